@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/gateway"
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func VoidOrderItem() gin.HandlerFunc {
+	return adjustOrderItem("VOID", "VOIDED")
+}
+
+func CompOrderItem() gin.HandlerFunc {
+	return adjustOrderItem("COMP", "COMPED")
+}
+
+// adjustOrderItem moves an active order item into the given status. The item
+// is kept in the collection and the manager-approved adjustment is appended
+// to its history.
+func adjustOrderItem(action string, status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderItemID := c.Param("order_item_id")
+
+		request, ok := bindAdjustmentRequest(c)
+		if !ok {
+			return
+		}
+
+		var orderItem models.OrderItem
+		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemID}).Decode(&orderItem)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "OrderItem not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order item details"})
+			return
+		}
+		if orderItem.Status != nil && *orderItem.Status != "ACTIVE" {
+			c.JSON(http.StatusConflict, gin.H{"error": "order item is already " + strings.ToLower(*orderItem.Status)})
+			return
+		}
+
+		// lowering the bill under money already taken would leave it paid
+		// for more than it is; that is what refunds are for
+		taken, err := orderHasPayments(ctx, orderItem.OrderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching payment details"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "order already has payments, refund them with a credit note instead"})
+			return
+		}
+
+		adjustment, ok := approveAdjustment(c, action, request)
+		if !ok {
+			return
+		}
+
+		result, err := orderItemCollection.UpdateOne(
+			ctx,
+			bson.M{"order_item_id": orderItemID, "status": bson.M{"$nin": []string{"VOIDED", "COMPED"}}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: status},
+					{Key: "updated_at", Value: adjustment.CreatedAt},
+				}},
+				{Key: "$push", Value: bson.D{{Key: "adjustments", Value: adjustment}}},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order item " + strings.ToLower(action) + " failed"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "order item was changed by another request"})
+			return
+		}
+		if err := rebillOrderInvoices(ctx, orderItem.OrderID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice could not be billed again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_item_id": orderItemID, "status": status, "adjustment": adjustment})
+	}
+}
+
+// CancelOrder cancels an order and voids every line that is still active.
+func CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+
+		request, ok := bindAdjustmentRequest(c)
+		if !ok {
+			return
+		}
+
+		var order models.Order
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order details"})
+			return
+		}
+		if order.Status != nil && *order.Status == "CANCELLED" {
+			c.JSON(http.StatusConflict, gin.H{"error": "order is already cancelled"})
+			return
+		}
+
+		// lowering the bill under money already taken would leave it paid
+		// for more than it is; that is what refunds are for
+		taken, err := orderHasPayments(ctx, orderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching payment details"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "order already has payments, refund them with a credit note instead"})
+			return
+		}
+
+		adjustment, ok := approveAdjustment(c, "CANCEL", request)
+		if !ok {
+			return
+		}
+
+		result, err := orderCollection.UpdateOne(
+			ctx,
+			bson.M{"order_id": orderID, "status": bson.M{"$ne": "CANCELLED"}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: "CANCELLED"},
+					{Key: "updated_at", Value: adjustment.CreatedAt},
+				}},
+				{Key: "$push", Value: bson.D{{Key: "adjustments", Value: adjustment}}},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order cancellation failed"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "order was changed by another request"})
+			return
+		}
+
+		itemAdjustment := adjustment
+		itemAdjustment.Action = "VOID"
+		itemAdjustment.Comment = strings.TrimSpace("order cancelled " + adjustment.Comment)
+		itemResult, err := orderItemCollection.UpdateMany(
+			ctx,
+			bson.M{"order_id": orderID, "status": bson.M{"$nin": []string{"VOIDED", "COMPED"}}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: "VOIDED"},
+					{Key: "updated_at", Value: adjustment.CreatedAt},
+				}},
+				{Key: "$push", Value: bson.D{{Key: "adjustments", Value: itemAdjustment}}},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order items could not be voided"})
+			return
+		}
+		if err := rebillOrderInvoices(ctx, orderID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice could not be billed again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"order_id":     orderID,
+			"status":       "CANCELLED",
+			"voided_items": itemResult.ModifiedCount,
+			"adjustment":   adjustment,
+		})
+	}
+}
+
+func bindAdjustmentRequest(c *gin.Context) (request models.AdjustmentRequest, ok bool) {
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, false
+	}
+	if validationErr := validate.Struct(request); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return request, false
+	}
+	return request, true
+}
+
+// approveAdjustment verifies the manager approval attached to the request and
+// builds the adjustment record. It writes the error response itself.
+func approveAdjustment(c *gin.Context, action string, request models.AdjustmentRequest) (adjustment models.Adjustment, ok bool) {
+	manager, msg := helper.VerifyManagerApproval(request.Approval)
+	if msg != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+		return adjustment, false
+	}
+
+	adjustment.Action = action
+	adjustment.ReasonCode = *request.ReasonCode
+	adjustment.Comment = request.Comment
+	adjustment.RequestedBy = c.GetString("uid")
+	adjustment.ApprovedBy = manager.UserID
+	adjustment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	return adjustment, true
+}
+
+func orderIsPaid(ctx context.Context, orderID string) (bool, error) {
+	count, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderID, "payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}}})
+	return count > 0, err
+}
+
+// orderHasPayments reports whether an order is paid or has any payment that
+// went through, or may still, on its invoices.
+func orderHasPayments(ctx context.Context, orderID string) (bool, error) {
+	paid, err := orderIsPaid(ctx, orderID)
+	if err != nil || paid {
+		return paid, err
+	}
+	count, err := paymentCollection.CountDocuments(ctx, bson.M{
+		"order_id": orderID,
+		"status":   bson.M{"$nin": bson.A{gateway.StatusDeclined, gateway.StatusVoided}},
+	})
+	return count > 0, err
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var auditCollection *mongo.Collection = database.OpenCollection(database.Client, "audit")
+
+// GetOrderAudit lists the audit trail of an order, including entries where
+// the order was the target of a move or merge.
+func GetOrderAudit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		result, err := auditCollection.Find(ctx, bson.M{"$or": []bson.M{
+			{"order_id": orderID},
+			{"target_order_id": orderID},
+		}}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the audit trail"})
+			return
+		}
+
+		entries := []models.AuditEntry{}
+		if err = result.All(ctx, &entries); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing the audit trail"})
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+func recordAudit(ctx context.Context, entry models.AuditEntry) error {
+	entry.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	entry.ID = primitive.NewObjectID()
+	entry.AuditID = entry.ID.Hex()
+	if entry.OrderItemIDs == nil {
+		entry.OrderItemIDs = []string{}
+	}
+	_, err := auditCollection.InsertOne(ctx, entry)
+	return err
+}
@@ -0,0 +1,423 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var categoryCollection *mongo.Collection = database.OpenCollection(database.Client, "category")
+
+// MenuTree is a menu with its categories nested as they are shown. Foods
+// not in any category are listed at the top level.
+type MenuTree struct {
+	MenuID     string         `json:"menu_id"`
+	Name       string         `json:"name"`
+	Category   string         `json:"category"`
+	Available  bool           `json:"available"`
+	Categories []CategoryNode `json:"categories"`
+	Foods      []models.Food  `json:"foods"`
+}
+
+type CategoryNode struct {
+	CategoryID string         `json:"category_id"`
+	Name       string         `json:"name"`
+	SortOrder  int            `json:"sort_order"`
+	Categories []CategoryNode `json:"categories"`
+	Foods      []models.Food  `json:"foods"`
+}
+
+func GetCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if menuID := c.Query("menu_id"); menuID != "" {
+			filter["menu_id"] = menuID
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "menu_id", Value: 1}, {Key: "sort_order", Value: 1}, {Key: "name", Value: 1}})
+		result, err := categoryCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing categories"})
+			return
+		}
+
+		allCategories := []models.Category{}
+		if err = result.All(ctx, &allCategories); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing categories"})
+			return
+		}
+		c.JSON(http.StatusOK, allCategories)
+	}
+}
+
+func GetCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var category models.Category
+
+		err := categoryCollection.FindOne(ctx, bson.M{"category_id": c.Param("category_id")}).Decode(&category)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching category details"})
+			return
+		}
+		c.JSON(http.StatusOK, category)
+	}
+}
+
+// CreateCategory adds a category to a menu, under parent_id when given.
+// Without a sort_order it goes after its siblings.
+func CreateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var category models.Category
+
+		if err := c.BindJSON(&category); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		validationErr := validate.Struct(category)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := menuCollection.CountDocuments(ctx, bson.M{"menu_id": category.MenuID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching menu details"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Menu not found"})
+			return
+		}
+		if category.ParentID != nil && *category.ParentID == "" {
+			category.ParentID = nil
+		}
+		if category.ParentID != nil {
+			if status, msg := checkCategoryParent(ctx, "", *category.MenuID, *category.ParentID); msg != "" {
+				c.JSON(status, gin.H{"error": msg})
+				return
+			}
+		}
+
+		if category.SortOrder == nil {
+			siblings, err := categoryCollection.CountDocuments(ctx, bson.M{"menu_id": category.MenuID, "parent_id": category.ParentID})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching category details"})
+				return
+			}
+			sortOrder := int(siblings)
+			category.SortOrder = &sortOrder
+		}
+		category.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		category.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		category.ID = primitive.NewObjectID()
+		category.CategoryID = category.ID.Hex()
+
+		result, err := categoryCollection.InsertOne(ctx, category)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Category was not created"})
+			return
+		}
+		c.JSON(http.StatusCreated, result)
+	}
+}
+
+// UpdateCategory renames, reorders or moves a category. An empty parent_id
+// moves it to the top level. A category stays in its menu.
+func UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var category models.Category
+		var existing models.Category
+		categoryID := c.Param("category_id")
+
+		if err := c.BindJSON(&category); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		err := categoryCollection.FindOne(ctx, bson.M{"category_id": categoryID}).Decode(&existing)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching category details"})
+			return
+		}
+		if category.MenuID != nil && *category.MenuID != *existing.MenuID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a category can't be moved to another menu"})
+			return
+		}
+
+		var updateObj primitive.D
+		if category.Name != nil {
+			if len(*category.Name) < 2 || len(*category.Name) > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name must be between 2 and 100 characters"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "name", Value: category.Name})
+		}
+		if category.SortOrder != nil {
+			if *category.SortOrder < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "sort_order can't be negative"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "sort_order", Value: category.SortOrder})
+		}
+		if category.ParentID != nil {
+			if *category.ParentID == "" {
+				updateObj = append(updateObj, bson.E{Key: "parent_id", Value: nil})
+			} else {
+				if status, msg := checkCategoryParent(ctx, categoryID, *existing.MenuID, *category.ParentID); msg != "" {
+					c.JSON(status, gin.H{"error": msg})
+					return
+				}
+				updateObj = append(updateObj, bson.E{Key: "parent_id", Value: category.ParentID})
+			}
+		}
+
+		category.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: category.UpdatedAt})
+
+		result, err := categoryCollection.UpdateOne(
+			ctx,
+			bson.M{"category_id": categoryID},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "category update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// DeleteCategory removes a category that has no subcategories or foods left.
+func DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		categoryID := c.Param("category_id")
+
+		children, err := categoryCollection.CountDocuments(ctx, bson.M{"parent_id": categoryID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching category details"})
+			return
+		}
+		foods, err := foodCollection.CountDocuments(ctx, bson.M{"category_id": categoryID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching food details"})
+			return
+		}
+		if children > 0 || foods > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "category still has subcategories or foods"})
+			return
+		}
+
+		result, err := categoryCollection.DeleteOne(ctx, bson.M{"category_id": categoryID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "category delete failed"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetMenuTree returns a menu with its categories and foods nested and in
+// display order, for front-of-house tablets and the QR menu.
+func GetMenuTree() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		menu, ok := findMenu(c, ctx)
+		if !ok {
+			return
+		}
+		location, err := restaurantLocation(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching restaurant details"})
+			return
+		}
+
+		result, err := categoryCollection.Find(ctx, bson.M{"menu_id": menu.MenuID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing categories"})
+			return
+		}
+		var categories []models.Category
+		if err = result.All(ctx, &categories); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing categories"})
+			return
+		}
+		result, err = foodCollection.Find(ctx, bson.M{"menu_id": menu.MenuID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing food items"})
+			return
+		}
+		var foods []models.Food
+		if err = result.All(ctx, &foods); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing food items"})
+			return
+		}
+		if rates, err := displayRates(ctx); err == nil && len(rates) > 0 {
+			for i, food := range foods {
+				if food.Price != nil {
+					foods[i].DisplayPrices = displayPrices(*food.Price, rates)
+				}
+			}
+		}
+
+		tree := MenuTree{
+			MenuID:    menu.MenuID,
+			Name:      menu.Name,
+			Category:  menu.Category,
+			Available: helper.MenuAvailableAt(menu, time.Now(), location),
+		}
+		tree.Categories, tree.Foods = buildCategoryTree(categories, foods)
+		c.JSON(http.StatusOK, tree)
+	}
+}
+
+// buildCategoryTree nests categories under their parents and foods under
+// their categories, each level sorted by sort order and then name. The foods
+// returned are those in no known category.
+func buildCategoryTree(categories []models.Category, foods []models.Food) ([]CategoryNode, []models.Food) {
+	known := map[string]bool{}
+	for _, category := range categories {
+		known[category.CategoryID] = true
+	}
+	children := map[string][]models.Category{}
+	for _, category := range categories {
+		parent := ""
+		if category.ParentID != nil && known[*category.ParentID] {
+			parent = *category.ParentID
+		}
+		children[parent] = append(children[parent], category)
+	}
+	foodsIn := map[string][]models.Food{}
+	for _, food := range foods {
+		category := ""
+		if food.CategoryID != nil && known[*food.CategoryID] {
+			category = *food.CategoryID
+		}
+		foodsIn[category] = append(foodsIn[category], food)
+	}
+
+	var nest func(parent string) []CategoryNode
+	nest = func(parent string) []CategoryNode {
+		level := children[parent]
+		sort.SliceStable(level, func(i, j int) bool {
+			a, b := sortOrderOf(level[i].SortOrder), sortOrderOf(level[j].SortOrder)
+			if a != b {
+				return a < b
+			}
+			return strings.ToLower(*level[i].Name) < strings.ToLower(*level[j].Name)
+		})
+		nodes := []CategoryNode{}
+		for _, category := range level {
+			nodes = append(nodes, CategoryNode{
+				CategoryID: category.CategoryID,
+				Name:       *category.Name,
+				SortOrder:  sortOrderOf(category.SortOrder),
+				Categories: nest(category.CategoryID),
+				Foods:      sortFoods(foodsIn[category.CategoryID]),
+			})
+		}
+		return nodes
+	}
+	return nest(""), sortFoods(foodsIn[""])
+}
+
+// sortFoods orders foods by sort order, those without one last, and then
+// by name.
+func sortFoods(foods []models.Food) []models.Food {
+	if foods == nil {
+		return []models.Food{}
+	}
+	sort.SliceStable(foods, func(i, j int) bool {
+		a, b := sortOrderOf(foods[i].SortOrder), sortOrderOf(foods[j].SortOrder)
+		if a != b {
+			return a < b
+		}
+		return strings.ToLower(*foods[i].Name) < strings.ToLower(*foods[j].Name)
+	})
+	return foods
+}
+
+func sortOrderOf(sortOrder *int) int {
+	if sortOrder == nil {
+		return math.MaxInt
+	}
+	return *sortOrder
+}
+
+// checkCategoryParent makes sure a parent category exists in the same menu
+// and is not the category itself or one of its descendants.
+func checkCategoryParent(ctx context.Context, categoryID, menuID, parentID string) (int, string) {
+	for id := parentID; id != ""; {
+		if id == categoryID {
+			return http.StatusBadRequest, "a category can't be placed under itself"
+		}
+		var parent models.Category
+		err := categoryCollection.FindOne(ctx, bson.M{"category_id": id}).Decode(&parent)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return http.StatusNotFound, "parent category " + id + " not found"
+			}
+			return http.StatusInternalServerError, "Error in fetching category details"
+		}
+		if *parent.MenuID != menuID {
+			return http.StatusBadRequest, "parent category " + id + " is on another menu"
+		}
+		id = ""
+		if parent.ParentID != nil {
+			id = *parent.ParentID
+		}
+	}
+	return 0, ""
+}
+
+// checkFoodCategory makes sure a food's category is on the food's menu.
+func checkFoodCategory(ctx context.Context, categoryID, menuID string) (int, string) {
+	var category models.Category
+	err := categoryCollection.FindOne(ctx, bson.M{"category_id": categoryID}).Decode(&category)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return http.StatusNotFound, "Category " + categoryID + " not found"
+		}
+		return http.StatusInternalServerError, "Error in fetching category details"
+	}
+	if *category.MenuID != menuID {
+		return http.StatusBadRequest, "category " + *category.Name + " is on another menu"
+	}
+	return 0, ""
+}
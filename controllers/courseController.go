@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// autoFireDelay is how long after a course is fired the next held course is
+// fired automatically. It is read from COURSE_AUTO_FIRE_DELAY, e.g. "15m";
+// when unset the next course waits for a manual fire.
+func autoFireDelay() time.Duration {
+	delay, err := time.ParseDuration(os.Getenv("COURSE_AUTO_FIRE_DELAY"))
+	if err != nil || delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// FireCourse releases the held items of an order to the kitchen. With
+// ?course=N only that course is fired; with ?delay=10m the course is
+// scheduled to fire later instead.
+func FireCourse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+
+		course := 0
+		if c.Query("course") != "" {
+			var err error
+			course, err = strconv.Atoi(c.Query("course"))
+			if err != nil || course < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "course must be a positive number"})
+				return
+			}
+		}
+
+		var order models.Order
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order details"})
+			return
+		}
+		if order.Status != nil && *order.Status == "CANCELLED" {
+			c.JSON(http.StatusConflict, gin.H{"error": "order is cancelled"})
+			return
+		}
+
+		if c.Query("delay") != "" {
+			delay, err := time.ParseDuration(c.Query("delay"))
+			if err != nil || delay <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "delay must be a positive duration such as 10m"})
+				return
+			}
+			fireAt := time.Now().Add(delay)
+			scheduled, err := scheduleCourse(ctx, orderID, course, fireAt, true)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "course could not be scheduled"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"order_id": orderID, "course": course, "scheduled": scheduled, "fire_at": fireAt})
+			return
+		}
+
+		fired, err := fireCourse(ctx, orderID, course)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "course could not be fired"})
+			return
+		}
+		if fired == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "no held items to fire"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID, "course": course, "fired": fired})
+	}
+}
+
+// fireCourse releases the held items of one course (or of every course when
+// course is 0) and prints them for the kitchen. When an auto-fire delay is
+// configured the next held course is scheduled after it.
+func fireCourse(ctx context.Context, orderID string, course int) (int64, error) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	filter := bson.M{"order_id": orderID, "hold": true, "status": bson.M{"$ne": "VOIDED"}}
+	if course > 0 {
+		filter["course"] = course
+	}
+	held, err := orderItemCollection.Distinct(ctx, "order_item_id", filter)
+	if err != nil {
+		return 0, err
+	}
+	var heldIDs []string
+	for _, id := range held {
+		if orderItemID, ok := id.(string); ok {
+			heldIDs = append(heldIDs, orderItemID)
+		}
+	}
+	if len(heldIDs) == 0 {
+		return 0, nil
+	}
+	filter["order_item_id"] = bson.M{"$in": heldIDs}
+	result, err := orderItemCollection.UpdateMany(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "hold", Value: false},
+			{Key: "fire_at", Value: nil},
+			{Key: "fired_at", Value: now},
+			{Key: "updated_at", Value: now},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	autoPrintKitchenTicket(orderID, heldIDs)
+
+	if delay := autoFireDelay(); delay > 0 && course > 0 && result.ModifiedCount > 0 {
+		if err := scheduleNextCourse(ctx, orderID, course, now.Add(delay)); err != nil {
+			return result.ModifiedCount, err
+		}
+	}
+	return result.ModifiedCount, nil
+}
+
+// scheduleNextCourse schedules the first held course after afterCourse, unless
+// it already has a fire time.
+func scheduleNextCourse(ctx context.Context, orderID string, afterCourse int, fireAt time.Time) error {
+	var next models.OrderItem
+	opts := options.FindOne().SetSort(bson.D{{Key: "course", Value: 1}})
+	err := orderItemCollection.FindOne(ctx, bson.M{
+		"order_id": orderID,
+		"hold":     true,
+		"status":   bson.M{"$ne": "VOIDED"},
+		"course":   bson.M{"$gt": afterCourse},
+	}, opts).Decode(&next)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = scheduleCourse(ctx, orderID, *next.Course, fireAt, false)
+	return err
+}
+
+// scheduleCourse sets the time at which the held items of a course fire. An
+// existing schedule is only replaced when override is set.
+func scheduleCourse(ctx context.Context, orderID string, course int, fireAt time.Time, override bool) (int64, error) {
+	filter := bson.M{"order_id": orderID, "hold": true, "status": bson.M{"$ne": "VOIDED"}}
+	if course > 0 {
+		filter["course"] = course
+	}
+	if !override {
+		filter["fire_at"] = nil
+	}
+	result, err := orderItemCollection.UpdateMany(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "fire_at", Value: fireAt}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// AutoFireCourses fires every held course whose scheduled time has passed. It
+// is run periodically by the background scheduler.
+func AutoFireCourses(ctx context.Context) error {
+	result, err := orderItemCollection.Find(ctx, bson.M{
+		"hold":    true,
+		"status":  bson.M{"$ne": "VOIDED"},
+		"fire_at": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	var dueItems []models.OrderItem
+	if err = result.All(ctx, &dueItems); err != nil {
+		return err
+	}
+
+	type orderCourse struct {
+		orderID string
+		course  int
+	}
+	seen := map[orderCourse]bool{}
+	for _, item := range dueItems {
+		key := orderCourse{item.OrderID, 1}
+		if item.Course != nil {
+			key.course = *item.Course
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, err := fireCourse(ctx, key.orderID, key.course); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCourseDefaults puts a new order item on course 1 unless told otherwise
+// and stamps it as fired when it is not held. A fire_at is only kept for held
+// items.
+func setCourseDefaults(orderItem *models.OrderItem, now time.Time) {
+	if orderItem.Course == nil {
+		course := 1
+		orderItem.Course = &course
+	}
+	if orderItem.Hold == nil {
+		hold := false
+		orderItem.Hold = &hold
+	}
+	orderItem.FiredAt = nil
+	if !*orderItem.Hold {
+		orderItem.FireAt = nil
+		orderItem.FiredAt = &now
+	}
+}
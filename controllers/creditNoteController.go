@@ -0,0 +1,384 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/gateway"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var creditNoteCollection *mongo.Collection = database.OpenCollection(database.Client, "creditNote")
+
+func GetInvoiceCreditNotes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		creditNotes, err := invoiceCreditNotes(ctx, c.Param("invoice_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing credit notes"})
+			return
+		}
+		c.JSON(http.StatusOK, creditNotes)
+	}
+}
+
+func GetCreditNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		creditNoteID := c.Param("credit_note_id")
+		var creditNote models.CreditNote
+
+		err := creditNoteCollection.FindOne(ctx, bson.M{"credit_note_id": creditNoteID}).Decode(&creditNote)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Credit note not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching credit note details"})
+			return
+		}
+		c.JSON(http.StatusOK, creditNote)
+	}
+}
+
+// RefundInvoice issues a credit note against the money received on an
+// invoice. Refunding order_item_ids credits those lines as they were billed,
+// after discounts and with their exclusive taxes; an amount refunds part of
+// the invoice; neither refunds everything not refunded yet. Every refund
+// needs a reason and a manager's approval.
+func RefundInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		invoiceID := c.Param("invoice_id")
+		var request models.RefundRequest
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if len(request.OrderItemIDs) > 0 && request.Amount != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refund either order_item_ids or an amount, not both"})
+			return
+		}
+
+		count, err := invoiceCollection.CountDocuments(ctx, bson.M{"invoice_id": invoiceID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+			return
+		}
+		// refunds hold the invoice like payments do, so two refunds can't
+		// both take what is left to refund
+		invoice, unlock, err := lockInvoicePayments(ctx, invoiceID)
+		if err != nil {
+			if err == errInvoiceBusy {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		defer unlock()
+
+		payments, err := invoicePayments(ctx, invoiceID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching payment details"})
+			return
+		}
+		creditNotes, err := invoiceCreditNotes(ctx, invoiceID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching credit note details"})
+			return
+		}
+		paid, refunded := paidCents(payments), refundedCents(creditNotes)
+		refundable := paid - refunded
+		if refundable <= 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "nothing is left to refund on this invoice"})
+			return
+		}
+
+		var lines []models.CreditNoteLine
+		amountCents := refundable
+		if len(request.OrderItemIDs) > 0 {
+			lines, amountCents, err = refundLines(ctx, invoice, creditNotes, request.OrderItemIDs)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		} else if request.Amount != nil {
+			amountCents = request.Amount.Cents
+		}
+		if amountCents > refundable {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refund is more than what is left to refund", "refundable": money.Of(refundable)})
+			return
+		}
+		if amountCents <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refund amount must be positive"})
+			return
+		}
+
+		method := paymentMethodOf(payments)
+		if request.Method != nil {
+			method = *request.Method
+		}
+		if method != "CASH" && method != "CARD" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "method is required when the invoice was paid with several methods"})
+			return
+		}
+		if method == "CARD" {
+			if cardCents := cardRefundableCents(payments); amountCents > cardCents {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "refund is more than what is left to refund on card payments", "refundable": money.Of(cardCents)})
+				return
+			}
+		}
+
+		adjustment, ok := approveAdjustment(c, "REFUND", request.AdjustmentRequest)
+		if !ok {
+			return
+		}
+
+		var creditNote models.CreditNote
+		creditNote.ID = primitive.NewObjectID()
+		creditNote.CreditNoteID = creditNote.ID.Hex()
+		creditNote.InvoiceID = invoiceID
+		creditNote.OrderID = invoice.OrderID
+		creditNote.Lines = lines
+		if creditNote.Lines == nil {
+			creditNote.Lines = []models.CreditNoteLine{}
+		}
+		creditNote.Amount = money.Of(amountCents)
+		creditNote.Method = method
+		creditNote.Adjustment = adjustment
+		creditNote.CreatedAt = adjustment.CreatedAt
+		creditNote.Status = "COMPLETED"
+		if method == "CARD" {
+			// the credit note is written before money moves, so a refund
+			// the provider makes is never left unrecorded
+			creditNote.Status = "PENDING"
+		}
+
+		if _, err := creditNoteCollection.InsertOne(ctx, creditNote); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Credit note was not created"})
+			return
+		}
+		if method == "CARD" {
+			refunds, refundErr := refundCardPayments(ctx, payments, amountCents, creditNote.CreditNoteID)
+			creditNote.ProviderRefunds = refunds
+			creditNote.Status = "COMPLETED"
+			if refundErr != nil {
+				// only what went through is kept; the lines are left to be
+				// refunded again
+				creditNote.Amount = money.Of(providerRefundCents(refunds))
+				creditNote.Lines = []models.CreditNoteLine{}
+				creditNote.Status = "FAILED"
+				if creditNote.Amount.Cents > 0 {
+					creditNote.Status = "PARTIAL"
+				}
+			}
+			if err := completeCreditNote(ctx, creditNote); err != nil {
+				log.Printf("refunds: credit note %s left pending, provider refunds %v: %v", creditNote.CreditNoteID, refunds, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "credit note could not be completed", "credit_note": creditNote})
+				return
+			}
+			if refundErr != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider error: " + refundErr.Error(), "credit_note": creditNote})
+				return
+			}
+		}
+		if refunded+amountCents == paid {
+			_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoiceID}, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "payment_status", Value: "REFUNDED"},
+					{Key: "updated_at", Value: adjustment.CreatedAt},
+				}},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice status update failed"})
+				return
+			}
+		}
+		c.JSON(http.StatusCreated, creditNote)
+	}
+}
+
+// refundLines credits the order items being refunded as they were billed
+// on the invoice. Items refunded before can't be refunded again.
+func refundLines(ctx context.Context, invoice models.Invoice, creditNotes []models.CreditNote, orderItemIDs []string) ([]models.CreditNoteLine, int64, error) {
+	bill, err := invoiceBill(ctx, invoice)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	alreadyRefunded := map[string]bool{}
+	for _, creditNote := range creditNotes {
+		for _, line := range creditNote.Lines {
+			alreadyRefunded[line.OrderItemID] = true
+		}
+	}
+
+	var lines []models.CreditNoteLine
+	var total int64
+	seen := map[string]bool{}
+	for _, orderItemID := range orderItemIDs {
+		if seen[orderItemID] {
+			continue
+		}
+		seen[orderItemID] = true
+		if alreadyRefunded[orderItemID] {
+			return nil, 0, fmt.Errorf("order item %s is already refunded", orderItemID)
+		}
+		amount, billed := bill.LineTotals[orderItemID]
+		if !billed {
+			return nil, 0, fmt.Errorf("order item %s is not billed on this invoice", orderItemID)
+		}
+		foodName := ""
+		for _, line := range bill.OrderDetails {
+			if line.OrderItemID == orderItemID {
+				foodName = line.FoodName
+			}
+		}
+		lines = append(lines, models.CreditNoteLine{OrderItemID: orderItemID, FoodName: foodName, Amount: amount})
+		total += amount.Cents
+	}
+	return lines, total, nil
+}
+
+// refundCardPayments sends a card refund back through the provider, spread
+// over the captured card payments in the order they were taken. Card
+// payments recorded without a provider were taken at a terminal, so what is
+// left is refunded there and only counted against them. It returns the
+// provider refunds made, even when a later one fails, and an error when the
+// card payments can't cover the whole refund.
+func refundCardPayments(ctx context.Context, payments []models.Payment, amountCents int64, creditNoteID string) ([]models.ProviderRefund, error) {
+	refunds := []models.ProviderRefund{}
+	remaining := amountCents
+	for _, throughProvider := range []bool{true, false} {
+		for _, payment := range payments {
+			if remaining == 0 {
+				break
+			}
+			if (payment.TransactionID != "") != throughProvider {
+				continue
+			}
+			part := cardRefundable(payment)
+			if part > remaining {
+				part = remaining
+			}
+			if part <= 0 {
+				continue
+			}
+
+			if throughProvider {
+				provider, err := gateway.Get(payment.Provider)
+				if err != nil {
+					return refunds, err
+				}
+				if _, err := provider.Refund(ctx, payment.TransactionID, part, creditNoteID+":"+payment.PaymentID); err != nil {
+					return refunds, err
+				}
+				refunds = append(refunds, models.ProviderRefund{
+					PaymentID:     payment.PaymentID,
+					Provider:      payment.Provider,
+					TransactionID: payment.TransactionID,
+					Amount:        money.Of(part),
+				})
+			}
+			_, err := paymentCollection.UpdateOne(ctx, bson.M{"payment_id": payment.PaymentID}, bson.D{
+				{Key: "$set", Value: bson.D{{Key: "refunded_amount", Value: money.Of(payment.RefundedAmount.Cents + part)}}},
+			})
+			if err != nil {
+				return refunds, err
+			}
+			remaining -= part
+		}
+	}
+	if remaining > 0 {
+		return refunds, fmt.Errorf("card payments are %s short of the refund", money.Of(remaining))
+	}
+	return refunds, nil
+}
+
+// cardRefundable is what is left to refund on a captured card payment.
+func cardRefundable(payment models.Payment) int64 {
+	if payment.Method == nil || *payment.Method != "CARD" || payment.Status != gateway.StatusCaptured || payment.Amount == nil {
+		return 0
+	}
+	return payment.Amount.Cents - payment.RefundedAmount.Cents
+}
+
+func cardRefundableCents(payments []models.Payment) int64 {
+	var cents int64
+	for _, payment := range payments {
+		if part := cardRefundable(payment); part > 0 {
+			cents += part
+		}
+	}
+	return cents
+}
+
+func providerRefundCents(refunds []models.ProviderRefund) int64 {
+	var cents int64
+	for _, refund := range refunds {
+		cents += refund.Amount.Cents
+	}
+	return cents
+}
+
+// completeCreditNote stores how a pending card refund ended.
+func completeCreditNote(ctx context.Context, creditNote models.CreditNote) error {
+	_, err := creditNoteCollection.UpdateOne(ctx, bson.M{"credit_note_id": creditNote.CreditNoteID}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: creditNote.Status},
+			{Key: "amount", Value: creditNote.Amount},
+			{Key: "lines", Value: creditNote.Lines},
+			{Key: "provider_refunds", Value: creditNote.ProviderRefunds},
+		}},
+	})
+	return err
+}
+
+func invoiceCreditNotes(ctx context.Context, invoiceID string) ([]models.CreditNote, error) {
+	creditNotes := []models.CreditNote{}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	result, err := creditNoteCollection.Find(ctx, bson.M{"invoice_id": invoiceID}, opts)
+	if err != nil {
+		return creditNotes, err
+	}
+	err = result.All(ctx, &creditNotes)
+	return creditNotes, err
+}
+
+func refundedCents(creditNotes []models.CreditNote) int64 {
+	var cents int64
+	for _, creditNote := range creditNotes {
+		cents += creditNote.Amount.Cents
+	}
+	return cents
+}
+
+// invoiceIsSettled reports whether an invoice is closed to changes other than
+// refunds. Voided invoices, replaced by a split, are closed to everything.
+func invoiceIsSettled(status *string) bool {
+	return status != nil && (*status == "PAID" || *status == "REFUNDED" || *status == "VOIDED")
+}
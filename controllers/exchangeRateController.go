@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var exchangeRateCollection *mongo.Collection = database.OpenCollection(database.Client, "exchangeRate")
+
+func GetExchangeRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "currency", Value: 1}})
+		result, err := exchangeRateCollection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing exchange rates"})
+			return
+		}
+
+		allExchangeRates := []models.ExchangeRate{}
+		if err = result.All(ctx, &allExchangeRates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing exchange rates"})
+			return
+		}
+		c.JSON(http.StatusOK, allExchangeRates)
+	}
+}
+
+func GetExchangeRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var exchangeRate models.ExchangeRate
+
+		err := exchangeRateCollection.FindOne(ctx, bson.M{"currency": strings.ToUpper(c.Param("currency"))}).Decode(&exchangeRate)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Exchange rate not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching exchange rate details"})
+			return
+		}
+		c.JSON(http.StatusOK, exchangeRate)
+	}
+}
+
+// SetExchangeRate sets what one unit of a currency is worth in the base
+// currency, adding the currency the first time. Only managers can change
+// rates.
+func SetExchangeRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var exchangeRate models.ExchangeRate
+
+		if err := c.BindJSON(&exchangeRate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(exchangeRate); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		currency, ok := currencyCode(c.Param("currency"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three letter ISO 4217 code"})
+			return
+		}
+		if currency == money.DefaultCurrency() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": currency + " is the base currency"})
+			return
+		}
+		if !requireManager(c, ctx, "only a manager can change exchange rates") {
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		id := primitive.NewObjectID()
+		upsert := true
+		opt := options.UpdateOptions{Upsert: &upsert}
+		_, err := exchangeRateCollection.UpdateOne(
+			ctx,
+			bson.M{"currency": currency},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "rate", Value: exchangeRate.Rate},
+					{Key: "updated_by", Value: c.GetString("uid")},
+					{Key: "updated_at", Value: updatedAt},
+				}},
+				{Key: "$setOnInsert", Value: bson.D{
+					{Key: "_id", Value: id},
+					{Key: "exchange_rate_id", Value: id.Hex()},
+					{Key: "created_at", Value: updatedAt},
+				}},
+			},
+			&opt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "exchange rate update failed"})
+			return
+		}
+
+		err = exchangeRateCollection.FindOne(ctx, bson.M{"currency": currency}).Decode(&exchangeRate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching exchange rate details"})
+			return
+		}
+		c.JSON(http.StatusOK, exchangeRate)
+	}
+}
+
+func DeleteExchangeRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if !requireManager(c, ctx, "only a manager can change exchange rates") {
+			return
+		}
+		result, err := exchangeRateCollection.DeleteOne(ctx, bson.M{"currency": strings.ToUpper(c.Param("currency"))})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "exchange rate delete failed"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Exchange rate not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// requireManager answers with 403 and returns false unless the caller is a
+// manager or an admin.
+func requireManager(c *gin.Context, ctx context.Context, msg string) bool {
+	caller, err := helper.UserByID(ctx, c.GetString("uid"))
+	if err != nil || !helper.IsManager(caller) {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+		return false
+	}
+	return true
+}
+
+// baseCurrencyError is the message for an amount sent in a currency other
+// than the base currency, which prices, fees and promotions must be in.
+func baseCurrencyError(field string, amount *money.Money) string {
+	if amount == nil || amount.In(money.DefaultCurrency()) {
+		return ""
+	}
+	return field + " must be in the base currency " + money.DefaultCurrency()
+}
+
+// currencyCode upper-cases a currency code and checks it has three letters.
+func currencyCode(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return code, false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return code, false
+		}
+	}
+	return code, true
+}
+
+// exchangeRateOf is the rate of a currency from the exchange rate table and
+// the same rate exactly, for converting with.
+func exchangeRateOf(ctx context.Context, currency string) (float64, *big.Rat, error) {
+	var exchangeRate models.ExchangeRate
+	err := exchangeRateCollection.FindOne(ctx, bson.M{"currency": currency}).Decode(&exchangeRate)
+	if err != nil {
+		return 0, nil, err
+	}
+	return *exchangeRate.Rate, helper.RatFromFloat(*exchangeRate.Rate), nil
+}
+
+// displayRate is the rate to show base currency prices in a display
+// currency with.
+type displayRate struct {
+	Currency string
+	Rate     *big.Rat
+}
+
+// displayRates are the rates of the restaurant's display currencies, skipping
+// those without a rate.
+func displayRates(ctx context.Context) ([]displayRate, error) {
+	rates := []displayRate{}
+	restaurant, err := restaurantSettings(ctx)
+	if err != nil {
+		return rates, err
+	}
+	for _, currency := range restaurant.DisplayCurrencies {
+		_, rate, err := exchangeRateOf(ctx, currency)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, displayRate{Currency: currency, Rate: rate})
+	}
+	return rates, nil
+}
+
+// displayPrices is a base currency price in each display currency. Rates
+// give the base currency value of a foreign unit, so prices are divided by
+// them.
+func displayPrices(price money.Money, rates []displayRate) []money.Money {
+	prices := []money.Money{}
+	for _, rate := range rates {
+		prices = append(prices, price.Convert(rate.Currency, new(big.Rat).Inv(rate.Rate)))
+	}
+	return prices
+}
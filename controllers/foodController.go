@@ -3,14 +3,14 @@ package controller
 import (
 	"context"
 	"fmt"
-	"log"
-	"math"
 	"net/http"
-	"strconv"
+	"reflect"
+	"strings"
 	"time"
 
 	"atm1504.in/rms/database"
 	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -22,53 +22,118 @@ import (
 
 var foodCollection *mongo.Collection = database.OpenCollection(database.Client, "food")
 
-var validate = validator.New()
+var validate = newValidator()
+
+// newValidator returns the validator used for request bodies. Money is
+// checked by its amount in cents, so tags like gt=0 work on it as on numbers.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		return field.Interface().(money.Money).Cents
+	}, money.Money{})
+	v.RegisterValidation("allergen", oneOfList(models.Allergens))
+	v.RegisterValidation("dietary_tag", oneOfList(models.DietaryTags))
+	return v
+}
+
+// oneOfList validates that a string is one of the values listed.
+func oneOfList(values []string) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return containsString(values, fl.Field().String())
+	}
+}
+
+// foodListSpec is what GET /foods can be filtered and sorted on. q searches
+// food names with the text index.
+var foodListSpec = listSpec{
+	filters: []listFilter{
+		{param: "menu_id", field: "menu_id"},
+		{param: "category_id", field: "category_id"},
+	},
+	priceField: "price",
+	dateField:  "created_at",
+	textSearch: true,
+	sorts: map[string]string{
+		"name":       "name",
+		"price":      "price.cents",
+		"sort_order": "sort_order",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	defaultSort: "created_at",
+}
 
 func GetFoods() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
-		}
+		defer cancel()
 
-		page, err := strconv.Atoi(c.Query("page"))
-		if err != nil || page < 1 {
-			page = 1
+		query, ok := listRequest(c, ctx, foodListSpec)
+		if !ok {
+			return
+		}
+		filter, msg := foodFilter(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		for key, value := range filter {
+			query.Filter[key] = value
 		}
 
-		startIndex := (page - 1) * recordPerPage
-		// startIndex, _ := strconv.Atoi(c.Query("startIndex"))
-
-		matchStage := bson.D{{Key: "$match", Value: bson.D{{}}}}
-		groupStage := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "_id", Value: "null"}}}, {Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}}, {Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}}}}}
-		projectStage := bson.D{
-			{
-				Key: "$project", Value: bson.D{
-					{Key: "_id", Value: 0},
-					{Key: "total_count", Value: 1},
-					{Key: "food_items", Value: bson.D{{Key: "$slice", Value: []interface{}{"$data", startIndex, recordPerPage}}}},
-				}}}
-
-		result, err := foodCollection.Aggregate(ctx, mongo.Pipeline{
-			matchStage, groupStage, projectStage})
-		defer cancel()
+		allFoods := []models.Food{}
+		page, err := findPage(ctx, foodCollection, query, &allFoods, nil)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing food items"})
+			return
 		}
-		var allFoods []bson.M
-		if err = result.All(ctx, &allFoods); err != nil {
-			log.Fatal(err)
+		if rates, err := displayRates(ctx); err == nil && len(rates) > 0 {
+			for i, food := range allFoods {
+				if food.Price != nil {
+					allFoods[i].DisplayPrices = displayPrices(*food.Price, rates)
+				}
+			}
+		}
+		c.JSON(http.StatusOK, pageResponse("food_items", allFoods, query, page))
+	}
+}
+
+// foodFilter reads the allergen and diet filters of GET /foods.
+// ?allergen_free=MILK,PEANUTS leaves out foods containing any of the
+// allergens, and foods whose allergens were never declared, and
+// ?dietary=VEGAN,GLUTEN_FREE keeps foods tagged with all of the diets.
+func foodFilter(c *gin.Context) (bson.M, string) {
+	filter := bson.M{}
+	if value := c.Query("allergen_free"); value != "" {
+		allergens := upperList(value)
+		for _, allergen := range allergens {
+			if !containsString(models.Allergens, allergen) {
+				return filter, allergen + " is not a known allergen"
+			}
 		}
-		// c.JSON(http.StatusOK, allFoods[0])
-		// Assuming you want to return the list of menus directly
-		if len(allFoods) > 0 {
-			c.JSON(http.StatusOK, allFoods[0])
-		} else {
-			c.JSON(http.StatusOK, []interface{}{}) // Return an empty array if no menus
+		filter["allergens"] = bson.M{"$type": "array", "$nin": allergens}
+	}
+	if value := c.Query("dietary"); value != "" {
+		tags := upperList(value)
+		for _, tag := range tags {
+			if !containsString(models.DietaryTags, tag) {
+				return filter, tag + " is not a known dietary tag"
+			}
 		}
+		filter["dietary_tags"] = bson.M{"$all": tags}
+	}
+	return filter, ""
+}
 
+// upperList splits a comma separated query value into upper-cased items.
+func upperList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToUpper(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
 
 func GetFood() gin.HandlerFunc {
@@ -90,6 +155,9 @@ func GetFood() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"erroe": "Error in fetching product"})
 			return
 		}
+		if rates, err := displayRates(ctx); err == nil && len(rates) > 0 && food.Price != nil {
+			food.DisplayPrices = displayPrices(*food.Price, rates)
+		}
 		c.JSON(http.StatusOK, food)
 	}
 }
@@ -112,6 +180,11 @@ func CreateFood() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
+		if msg := baseCurrencyError("price", food.Price); msg != "" {
+			defer cancel()
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
 
 		err := menuCollection.FindOne(ctx, bson.M{"menu_id": food.MenuID}).Decode(&menu)
 		defer cancel()
@@ -126,13 +199,47 @@ func CreateFood() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching menu details"})
 			return
 		}
+		if menu.EndDate != nil && !menu.EndDate.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "menu " + menu.Name + " has ended"})
+			return
+		}
+
+		if food.CategoryID != nil && *food.CategoryID == "" {
+			food.CategoryID = nil
+		}
+		if food.CategoryID != nil {
+			if status, msg := checkFoodCategory(ctx, *food.CategoryID, *food.MenuID); msg != "" {
+				c.JSON(status, gin.H{"error": msg})
+				return
+			}
+		}
+
+		unknownTaxRate, err := checkTaxRateIDs(ctx, food.TaxRateIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching tax rate details"})
+			return
+		}
+		if unknownTaxRate != "" {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Tax rate " + unknownTaxRate + " not found"})
+			return
+		}
+		if food.TaxRateIDs == nil {
+			food.TaxRateIDs = []string{}
+		}
+		// a food without allergens must say so, or it would pass as free
+		// of every allergen
+		if food.Allergens == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "allergens is required, send [] for a food without any"})
+			return
+		}
+		if food.DietaryTags == nil {
+			food.DietaryTags = []string{}
+		}
 
 		food.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.ID = primitive.NewObjectID()
 		food.FoodID = food.ID.Hex()
-		var num = toFixed(*food.Price, 2)
-		food.Price = &num
 		result, inserErr := foodCollection.InsertOne(ctx, food)
 		defer cancel()
 		if inserErr != nil {
@@ -146,15 +253,6 @@ func CreateFood() gin.HandlerFunc {
 	}
 }
 
-func round(num float64) int {
-	return int(num + math.Copysign(0.5, num))
-}
-
-func toFixed(num float64, precision int) float64 {
-	output := math.Pow(10, float64(precision))
-	return float64(round(num*output)) / output
-}
-
 func UpdateFood() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -174,11 +272,58 @@ func UpdateFood() gin.HandlerFunc {
 			updateObj = append(updateObj, bson.E{Key: "name", Value: food.Name})
 		}
 		if food.Price != nil {
+			if msg := baseCurrencyError("price", food.Price); msg != "" {
+				defer cancel()
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
 			updateObj = append(updateObj, bson.E{Key: "price", Value: food.Price})
 		}
 
 		if food.FoodImage != nil {
+			// thumbnails belong to an uploaded image, not one linked to
 			updateObj = append(updateObj, bson.E{Key: "food_image", Value: food.FoodImage})
+			updateObj = append(updateObj, bson.E{Key: "thumbnails", Value: nil})
+		}
+
+		if food.Allergens != nil {
+			if err := validate.Var(food.Allergens, "dive,allergen"); err != nil {
+				defer cancel()
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "allergens", Value: food.Allergens})
+		}
+		if food.DietaryTags != nil {
+			if err := validate.Var(food.DietaryTags, "dive,dietary_tag"); err != nil {
+				defer cancel()
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "dietary_tags", Value: food.DietaryTags})
+		}
+		if food.Modifiers != nil {
+			if err := validate.Var(food.Modifiers, "dive"); err != nil {
+				defer cancel()
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "modifiers", Value: food.Modifiers})
+		}
+
+		if food.TaxRateIDs != nil {
+			unknownTaxRate, err := checkTaxRateIDs(ctx, food.TaxRateIDs)
+			if err != nil {
+				defer cancel()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching tax rate details"})
+				return
+			}
+			if unknownTaxRate != "" {
+				defer cancel()
+				c.JSON(http.StatusNotFound, gin.H{"message": "Tax rate " + unknownTaxRate + " not found"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "tax_rate_ids", Value: food.TaxRateIDs})
 		}
 
 		if food.MenuID != nil {
@@ -196,8 +341,52 @@ func UpdateFood() gin.HandlerFunc {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 				return
 			}
+			if menu.EndDate != nil && !menu.EndDate.After(time.Now()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "menu " + menu.Name + " has ended"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{Key: "menu_id", Value: food.MenuID})
 		}
+
+		// a food moved to another menu leaves its category behind unless
+		// it is given one there
+		if food.CategoryID != nil || food.MenuID != nil {
+			var existing models.Food
+			err := foodCollection.FindOne(ctx, bson.M{"food_id": foodID}).Decode(&existing)
+			if err != nil && err != mongo.ErrNoDocuments {
+				defer cancel()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching food details"})
+				return
+			}
+			menuID := food.MenuID
+			if menuID == nil {
+				menuID = existing.MenuID
+			}
+			switch {
+			case food.CategoryID != nil && *food.CategoryID != "":
+				if menuID == nil {
+					defer cancel()
+					c.JSON(http.StatusBadRequest, gin.H{"error": "a food needs a menu to have a category"})
+					return
+				}
+				if status, msg := checkFoodCategory(ctx, *food.CategoryID, *menuID); msg != "" {
+					defer cancel()
+					c.JSON(status, gin.H{"error": msg})
+					return
+				}
+				updateObj = append(updateObj, bson.E{Key: "category_id", Value: food.CategoryID})
+			case food.CategoryID != nil || (existing.MenuID != nil && *existing.MenuID != *food.MenuID):
+				updateObj = append(updateObj, bson.E{Key: "category_id", Value: nil})
+			}
+		}
+		if food.SortOrder != nil {
+			if *food.SortOrder < 0 {
+				defer cancel()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "sort_order can't be negative"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "sort_order", Value: food.SortOrder})
+		}
 		food.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: food.UpdatedAt})
 
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/imaging"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// defaultMaxImageBytes is the largest upload taken unless IMAGE_MAX_BYTES
+// says otherwise.
+const defaultMaxImageBytes = 5 << 20
+
+// thumbnailSizes are the thumbnails made of every food image, by the longest
+// side in pixels.
+var thumbnailSizes = []struct {
+	name string
+	size int
+}{
+	{"small", 160},
+	{"medium", 480},
+}
+
+// UploadFoodImage takes a food's image as the "image" field of a multipart
+// form, stores it with its thumbnails and points the food at them. Files are
+// named after their content, so their URLs never change meaning and can be
+// cached for good.
+func UploadFoodImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var food models.Food
+		foodID := c.Param("food_id")
+
+		err := foodCollection.FindOne(ctx, bson.M{"food_id": foodID}).Decode(&food)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Food not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching food details"})
+			return
+		}
+
+		limit := maxImageBytes()
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1<<20)
+		header, err := c.FormFile("image")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image must be at most " + strconv.FormatInt(limit, 10) + " bytes"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image must be sent as the image field of a multipart form"})
+			return
+		}
+		if header.Size > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image must be at most " + strconv.FormatInt(limit, 10) + " bytes"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(file, limit+1))
+		file.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if int64(len(data)) > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image must be at most " + strconv.FormatInt(limit, 10) + " bytes"})
+			return
+		}
+
+		img, contentType, err := imaging.Decode(data)
+		if err == imaging.ErrUnsupported {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image could not be read: " + err.Error()})
+			return
+		}
+
+		store, err := storage.Default()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "image storage is not configured"})
+			return
+		}
+		sum := sha256.Sum256(data)
+		prefix := "foods/" + food.FoodID + "/" + hex.EncodeToString(sum[:8])
+		imageKey := prefix + imaging.Formats[contentType]
+		if err := store.Put(ctx, imageKey, storage.Object{Data: data, ContentType: contentType}); err != nil {
+			log.Printf("images: storing %s failed: %v", imageKey, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "image was not stored"})
+			return
+		}
+		thumbnails := map[string]string{}
+		for _, thumbnail := range thumbnailSizes {
+			encoded, thumbnailType, err := imaging.Encode(imaging.Thumbnail(img, thumbnail.size), contentType)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "thumbnail could not be made"})
+				return
+			}
+			key := prefix + "-" + thumbnail.name + imaging.Formats[thumbnailType]
+			if err := store.Put(ctx, key, storage.Object{Data: encoded, ContentType: thumbnailType}); err != nil {
+				log.Printf("images: storing %s failed: %v", key, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "image was not stored"})
+				return
+			}
+			thumbnails[thumbnail.name] = imageURL(key)
+		}
+
+		previous := foodImageKeys(food)
+		imageLink := imageURL(imageKey)
+		food.FoodImage = &imageLink
+		food.Thumbnails = thumbnails
+		food.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = foodCollection.UpdateOne(ctx, bson.M{"food_id": food.FoodID}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "food_image", Value: food.FoodImage},
+				{Key: "thumbnails", Value: food.Thumbnails},
+				{Key: "updated_at", Value: food.UpdatedAt},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "food item update failed"})
+			return
+		}
+
+		current := foodImageKeys(food)
+		for _, key := range previous {
+			if containsString(current, key) {
+				continue
+			}
+			if err := store.Delete(ctx, key); err != nil {
+				log.Printf("images: removing %s failed: %v", key, err)
+			}
+		}
+		c.JSON(http.StatusOK, food)
+	}
+}
+
+// ServeImage serves stored images. Their names change with their content,
+// so they are cached for a year.
+func ServeImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		key := strings.TrimPrefix(c.Param("key"), "/")
+
+		etag := `"` + strings.TrimSuffix(path.Base(key), path.Ext(key)) + `"`
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		store, err := storage.Default()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "image storage is not configured"})
+			return
+		}
+		object, err := store.Get(ctx, key)
+		if err == storage.ErrNotFound || err == storage.ErrInvalidKey {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusNotFound, gin.H{"message": "Image not found"})
+			return
+		}
+		if err != nil {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching image"})
+			return
+		}
+		if object.ContentType == "" {
+			object.ContentType = http.DetectContentType(object.Data)
+		}
+		c.Data(http.StatusOK, object.ContentType, object.Data)
+	}
+}
+
+// imageURL is where a stored image is served, under IMAGE_BASE_URL when
+// images are served from a CDN.
+func imageURL(key string) string {
+	return imageBaseURL() + "/" + key
+}
+
+// foodImageKeys are the stored images a food points at. Images hosted
+// elsewhere are left out.
+func foodImageKeys(food models.Food) []string {
+	var keys []string
+	links := []string{}
+	if food.FoodImage != nil {
+		links = append(links, *food.FoodImage)
+	}
+	for _, link := range food.Thumbnails {
+		links = append(links, link)
+	}
+	for _, link := range links {
+		if key, ok := strings.CutPrefix(link, imageBaseURL()+"/"); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func imageBaseURL() string {
+	if base := os.Getenv("IMAGE_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "/images"
+}
+
+// maxImageBytes reads IMAGE_MAX_BYTES, the largest image upload taken.
+func maxImageBytes() int64 {
+	if value := os.Getenv("IMAGE_MAX_BYTES"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && limit > 0 {
+			return limit
+		}
+		log.Printf("images: ignoring IMAGE_MAX_BYTES %q", value)
+	}
+	return defaultMaxImageBytes
+}
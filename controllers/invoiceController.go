@@ -4,10 +4,13 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
 	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,40 +19,96 @@ import (
 )
 
 type InvoiceViewFormat struct {
-	InvoiceID      string      `bson:"invoice_id" json:"order_items"`
-	PaymentMethod  string      `bson:"payment_method" json:"payment_method"`
-	OrderID        string      `bson:"order_id" json:"order_id"`
-	PaymentStatus  *string     `bson:"payment_status" json:"payment_status"`
-	PaymentDue     interface{} `bson:"payment_due" json:"payment_due"`
-	TableNumber    interface{} `bson:"table_number" json:"table_number"`
-	PaymentDueDate time.Time   `bson:"payment_due_date" json:"payment_due_date"`
-	OrderDetails   interface{} `bson:"order_details" json:"order_details"`
+	InvoiceID      string  `bson:"invoice_id" json:"invoice_id"`
+	InvoiceNumber  string  `bson:"invoice_number" json:"invoice_number,omitempty"`
+	PaymentMethod  string  `bson:"payment_method" json:"payment_method"`
+	OrderID        string  `bson:"order_id" json:"order_id"`
+	PaymentStatus  *string `bson:"payment_status" json:"payment_status"`
+	InvoiceBill    `bson:",inline"`
+	TipTotal       money.Money         `bson:"tip_total" json:"tip_total"`
+	AmountPaid     money.Money         `bson:"amount_paid" json:"amount_paid"`
+	Balance        money.Money         `bson:"balance" json:"balance"`
+	Payments       []models.Payment    `bson:"payments" json:"payments"`
+	RefundedTotal  money.Money         `bson:"refunded_total" json:"refunded_total"`
+	CreditNotes    []models.CreditNote `bson:"credit_notes" json:"credit_notes"`
+	PaymentDueDate time.Time           `bson:"payment_due_date" json:"payment_due_date"`
+	SplitType      *string             `bson:"split_type" json:"split_type,omitempty"`
+	SplitIndex     *int                `bson:"split_index" json:"split_index,omitempty"`
+	SplitCount     *int                `bson:"split_count" json:"split_count,omitempty"`
+	Seat           *int                `bson:"seat" json:"seat,omitempty"`
+}
+
+// InvoiceBill is what an invoice bills: its lines, discounts, taxes and
+// totals as priced when it was billed. It is kept on the invoice, so changes
+// to food prices, tax rates or promotions made afterwards leave it as the
+// customer was given it.
+type InvoiceBill struct {
+	Subtotal           money.Money           `bson:"subtotal" json:"subtotal"`
+	Discounts          []helper.DiscountLine `bson:"discounts" json:"discounts"`
+	DiscountTotal      money.Money           `bson:"discount_total" json:"discount_total"`
+	TaxLines           []helper.TaxLine      `bson:"tax_lines" json:"tax_lines"`
+	TaxTotal           money.Money           `bson:"tax_total" json:"tax_total"`
+	ServiceCharges     []ServiceChargeLine   `bson:"service_charges" json:"service_charges"`
+	ServiceChargeTotal money.Money           `bson:"service_charge_total" json:"service_charge_total"`
+	PaymentDue         money.Money           `bson:"payment_due" json:"payment_due"`
+	ServerID           *string               `bson:"server_id" json:"server_id"`
+	TableNumber        *int                  `bson:"table_number" json:"table_number"`
+	OrderType          string                `bson:"order_type" json:"order_type"`
+	CustomerName       *string               `bson:"customer_name" json:"customer_name"`
+	CustomerPhone      *string               `bson:"customer_phone" json:"customer_phone"`
+	DeliveryFee        *money.Money          `bson:"delivery_fee" json:"delivery_fee"`
+	OrderDetails       []OrderLine           `bson:"order_details" json:"order_details"`
+	VoidedItems        []OrderLine           `bson:"voided_items" json:"voided_items"`
+	OrderTotal         money.Money           `bson:"order_total" json:"order_total,omitempty"`
+	SharedItems        []OrderLine           `bson:"shared_items" json:"shared_items,omitempty"`
+	SharedAmount       money.Money           `bson:"shared_amount" json:"shared_amount,omitempty"`
+	// LineTotals is what each order item was billed, after discounts and
+	// with its exclusive taxes, as credited when it is refunded.
+	LineTotals map[string]money.Money `bson:"line_totals" json:"-"`
+	BilledAt   time.Time              `bson:"billed_at" json:"billed_at"`
 }
 
 var invoiceCollection *mongo.Collection = database.OpenCollection(database.Client, "invoice")
 
+var invoiceListSpec = listSpec{
+	filters: []listFilter{
+		{param: "status", field: "payment_status", upper: true},
+		{param: "payment_method", field: "payment_method", upper: true},
+		{param: "order_id", field: "order_id"},
+	},
+	dateField:    "created_at",
+	searchFields: []string{"invoice_number", "billing_email"},
+	sorts: map[string]string{
+		"invoice_number":   "invoice_number",
+		"payment_due_date": "payment_due_date",
+		"created_at":       "created_at",
+		"updated_at":       "updated_at",
+	},
+	defaultSort: "created_at",
+}
+
+// GetInvoices lists invoices. ?status=overdue answers with the aging report
+// instead.
 func GetInvoices() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		result, err := invoiceCollection.Find(context.TODO(), bson.M{})
 		defer cancel()
+
+		if strings.EqualFold(c.Query("status"), "OVERDUE") {
+			getOverdueReport(c, ctx)
+			return
+		}
+		query, ok := listRequest(c, ctx, invoiceListSpec)
+		if !ok {
+			return
+		}
+		allInvoices := []models.Invoice{}
+		page, err := findPage(ctx, invoiceCollection, query, &allInvoices, nil)
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{
-					"message": "Menu not found",
-				})
-				return
-			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invoice items"})
 			return
 		}
-
-		var allInvoices []bson.M
-		if err = result.All(ctx, &allInvoices); err != nil {
-			log.Fatal(err)
-		}
-		c.JSON(http.StatusOK, allInvoices)
+		c.JSON(http.StatusOK, pageResponse("invoices", allInvoices, query, page))
 	}
 }
 
@@ -72,29 +131,302 @@ func GetInvoice() gin.HandlerFunc {
 			return
 		}
 
-		var invoiceView InvoiceViewFormat
-		allOrderItems, err := ItemsByOrder(invoice.OrderID)
-
+		invoiceView, err := buildInvoiceView(ctx, invoice)
+		defer cancel()
 		if err != nil {
-			defer cancel()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing order items by order ID"})
 			return
 		}
-		invoiceView.OrderID = invoice.OrderID
-		invoiceView.PaymentDueDate = invoice.PaymentDueDate
-		invoiceView.PaymentMethod = "null"
-		if invoice.PaymentMethod != nil {
-			invoiceView.PaymentMethod = *invoice.PaymentMethod
+		c.JSON(http.StatusOK, invoiceView)
+	}
+}
+
+// orderPricing is an order priced as a whole: its charged and voided lines,
+// the promotions taken off each line, the taxes and the amount due.
+type orderPricing struct {
+	Order              models.Order
+	TableNumber        *int
+	Guests             *int
+	Lines              []OrderLine
+	VoidedLines        []OrderLine
+	TaxRates           []models.TaxRate
+	Discounts          helper.DiscountResult
+	SubtotalCents      int64
+	Taxes              helper.TaxSummary
+	ServiceCharges     []ServiceChargeLine
+	ServiceChargeCents int64
+	DueCents           int64
+}
+
+// priceOrder prices an order from its current state: the subtotal of the
+// charged lines, the promotions and coupons that apply, the tax breakdown
+// per rate on the discounted lines, the automatic service charges and the
+// amount due including exclusive taxes, service charges and any delivery fee.
+func priceOrder(ctx context.Context, orderID string, couponCodes []string) (orderPricing, error) {
+	var pricing orderPricing
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&pricing.Order); err != nil {
+		return pricing, err
+	}
+	if pricing.Order.TableID != nil {
+		var table models.Table
+		if err := tableCollection.FindOne(ctx, bson.M{"table_id": pricing.Order.TableID}).Decode(&table); err == nil {
+			pricing.TableNumber = table.TableNumber
+			pricing.Guests = table.NumberOfGuests
 		}
+	}
 
-		invoiceView.InvoiceID = invoice.InvoiceID
-		invoiceView.PaymentStatus = invoice.PaymentStatus
-		invoiceView.PaymentDue = allOrderItems[0]["payment_due"]
-		invoiceView.TableNumber = allOrderItems[0]["table_number"]
-		invoiceView.OrderDetails = allOrderItems[0]["order_items"]
-		defer cancel()
-		c.JSON(http.StatusOK, invoiceView)
+	lines, err := OrderLines(ctx, orderID)
+	if err != nil {
+		return pricing, err
+	}
+	pricing.TaxRates, err = activeTaxRates(ctx)
+	if err != nil {
+		return pricing, err
+	}
+	promotions, err := activePromotions(ctx)
+	if err != nil {
+		return pricing, err
+	}
+	serviceCharges, err := activeServiceCharges(ctx)
+	if err != nil {
+		return pricing, err
+	}
+	// promotion windows are read on the restaurant's clock
+	location, err := restaurantLocation(ctx)
+	if err != nil {
+		return pricing, err
+	}
+
+	var discountableLines []helper.DiscountableLine
+	pricing.Lines = []OrderLine{}
+	pricing.VoidedLines = []OrderLine{}
+	for _, line := range lines {
+		if line.Status == "VOIDED" {
+			pricing.VoidedLines = append(pricing.VoidedLines, line)
+			continue
+		}
+		pricing.Lines = append(pricing.Lines, line)
+		amountCents := line.Amount.Cents
+		pricing.SubtotalCents += amountCents
+		if amountCents > 0 {
+			discountableLines = append(discountableLines, helper.DiscountableLine{
+				OrderItemID: line.OrderItemID,
+				FoodID:      line.FoodID,
+				Category:    line.Category,
+				AmountCents: amountCents,
+				OrderedAt:   line.CreatedAt.In(location),
+			})
+		}
+	}
+
+	orderedAt := time.Now()
+	if !pricing.Order.OrderDate.IsZero() {
+		orderedAt = pricing.Order.OrderDate
+	}
+	orderedAt = orderedAt.In(location)
+	pricing.Discounts = helper.ApplyPromotions(promotions, discountableLines, orderedAt, couponCodes)
+	pricing.Taxes = pricing.taxesFor(pricing.Lines)
+
+	orderType := "DINE_IN"
+	if pricing.Order.OrderType != nil {
+		orderType = *pricing.Order.OrderType
+	}
+	pricing.ServiceCharges, pricing.ServiceChargeCents = serviceChargesFor(serviceCharges, orderType, pricing.Guests, pricing.SubtotalCents-pricing.Discounts.TotalCents)
+
+	pricing.DueCents = pricing.SubtotalCents - pricing.Discounts.TotalCents + pricing.Taxes.ExclusiveTaxCents + pricing.ServiceChargeCents
+	if pricing.Order.DeliveryFee != nil {
+		pricing.DueCents += pricing.Order.DeliveryFee.Cents
+	}
+	return pricing, nil
+}
+
+// taxesFor works out the taxes on some of the order's lines, after discounts.
+func (pricing orderPricing) taxesFor(lines []OrderLine) helper.TaxSummary {
+	var taxableLines []helper.TaxableLine
+	for _, line := range lines {
+		amountCents := line.Amount.Cents - pricing.Discounts.LineDiscounts[line.OrderItemID]
+		if amountCents != 0 {
+			taxableLines = append(taxableLines, helper.TaxableLine{AmountCents: amountCents, Rates: taxRatesForLine(line, pricing.TaxRates)})
+		}
+	}
+	return helper.ComputeTaxes(taxableLines)
+}
+
+// invoicePricing prices the order behind an invoice with the coupons redeemed
+// on it. For a split invoice the coupons of every part of the split count,
+// and the split invoices are returned as well.
+func invoicePricing(ctx context.Context, invoice models.Invoice) (orderPricing, []models.Invoice, error) {
+	couponCodes := invoice.CouponCodes
+	var splits []models.Invoice
+	if invoice.SplitType != nil {
+		var err error
+		splits, err = splitInvoices(ctx, invoice.OrderID)
+		if err != nil {
+			return orderPricing{}, nil, err
+		}
+		couponCodes = splitCouponCodes(splits)
+	}
+	pricing, err := priceOrder(ctx, invoice.OrderID, couponCodes)
+	return pricing, splits, err
+}
+
+// buildInvoiceView shows an invoice as billed, with the payments and credit
+// notes taken against it.
+func buildInvoiceView(ctx context.Context, invoice models.Invoice) (InvoiceViewFormat, error) {
+	var invoiceView InvoiceViewFormat
+	invoiceView.InvoiceID = invoice.InvoiceID
+	invoiceView.InvoiceNumber = invoice.InvoiceNumber
+	invoiceView.OrderID = invoice.OrderID
+	invoiceView.PaymentStatus = invoice.PaymentStatus
+	invoiceView.PaymentDueDate = invoice.PaymentDueDate
+	invoiceView.SplitType = invoice.SplitType
+	invoiceView.SplitIndex = invoice.SplitIndex
+	invoiceView.SplitCount = invoice.SplitCount
+	invoiceView.Seat = invoice.Seat
+	invoiceView.PaymentMethod = "null"
+	if invoice.PaymentMethod != nil {
+		invoiceView.PaymentMethod = *invoice.PaymentMethod
+	}
+
+	payments, err := invoicePayments(ctx, invoice.InvoiceID)
+	if err != nil {
+		return invoiceView, err
+	}
+	invoiceView.Payments = payments
+	invoiceView.AmountPaid = money.Of(paidCents(payments))
+	invoiceView.TipTotal = money.Of(tipCents(payments))
+	if method := paymentMethodOf(payments); method != "" {
+		invoiceView.PaymentMethod = method
+	}
+	creditNotes, err := invoiceCreditNotes(ctx, invoice.InvoiceID)
+	if err != nil {
+		return invoiceView, err
+	}
+	invoiceView.CreditNotes = creditNotes
+	invoiceView.RefundedTotal = money.Of(refundedCents(creditNotes))
+
+	bill, err := invoiceBill(ctx, invoice)
+	if err != nil {
+		return invoiceView, err
+	}
+	invoiceView.InvoiceBill = bill
+	invoiceView.Balance = money.Of(bill.PaymentDue.Cents - paidCents(payments))
+	return invoiceView, nil
+}
+
+// invoiceBill is the bill kept on an invoice. Invoices from before bills
+// were kept are priced as they stand.
+func invoiceBill(ctx context.Context, invoice models.Invoice) (InvoiceBill, error) {
+	var bill InvoiceBill
+	if invoice.Bill != nil {
+		err := bson.Unmarshal(invoice.Bill, &bill)
+		return bill, err
+	}
+	return priceInvoice(ctx, invoice)
+}
+
+// priceInvoice prices an invoice from the current state of its order. A
+// plain invoice bills the whole order; a split invoice bills its share of
+// it, see splitShares.
+func priceInvoice(ctx context.Context, invoice models.Invoice) (InvoiceBill, error) {
+	var bill InvoiceBill
+	pricing, splits, err := invoicePricing(ctx, invoice)
+	if err != nil {
+		return bill, err
+	}
+	order := pricing.Order
+	bill.OrderType = "DINE_IN"
+	if order.OrderType != nil {
+		bill.OrderType = *order.OrderType
+	}
+	bill.CustomerName = order.CustomerName
+	bill.CustomerPhone = order.CustomerPhone
+	bill.DeliveryFee = order.DeliveryFee
+	bill.TableNumber = pricing.TableNumber
+	bill.ServerID = order.ServerID
+	bill.VoidedItems = pricing.VoidedLines
+	bill.BilledAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if invoice.SplitType == nil {
+		bill.OrderDetails = pricing.Lines
+		bill.Subtotal = money.Of(pricing.SubtotalCents)
+		bill.Discounts = pricing.Discounts.Lines
+		bill.DiscountTotal = money.Of(pricing.Discounts.TotalCents)
+		bill.ServiceCharges = pricing.ServiceCharges
+		bill.ServiceChargeTotal = money.Of(pricing.ServiceChargeCents)
+		bill.TaxLines = pricing.Taxes.Lines
+		bill.TaxTotal = money.Of(pricing.Taxes.InclusiveTaxCents + pricing.Taxes.ExclusiveTaxCents)
+		bill.PaymentDue = money.Of(pricing.DueCents)
+	} else {
+		share := splitShares(pricing, splits)[invoice.InvoiceID]
+		bill.OrderTotal = money.Of(pricing.DueCents)
+		bill.OrderDetails = share.Lines
+		bill.SharedItems = share.SharedLines
+		bill.SharedAmount = money.Of(share.SharedCents)
+		bill.Subtotal = money.Of(share.SubtotalCents)
+		bill.Discounts = share.Discounts
+		bill.DiscountTotal = money.Of(share.DiscountCents)
+		bill.ServiceCharges = []ServiceChargeLine{}
+		bill.TaxLines = share.Taxes.Lines
+		bill.TaxTotal = money.Of(share.Taxes.InclusiveTaxCents + share.Taxes.ExclusiveTaxCents)
+		bill.PaymentDue = money.Of(share.DueCents)
+	}
+
+	bill.LineTotals = map[string]money.Money{}
+	for _, line := range bill.OrderDetails {
+		cents := line.Amount.Cents - pricing.Discounts.LineDiscounts[line.OrderItemID] + pricing.taxesFor([]OrderLine{line}).ExclusiveTaxCents
+		bill.LineTotals[line.OrderItemID] = money.Of(cents)
+	}
+	return bill, nil
+}
+
+// billInvoice prices an invoice and keeps the bill on it.
+func billInvoice(ctx context.Context, invoice *models.Invoice) error {
+	bill, err := priceInvoice(ctx, *invoice)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(bill)
+	if err != nil {
+		return err
+	}
+	_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoice.InvoiceID}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "bill", Value: bson.Raw(raw)}}},
+	})
+	if err != nil {
+		return err
+	}
+	invoice.Bill = raw
+	// what is due may have changed, down to nothing for a comped order
+	status, _, err := refreshPaymentStatus(ctx, invoice.InvoiceID, bill.PaymentDue.Cents)
+	if err == nil {
+		invoice.PaymentStatus = &status
+	}
+	return err
+}
+
+// rebillOrderInvoices bills the open invoices of an order again after a
+// change made to the bill on purpose, such as a void or a coupon. Paid and
+// refunded invoices keep their bill; they only change through credit notes.
+// Voided invoices keep the bill they were voided with.
+func rebillOrderInvoices(ctx context.Context, orderID string) error {
+	result, err := invoiceCollection.Find(ctx, bson.M{
+		"order_id":       orderID,
+		"payment_status": bson.M{"$nin": bson.A{"PAID", "REFUNDED", "VOIDED"}},
+	})
+	if err != nil {
+		return err
+	}
+	var invoices []models.Invoice
+	if err = result.All(ctx, &invoices); err != nil {
+		return err
+	}
+	for i := range invoices {
+		if err := billInvoice(ctx, &invoices[i]); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func CreateInvoice() gin.HandlerFunc {
@@ -113,15 +445,45 @@ func CreateInvoice() gin.HandlerFunc {
 		err := orderCollection.FindOne(ctx, bson.M{"order_id": invoice.OrderID}).Decode(&order)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "message: Order was not found"})
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order details"})
 			return
 		}
-		status := "PENDING"
-		if invoice.PaymentStatus == nil {
-			invoice.PaymentStatus = &status
+		if order.Status != nil && *order.Status != "OPEN" {
+			c.JSON(http.StatusConflict, gin.H{"error": "order " + invoice.OrderID + " is " + strings.ToLower(*order.Status) + " and can't be invoiced"})
+			return
+		}
+		// an order is billed once, on one invoice or on its split invoices;
+		// only voided invoices don't count
+		count, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": invoice.OrderID, "payment_status": bson.M{"$ne": "VOIDED"}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "order " + invoice.OrderID + " is already invoiced"})
+			return
 		}
 
-		invoice.PaymentDueDate, _ = time.Parse(time.RFC3339, time.Now().AddDate(0, 0, 1).Format(time.RFC3339))
+		// the status follows from the payments recorded against the invoice
+		status := "PENDING"
+		invoice.PaymentStatus = &status
+		// coupons are redeemed through the coupon endpoint so usage is counted
+		invoice.CouponCodes = []string{}
+
+		// accounts on credit terms are given a later due date, everyone
+		// else has a day
+		if invoice.PaymentDueDate.IsZero() {
+			invoice.PaymentDueDate, _ = time.Parse(time.RFC3339, time.Now().AddDate(0, 0, 1).Format(time.RFC3339))
+		} else if !invoice.PaymentDueDate.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payment_due_date must be in the future"})
+			return
+		}
+		invoice.RemindersSent = 0
+		invoice.LastRemindedAt = nil
 		invoice.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		invoice.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		invoice.ID = primitive.NewObjectID()
@@ -134,60 +496,161 @@ func CreateInvoice() gin.HandlerFunc {
 			return
 		}
 
+		numbered, err := numberInvoices(ctx, []models.Invoice{invoice})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice number was not assigned"})
+			return
+		}
+		invoice = numbered[0]
+
 		result, insertErr := invoiceCollection.InsertOne(ctx, invoice)
 		defer cancel()
 		if insertErr != nil {
+			releaseInvoiceNumbers(ctx, numbered)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice item was not created"})
 			return
 		}
-		c.JSON(http.StatusOK, result)
+		if err := billInvoice(ctx, &invoice); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while pricing the invoice"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"InsertedID": result.InsertedID, "invoice_id": invoice.InvoiceID, "invoice_number": invoice.InvoiceNumber})
+	}
+}
+
+// numberInvoices gives each invoice a fresh number from the series. If a
+// number can't be taken, the ones taken so far are handed back.
+func numberInvoices(ctx context.Context, invoices []models.Invoice) ([]models.Invoice, error) {
+	numbering, err := invoiceNumbering(ctx)
+	if err != nil {
+		return invoices, err
+	}
+	now := time.Now()
+	for i := range invoices {
+		number, err := numbering.Next(ctx, now)
+		if err != nil {
+			releaseInvoiceNumbers(ctx, invoices[:i])
+			return invoices, err
+		}
+		invoices[i].InvoiceNumber = number.Number
+		invoices[i].NumberSeries = number.Series
+		invoices[i].NumberSequence = number.Sequence
+	}
+	return invoices, nil
+}
+
+// releaseInvoiceNumbers hands back the numbers of invoices that were never
+// stored. Numbers of stored invoices are never released, even when the
+// invoice is voided.
+func releaseInvoiceNumbers(ctx context.Context, invoices []models.Invoice) {
+	var numbers []helper.InvoiceNumber
+	for _, invoice := range invoices {
+		if invoice.NumberSeries != "" {
+			numbers = append(numbers, invoiceNumberOf(invoice))
+		}
 	}
+	if err := helper.ReleaseInvoiceNumbers(ctx, numbers); err != nil {
+		log.Printf("invoice numbers %v could not be released: %v", numbers, err)
+	}
+}
+
+// EnsureInvoiceIndexes creates the unique index that keeps an invoice number
+// from being given to two invoices.
+func EnsureInvoiceIndexes(ctx context.Context) error {
+	_, err := invoiceCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "invoice_number", Value: 1}},
+		Options: options.Index().
+			SetName("invoice_number_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"invoice_number": bson.M{"$type": "string"}}),
+	})
+	return err
+}
+
+func invoiceNumberOf(invoice models.Invoice) helper.InvoiceNumber {
+	return helper.InvoiceNumber{Number: invoice.InvoiceNumber, Series: invoice.NumberSeries, Sequence: invoice.NumberSequence}
+}
+
+// InvoiceUpdate is the body of PATCH /invoices/:invoice_id. A card_token
+// charges the balance of the invoice to that card.
+type InvoiceUpdate struct {
+	models.Invoice
+	CardToken string `json:"card_token"`
 }
 
+// UpdateInvoice changes the details of an open invoice, or charges its
+// balance to a card. Paid and refunded invoices can only be changed through
+// credit notes.
 func UpdateInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		var invoice models.Invoice
+		var update InvoiceUpdate
+		var existing models.Invoice
 		invoiceID := c.Param("invoice_id")
 
-		if err := c.BindJSON(&invoice); err != nil {
+		if err := c.BindJSON(&update); err != nil {
 			defer cancel()
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		invoice := update.Invoice
 
 		filter := bson.M{"invoice_id": invoiceID}
+		err := invoiceCollection.FindOne(ctx, filter).Decode(&existing)
+		defer cancel()
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		if invoiceIsSettled(existing.PaymentStatus) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a " + strings.ToLower(*existing.PaymentStatus) + " invoice can only be changed through a refund"})
+			return
+		}
+
+		if update.CardToken != "" {
+			if invoice.PaymentMethod != nil && *invoice.PaymentMethod != "CARD" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "a card_token can only be used with the CARD payment method"})
+				return
+			}
+			method := "CARD"
+			payment := models.Payment{Method: &method, CardToken: update.CardToken, IdempotencyKey: c.GetHeader("Idempotency-Key")}
+			result, status, msg := takePayment(ctx, invoiceID, payment)
+			if msg != "" {
+				c.JSON(status, gin.H{"error": msg})
+				return
+			}
+			c.JSON(status, result)
+			return
+		}
+
 		var updateObj primitive.D
 		if invoice.PaymentMethod != nil {
+			if err := validate.Var(*invoice.PaymentMethod, "eq=CARD|eq=CASH|eq="); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payment_method"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{Key: "payment_method", Value: invoice.PaymentMethod})
 		}
 
 		if invoice.PaymentStatus != nil {
-			updateObj = append(updateObj, bson.E{Key: "payment_status", Value: invoice.PaymentStatus})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payment_status follows from the payments, record a payment instead"})
+			return
 		}
 
 		invoice.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: invoice.UpdatedAt})
 
-		upsert := true
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
-		}
-
-		status := "PENDING"
-		if invoice.PaymentStatus == nil {
-			invoice.PaymentStatus = &status
-		}
-
 		result, err := invoiceCollection.UpdateOne(
 			ctx,
 			filter,
 			bson.D{
 				{Key: "$set", Value: updateObj},
 			},
-			&opt,
 		)
-		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice item update failed"})
 			return
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type KitchenTicketItem struct {
+	OrderItemID string     `json:"order_item_id"`
+	FoodID      string     `json:"food_id"`
+	FoodName    string     `json:"food_name"`
+	Quantity    string     `json:"quantity"`
+	Status      string     `json:"status"`
+	Course      int        `json:"course"`
+	Seat        *int       `json:"seat,omitempty"`
+	Hold        bool       `json:"hold"`
+	FireAt      *time.Time `json:"fire_at,omitempty"`
+	FiredAt     *time.Time `json:"fired_at,omitempty"`
+	Modifiers   []string   `json:"modifiers,omitempty"`
+	// AllergenWarnings are the declared allergies the item contains.
+	AllergenWarnings []string      `json:"allergen_warnings,omitempty"`
+	Notes            []models.Note `json:"notes"`
+}
+
+type KitchenTicket struct {
+	OrderID       string              `json:"order_id"`
+	OrderType     string              `json:"order_type"`
+	TableNumber   *int                `json:"table_number"`
+	CustomerName  *string             `json:"customer_name,omitempty"`
+	PickupTime    *time.Time          `json:"pickup_time,omitempty"`
+	Allergies     []string            `json:"allergies,omitempty"`
+	AllergyAlerts []models.Note       `json:"allergy_alerts"`
+	Notes         []models.Note       `json:"notes"`
+	Items         []KitchenTicketItem `json:"items"`
+	PrintedAt     time.Time           `json:"printed_at"`
+}
+
+func GetKitchenTicket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+
+		ticket, err := BuildKitchenTicket(ctx, orderID)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building kitchen ticket"})
+			return
+		}
+		c.JSON(http.StatusOK, ticket)
+	}
+}
+
+// BuildKitchenTicket collects the items of an order together with the notes
+// attached to the order and its items. Allergy notes, including those on the
+// order's table, are lifted into AllergyAlerts so they are printed at the top
+// of the ticket, and items containing an allergy declared on the order are
+// flagged.
+func BuildKitchenTicket(ctx context.Context, orderID string) (KitchenTicket, error) {
+	var ticket KitchenTicket
+	var order models.Order
+
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order); err != nil {
+		return ticket, err
+	}
+	ticket.OrderID = order.OrderID
+	ticket.OrderType = "DINE_IN"
+	if order.OrderType != nil {
+		ticket.OrderType = *order.OrderType
+	}
+	ticket.CustomerName = order.CustomerName
+	ticket.PickupTime = order.PickupTime
+	ticket.Allergies = order.Allergies
+	ticket.PrintedAt = time.Now()
+
+	if order.TableID != nil {
+		var table models.Table
+		if err := tableCollection.FindOne(ctx, bson.M{"table_id": order.TableID}).Decode(&table); err == nil {
+			ticket.TableNumber = table.TableNumber
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "course", Value: 1}, {Key: "created_at", Value: 1}})
+	result, err := orderItemCollection.Find(ctx, bson.M{"order_id": orderID}, opts)
+	if err != nil {
+		return ticket, err
+	}
+	var orderItems []models.OrderItem
+	if err = result.All(ctx, &orderItems); err != nil {
+		return ticket, err
+	}
+
+	var itemIDs []string
+	for _, orderItem := range orderItems {
+		itemIDs = append(itemIDs, orderItem.OrderItemID)
+	}
+	itemNotes, err := NotesFor(ctx, "ORDER_ITEM", itemIDs)
+	if err != nil {
+		return ticket, err
+	}
+	orderNotes, err := NotesFor(ctx, "ORDER", []string{orderID})
+	if err != nil {
+		return ticket, err
+	}
+	var tableNotes []models.Note
+	if order.TableID != nil {
+		if tableNotes, err = NotesFor(ctx, "TABLE", []string{*order.TableID}); err != nil {
+			return ticket, err
+		}
+	}
+
+	notesByItem := map[string][]models.Note{}
+	for _, note := range itemNotes {
+		notesByItem[*note.EntityID] = append(notesByItem[*note.EntityID], note)
+	}
+
+	ticket.AllergyAlerts = []models.Note{}
+	ticket.Notes = []models.Note{}
+	// allergies noted on the table are meant for whoever sits at it
+	alerts := append(append(append([]models.Note{}, tableNotes...), orderNotes...), itemNotes...)
+	for _, note := range alerts {
+		if *note.NoteType == "ALLERGY" {
+			ticket.AllergyAlerts = append(ticket.AllergyAlerts, note)
+		}
+	}
+	for _, note := range orderNotes {
+		if *note.NoteType != "ALLERGY" {
+			ticket.Notes = append(ticket.Notes, note)
+		}
+	}
+
+	ticket.Items = []KitchenTicketItem{}
+	for _, orderItem := range orderItems {
+		item := KitchenTicketItem{
+			OrderItemID: orderItem.OrderItemID,
+			Status:      "ACTIVE",
+			Course:      1,
+			Seat:        orderItem.Seat,
+			FireAt:      orderItem.FireAt,
+			FiredAt:     orderItem.FiredAt,
+			Modifiers:   orderItem.Modifiers,
+			Notes:       notesByItem[orderItem.OrderItemID],
+		}
+		if orderItem.Course != nil {
+			item.Course = *orderItem.Course
+		}
+		if orderItem.Hold != nil {
+			item.Hold = *orderItem.Hold
+		}
+		if orderItem.Status != nil {
+			item.Status = *orderItem.Status
+		}
+		if orderItem.Quantity != nil {
+			item.Quantity = *orderItem.Quantity
+		}
+		if orderItem.FoodID != nil {
+			item.FoodID = *orderItem.FoodID
+			var food models.Food
+			if err := foodCollection.FindOne(ctx, bson.M{"food_id": orderItem.FoodID}).Decode(&food); err == nil && food.Name != nil {
+				item.FoodName = *food.Name
+				item.AllergenWarnings = helper.AllergenConflicts(order.Allergies, food, orderItem.Modifiers)
+			}
+		}
+		if item.Notes == nil {
+			item.Notes = []models.Note{}
+		}
+		ticket.Items = append(ticket.Items, item)
+	}
+
+	return ticket, nil
+}
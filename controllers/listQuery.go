@@ -0,0 +1,408 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/money"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultRecordPerPage = 10
+	maxRecordPerPage     = 100
+)
+
+// listSpec is what a list route can be filtered, searched and sorted on.
+// Every list route reads the same query parameters:
+//
+//   - one parameter per filter, matching a field exactly; comma separated
+//     values match any of them
+//   - min_price and max_price on the price field
+//   - from and to on the date field, as RFC 3339 times or local dates
+//   - q, searching the search fields
+//   - sort, a comma separated list of sort keys, each descending with a
+//     leading -
+//   - recordPerPage, and cursor, the next_cursor of the page before
+//   - count=true, adding the number of results in all
+type listSpec struct {
+	filters    []listFilter
+	priceField string
+	dateField  string
+	// textSearch searches with the collection's text index rather than by
+	// matching searchFields.
+	textSearch   bool
+	searchFields []string
+	sorts        map[string]string
+	defaultSort  string
+}
+
+// listFilter matches param against field. Upper-cased filters take enum
+// values in any case; numeric ones compare as integers.
+type listFilter struct {
+	param   string
+	field   string
+	upper   bool
+	numeric bool
+}
+
+// listQuery is a list request worked out against its spec.
+type listQuery struct {
+	Filter        bson.M
+	Sort          bson.D
+	RecordPerPage int
+	// After holds the sort key values of the last result of the page
+	// before, nil for the first page.
+	After []interface{}
+	Count bool
+	// textScore sorts text search results by relevance.
+	textScore bool
+}
+
+// listPage is a page of results as found: where the next page starts, and
+// how many results there are in all when they were counted.
+type listPage struct {
+	NextCursor string
+	TotalCount *int64
+}
+
+// listCursor is what a next_cursor token holds: the sort it was made for
+// and the sort key values of the last result handed out.
+type listCursor struct {
+	Sort   string        `bson:"s"`
+	Values []interface{} `bson:"v"`
+}
+
+// parseListQuery reads the list parameters of a request. A non-empty msg
+// says what is wrong with them.
+func parseListQuery(c *gin.Context, spec listSpec, location *time.Location) (listQuery, string) {
+	query := listQuery{Filter: bson.M{}, RecordPerPage: defaultRecordPerPage}
+
+	if c.Query("page") != "" {
+		return query, "page is no longer supported, pass the next_cursor of the page before as cursor"
+	}
+	if value := c.Query("recordPerPage"); value != "" {
+		recordPerPage, err := strconv.Atoi(value)
+		if err != nil || recordPerPage < 1 || recordPerPage > maxRecordPerPage {
+			return query, "recordPerPage must be between 1 and " + strconv.Itoa(maxRecordPerPage)
+		}
+		query.RecordPerPage = recordPerPage
+	}
+	if value := c.Query("count"); value != "" {
+		count, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, "count must be true or false"
+		}
+		query.Count = count
+	}
+
+	for _, filter := range spec.filters {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		var values []interface{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if filter.upper {
+				item = strings.ToUpper(item)
+			}
+			if !filter.numeric {
+				values = append(values, item)
+				continue
+			}
+			number, err := strconv.Atoi(item)
+			if err != nil {
+				return query, filter.param + " must be a number"
+			}
+			values = append(values, number)
+		}
+		if len(values) == 1 {
+			query.Filter[filter.field] = values[0]
+		} else {
+			query.Filter[filter.field] = bson.M{"$in": values}
+		}
+	}
+
+	if spec.priceField != "" {
+		price := bson.M{}
+		for param, operator := range map[string]string{"min_price": "$gte", "max_price": "$lte"} {
+			if value := c.Query(param); value != "" {
+				amount, err := money.Parse(value, money.DefaultCurrency())
+				if err != nil {
+					return query, param + " must be an amount"
+				}
+				price[operator] = amount.Cents
+			}
+		}
+		if len(price) > 0 {
+			query.Filter[spec.priceField+".cents"] = price
+		}
+	}
+
+	if spec.dateField != "" {
+		dates := bson.M{}
+		for param, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+			if value := c.Query(param); value != "" {
+				at, msg := parseListDate(value, param == "to", location)
+				if msg != "" {
+					return query, param + " " + msg
+				}
+				dates[operator] = at
+			}
+		}
+		if len(dates) > 0 {
+			query.Filter[spec.dateField] = dates
+		}
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		if spec.textSearch {
+			query.Filter["$text"] = bson.M{"$search": q}
+			query.textScore = true
+		} else if len(spec.searchFields) > 0 {
+			pattern := containsPattern(q)
+			var anyOf bson.A
+			for _, field := range spec.searchFields {
+				anyOf = append(anyOf, bson.M{field: pattern})
+			}
+			query.Filter["$or"] = anyOf
+		}
+	}
+
+	sortValue := c.Query("sort")
+	if sortValue == "" && query.textScore {
+		query.Sort = bson.D{{Key: "score", Value: -1}}
+	} else {
+		if sortValue == "" {
+			sortValue = spec.defaultSort
+		}
+		for _, key := range strings.Split(sortValue, ",") {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+			direction := 1
+			if strings.HasPrefix(key, "-") {
+				key, direction = key[1:], -1
+			}
+			field, ok := spec.sorts[key]
+			if !ok {
+				return query, "can't sort by " + key
+			}
+			query.Sort = append(query.Sort, bson.E{Key: field, Value: direction})
+		}
+	}
+	// _id last keeps pages stable when sort keys tie
+	query.Sort = append(query.Sort, bson.E{Key: "_id", Value: 1})
+
+	if value := c.Query("cursor"); value != "" {
+		after, ok := decodeListCursor(value, query.Sort)
+		if !ok {
+			return query, "cursor is not valid for this sort"
+		}
+		query.After = after
+	}
+	return query, ""
+}
+
+// sortSignature names a sort, so a cursor made for one sort isn't used
+// with another.
+func sortSignature(sort bson.D) string {
+	var keys []string
+	for _, key := range sort {
+		keys = append(keys, fmt.Sprintf("%s:%v", key.Key, key.Value))
+	}
+	return strings.Join(keys, ",")
+}
+
+func encodeListCursor(sort bson.D, values []interface{}) (string, error) {
+	data, err := bson.Marshal(listCursor{Sort: sortSignature(sort), Values: values})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeListCursor(token string, sort bson.D) ([]interface{}, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, false
+	}
+	var cursor listCursor
+	if err := bson.Unmarshal(data, &cursor); err != nil {
+		return nil, false
+	}
+	if cursor.Sort != sortSignature(sort) || len(cursor.Values) != len(sort) {
+		return nil, false
+	}
+	return cursor.Values, true
+}
+
+// afterFilter matches the results sorting after the sort key values after.
+// For keys k1, k2, ... that is k1 past its value, or k1 equal and k2 past
+// its value, and so on. Missing and null values sort lowest: first when
+// ascending, last when descending.
+func afterFilter(sort bson.D, after []interface{}) bson.M {
+	var anyOf bson.A
+	for i, key := range sort {
+		var pasts []interface{}
+		switch {
+		case key.Value == 1 && after[i] == nil:
+			pasts = append(pasts, bson.M{"$ne": nil})
+		case key.Value == 1:
+			pasts = append(pasts, bson.M{"$gt": after[i]})
+		case after[i] != nil:
+			// $lt leaves out missing values, which come last descending
+			pasts = append(pasts, bson.M{"$lt": after[i]}, nil)
+		}
+		for _, past := range pasts {
+			match := bson.D{}
+			for j := 0; j < i; j++ {
+				match = append(match, bson.E{Key: sort[j].Key, Value: after[j]})
+			}
+			anyOf = append(anyOf, append(match, bson.E{Key: key.Key, Value: past}))
+		}
+	}
+	return bson.M{"$or": anyOf}
+}
+
+// parseListDate reads a from or to parameter. A bare date is the start of
+// that day on the restaurant's clock, or for to its end.
+func parseListDate(value string, endOfDay bool, location *time.Location) (time.Time, string) {
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, ""
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, location)
+	if err != nil {
+		return day, "must be an RFC 3339 time or a date such as 2026-10-19"
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1).Add(-time.Nanosecond), ""
+	}
+	return day, ""
+}
+
+// containsPattern matches text anywhere in a field, ignoring case.
+func containsPattern(text string) bson.M {
+	return bson.M{"$regex": regexp.QuoteMeta(text), "$options": "i"}
+}
+
+// listRequest parses the list parameters of a request, answering with 400
+// or 500 and returning false when it can't.
+func listRequest(c *gin.Context, ctx context.Context, spec listSpec) (listQuery, bool) {
+	location, err := restaurantLocation(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching restaurant details"})
+		return listQuery{}, false
+	}
+	query, msg := parseListQuery(c, spec, location)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return query, false
+	}
+	return query, true
+}
+
+// findPage loads one page of a list query into results, a pointer to a
+// slice. Pages start where the cursor left off rather than skipping the
+// results before them, so deep pages cost no more than the first. The
+// results are counted only when asked for, as counting reads every match.
+// The projection must keep the sort keys, as the next cursor is made of
+// them.
+func findPage(ctx context.Context, collection *mongo.Collection, query listQuery, results interface{}, projection bson.M) (listPage, error) {
+	var page listPage
+	if query.Count {
+		total, err := collection.CountDocuments(ctx, query.Filter)
+		if err != nil {
+			return page, err
+		}
+		page.TotalCount = &total
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: query.Filter}}}
+	if query.textScore {
+		pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}})
+	}
+	if query.After != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: afterFilter(query.Sort, query.After)}})
+	}
+	// one more than a page tells whether there is a next page
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: query.Sort}},
+		bson.D{{Key: "$limit", Value: query.RecordPerPage + 1}},
+	)
+	if projection != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: projection}})
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return page, err
+	}
+	var documents []bson.Raw
+	if err = cursor.All(ctx, &documents); err != nil {
+		return page, err
+	}
+
+	if len(documents) > query.RecordPerPage {
+		documents = documents[:query.RecordPerPage]
+		last := documents[len(documents)-1]
+		values := make([]interface{}, len(query.Sort))
+		for i, key := range query.Sort {
+			if value, err := last.LookupErr(strings.Split(key.Key, ".")...); err == nil && value.Type != bsontype.Null {
+				values[i] = value
+			}
+		}
+		if page.NextCursor, err = encodeListCursor(query.Sort, values); err != nil {
+			return page, err
+		}
+	}
+
+	list := reflect.ValueOf(results).Elem()
+	list.Set(reflect.MakeSlice(list.Type(), 0, len(documents)))
+	for _, document := range documents {
+		item := reflect.New(list.Type().Elem())
+		if err := bson.Unmarshal(document, item.Interface()); err != nil {
+			return page, err
+		}
+		list.Set(reflect.Append(list, item.Elem()))
+	}
+	return page, nil
+}
+
+// pageResponse is the body every list route answers with: the page of
+// results under key, the cursor of the next page, empty after the last
+// one, and the total when it was counted.
+func pageResponse(key string, results interface{}, query listQuery, page listPage) gin.H {
+	response := gin.H{
+		key:               results,
+		"record_per_page": query.RecordPerPage,
+		"next_cursor":     page.NextCursor,
+		"has_more":        page.NextCursor != "",
+	}
+	if page.TotalCount != nil {
+		response["total_count"] = *page.TotalCount
+	}
+	return response
+}
+
+// EnsureListIndexes creates the indexes list routes rely on, such as the
+// text index food search uses. Existing indexes are left as they are.
+func EnsureListIndexes(ctx context.Context) error {
+	_, err := foodCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: "text"}},
+		Options: options.Index().SetName("food_name_text"),
+	})
+	return err
+}
@@ -2,13 +2,13 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"strconv"
 	"time"
 
 	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
 	"atm1504.in/rms/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -20,56 +20,40 @@ import (
 var menuCollection *mongo.Collection = database.OpenCollection(database.Client, "menu")
 
 // var validate = validator.New()
+var menuListSpec = listSpec{
+	filters: []listFilter{
+		{param: "category", field: "category"},
+		{param: "status", field: "status", upper: true},
+	},
+	dateField:    "created_at",
+	searchFields: []string{"name", "category"},
+	sorts: map[string]string{
+		"name":       "name",
+		"category":   "category",
+		"start_date": "start_date",
+		"end_date":   "end_date",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	defaultSort: "created_at",
+}
+
 func GetMenus() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		recordPerPage, err := strconv.Atoi(c.DefaultQuery("recordPerPage", "10"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
-		}
-
-		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-		if err != nil || page < 1 {
-			page = 1
-		}
-
-		startIndex := (page - 1) * recordPerPage
-
-		matchStage := bson.D{{Key: "$match", Value: bson.D{}}}
-		groupStage := bson.D{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: nil},
-			{Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}},
-			{Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
-		}}}
-		projectStage := bson.D{
-			{Key: "$project", Value: bson.D{
-				{Key: "_id", Value: 0},
-				{Key: "total_count", Value: 1},
-				{Key: "menus", Value: bson.D{{Key: "$slice", Value: []interface{}{"$data", startIndex, recordPerPage}}}},
-			}},
+		query, ok := listRequest(c, ctx, menuListSpec)
+		if !ok {
+			return
 		}
-
-		result, err := menuCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, projectStage})
-		defer cancel()
+		allMenus := []models.Menu{}
+		page, err := findPage(ctx, menuCollection, query, &allMenus, nil)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing menus"})
 			return
 		}
-
-		var allMenus []bson.M
-		if err = result.All(ctx, &allMenus); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while processing menus"})
-			log.Fatal(err)
-		}
-
-		// Assuming you want to return the list of menus directly
-		if len(allMenus) > 0 {
-			c.JSON(http.StatusOK, allMenus[0])
-		} else {
-			c.JSON(http.StatusOK, []interface{}{}) // Return an empty array if no menus
-		}
+		c.JSON(http.StatusOK, pageResponse("menus", allMenus, query, page))
 	}
 }
 
@@ -98,6 +82,40 @@ func GetMenu() gin.HandlerFunc {
 	}
 }
 
+// GetActiveMenus lists the menus that can be ordered from at a moment, now
+// unless at is given. at is RFC 3339, or a local time such as
+// 2026-10-19T08:30 on the restaurant's clock.
+func GetActiveMenus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		location, err := restaurantLocation(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching restaurant details"})
+			return
+		}
+		at := time.Now()
+		if value := c.Query("at"); value != "" {
+			at, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				at, err = time.ParseInLocation("2006-01-02T15:04", value, location)
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "at must be an RFC 3339 time or a local time such as 2026-10-19T08:30"})
+				return
+			}
+		}
+
+		menus, err := activeMenus(ctx, at, location)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing menus"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"at": at.In(location), "timezone": location.String(), "menus": menus})
+	}
+}
+
 func CreateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -114,10 +132,30 @@ func CreateMenu() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
+		if menu.StartDate != nil && menu.EndDate != nil && !menu.EndDate.After(*menu.StartDate) {
+			defer cancel()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be after start_date"})
+			return
+		}
+		// a menu is published as version 1 straight away unless it is
+		// created as a draft
+		status := "PUBLISHED"
+		if menu.Status != nil {
+			status = *menu.Status
+		}
+		menu.Status = &status
+		menu.Version = 0
+		menu.Draft = nil
+		menu.PublishAt = nil
+		menu.PublishedAt = nil
 		menu.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.ID = primitive.NewObjectID()
 		menu.MenuID = menu.ID.Hex()
+		if status == "PUBLISHED" {
+			menu.Version = 1
+			menu.PublishedAt = &menu.CreatedAt
+		}
 
 		result, err := menuCollection.InsertOne(ctx, menu)
 		defer cancel()
@@ -125,63 +163,174 @@ func CreateMenu() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating menu"})
 			return
 		}
+		if status == "PUBLISHED" {
+			if _, err := menuVersionCollection.InsertOne(ctx, newMenuVersion(menu.MenuID, 1, menuContentOf(menu), c.GetString("uid"), nil)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in recording the menu version"})
+				return
+			}
+		}
 		c.JSON(http.StatusCreated, result)
 	}
 }
 
-func inTimeSpan(start, end, check time.Time) bool {
-	return check.After(start) && check.Before(end)
-}
-
+// UpdateMenu edits a menu's draft, starting one from the published menu when
+// there is none. Any of name, category, start_date, end_date and schedules
+// can be sent, and null clears a date. The menu served doesn't change until
+// the draft is published.
 func UpdateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		var menu models.Menu
+		defer cancel()
+		var edits map[string]json.RawMessage
 
-		menuID := c.Param("menu_id")
-		filter := bson.M{"menu_id": menuID}
-		if err := c.BindJSON(&menu); err != nil {
+		if err := c.BindJSON(&edits); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			defer cancel()
+			return
+		}
+		menu, ok := findMenu(c, ctx)
+		if !ok {
 			return
 		}
 
-		var updateObj primitive.D
-		if menu.StartDate != nil && menu.EndDate != nil {
-			if !inTimeSpan(*menu.StartDate, *menu.EndDate, time.Now()) {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Kindly enter valid time"})
-				defer cancel()
-				return
-			}
-			updateObj = append(updateObj, bson.E{Key: "start_date", Value: menu.StartDate})
-			updateObj = append(updateObj, bson.E{Key: "end_date", Value: menu.EndDate})
+		draft := menuContentOf(menu)
+		if menu.Draft != nil {
+			draft = *menu.Draft
+		}
+		if err := applyMenuEdits(&draft, edits); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if msg := checkMenuContent(draft); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
 
-			if menu.Name != "" {
-				updateObj = append(updateObj, bson.E{Key: "name", Value: menu.Name})
-			}
+		menu.Draft = &draft
+		menu.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err := menuCollection.UpdateOne(ctx, bson.M{"menu_id": menu.MenuID}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "draft", Value: menu.Draft},
+				{Key: "updated_at", Value: menu.UpdatedAt},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in updating menu"})
+			return
+		}
+		c.JSON(http.StatusOK, menu)
+	}
+}
 
-			if menu.Category != "" {
-				updateObj = append(updateObj, bson.E{Key: "category", Value: menu.Category})
-			}
+// applyMenuEdits sets the fields present in a PATCH body on a draft.
+func applyMenuEdits(draft *models.MenuContent, edits map[string]json.RawMessage) error {
+	for key, value := range edits {
+		var target interface{}
+		switch key {
+		case "name":
+			target = &draft.Name
+		case "category":
+			target = &draft.Category
+		case "start_date":
+			target = &draft.StartDate
+		case "end_date":
+			target = &draft.EndDate
+		case "schedules":
+			target = &draft.Schedules
+		default:
+			return fmt.Errorf("%s can't be edited, publish the draft or use the menu's actions instead", key)
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	return nil
+}
 
-			upsert := true
-			opt := options.UpdateOptions{Upsert: &upsert}
-
-			result, err := menuCollection.UpdateOne(
-				ctx,
-				filter,
-				bson.D{
-					{Key: "$set", Value: updateObj},
-				},
-				&opt,
-			)
-			defer cancel()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in updating menu"})
-				return
-			}
-			c.JSON(http.StatusOK, result)
+// checkMenuContent validates menu content before it is saved or published
+// and returns the error message, if any.
+func checkMenuContent(content models.MenuContent) string {
+	if validationErr := validate.Struct(content); validationErr != nil {
+		return validationErr.Error()
+	}
+	if content.StartDate != nil && content.EndDate != nil && !content.EndDate.After(*content.StartDate) {
+		return "end_date must be after start_date"
+	}
+	return ""
+}
+
+// activeMenus are the menus that can be ordered from at a moment.
+func activeMenus(ctx context.Context, at time.Time, location *time.Location) ([]models.Menu, error) {
+	menus := []models.Menu{}
+	result, err := menuCollection.Find(ctx, bson.M{"$and": bson.A{
+		bson.M{"$or": bson.A{bson.M{"start_date": nil}, bson.M{"start_date": bson.M{"$lte": at}}}},
+		bson.M{"$or": bson.A{bson.M{"end_date": nil}, bson.M{"end_date": bson.M{"$gt": at}}}},
+	}}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return menus, err
+	}
+	var candidates []models.Menu
+	if err = result.All(ctx, &candidates); err != nil {
+		return menus, err
+	}
+	for _, menu := range candidates {
+		if helper.MenuAvailableAt(menu, at, location) {
+			menus = append(menus, menu)
+		}
+	}
+	return menus, nil
+}
+
+// checkFoodsAvailable makes sure every food ordered is on a menu that can be
+// ordered from at the moment. It returns the HTTP status to answer with and,
+// when something can't be ordered, the error message.
+func checkFoodsAvailable(ctx context.Context, orderItems []models.OrderItem, at time.Time) (int, string) {
+	var foodIDs []string
+	for _, orderItem := range orderItems {
+		foodIDs = append(foodIDs, *orderItem.FoodID)
+	}
+	var foods []models.Food
+	result, err := foodCollection.Find(ctx, bson.M{"food_id": bson.M{"$in": foodIDs}})
+	if err == nil {
+		err = result.All(ctx, &foods)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "Error in fetching food details"
+	}
+	foodByID := map[string]models.Food{}
+	var menuIDs []string
+	for _, food := range foods {
+		foodByID[food.FoodID] = food
+		if food.MenuID != nil {
+			menuIDs = append(menuIDs, *food.MenuID)
+		}
+	}
+
+	var menus []models.Menu
+	result, err = menuCollection.Find(ctx, bson.M{"menu_id": bson.M{"$in": menuIDs}})
+	if err == nil {
+		err = result.All(ctx, &menus)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "Error in fetching menu details"
+	}
+	menuByID := map[string]models.Menu{}
+	for _, menu := range menus {
+		menuByID[menu.MenuID] = menu
+	}
+	location, err := restaurantLocation(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, "Error in fetching restaurant details"
+	}
+
+	for _, foodID := range foodIDs {
+		food, ok := foodByID[foodID]
+		if !ok {
+			return http.StatusNotFound, "food " + foodID + " not found"
+		}
+		menu, ok := menuByID[*food.MenuID]
+		if !ok || !helper.MenuAvailableAt(menu, at, location) {
+			return http.StatusBadRequest, *food.Name + " is not available at this time"
 		}
-		defer cancel()
 	}
+	return http.StatusOK, ""
 }
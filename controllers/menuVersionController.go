@@ -0,0 +1,323 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var menuVersionCollection *mongo.Collection = database.OpenCollection(database.Client, "menuVersion")
+
+var errMenuChanged = errors.New("menu was changed at the same time, try again")
+
+// PublishMenu publishes a menu's draft, or a menu never published, as its
+// next version. With a publish_at in the future the draft is published then
+// by the background scheduler instead; later edits to the draft are
+// published with it.
+func PublishMenu() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var body struct {
+			PublishAt *time.Time `json:"publish_at"`
+		}
+
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if !requireManager(c, ctx, "only a manager can publish menus") {
+			return
+		}
+
+		menu, ok := findMenu(c, ctx)
+		if !ok {
+			return
+		}
+		content, msg := menuToPublish(menu)
+		if msg != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+		if msg := checkMenuContent(content); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if body.PublishAt != nil && body.PublishAt.After(time.Now()) {
+			menu.PublishAt = body.PublishAt
+			menu.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			_, err := menuCollection.UpdateOne(ctx, bson.M{"menu_id": menu.MenuID}, bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "publish_at", Value: menu.PublishAt},
+					{Key: "updated_at", Value: menu.UpdatedAt},
+				}},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in scheduling the menu"})
+				return
+			}
+			c.JSON(http.StatusOK, menu)
+			return
+		}
+
+		menu, err := publishMenu(ctx, menu, content, c.GetString("uid"), nil)
+		if err != nil {
+			if err == errMenuChanged {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in publishing the menu"})
+			return
+		}
+		c.JSON(http.StatusOK, menu)
+	}
+}
+
+// DiscardMenuDraft throws away a menu's draft along with any scheduled
+// publish.
+func DiscardMenuDraft() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if !requireManager(c, ctx, "only a manager can discard menu drafts") {
+			return
+		}
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := menuCollection.UpdateOne(ctx, bson.M{"menu_id": c.Param("menu_id")}, bson.D{
+			{Key: "$unset", Value: bson.D{{Key: "draft", Value: ""}, {Key: "publish_at", Value: ""}}},
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in updating menu"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Menu not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetMenuVersions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+		result, err := menuVersionCollection.Find(ctx, bson.M{"menu_id": c.Param("menu_id")}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing menu versions"})
+			return
+		}
+		allVersions := []models.MenuVersion{}
+		if err = result.All(ctx, &allVersions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing menu versions"})
+			return
+		}
+		c.JSON(http.StatusOK, allVersions)
+	}
+}
+
+// RollbackMenu publishes an earlier version of a menu again, as a new
+// version. A pending draft is kept.
+func RollbackMenu() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a number"})
+			return
+		}
+		if !requireManager(c, ctx, "only a manager can roll back menus") {
+			return
+		}
+		menu, ok := findMenu(c, ctx)
+		if !ok {
+			return
+		}
+
+		var previous models.MenuVersion
+		err = menuVersionCollection.FindOne(ctx, bson.M{"menu_id": menu.MenuID, "version": version}).Decode(&previous)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Menu version not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching menu version details"})
+			return
+		}
+		if version == menu.Version {
+			c.JSON(http.StatusConflict, gin.H{"error": "version " + c.Param("version") + " is already the published version"})
+			return
+		}
+
+		menu, err = publishMenu(ctx, menu, previous.Content, c.GetString("uid"), &version)
+		if err != nil {
+			if err == errMenuChanged {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in publishing the menu"})
+			return
+		}
+		c.JSON(http.StatusOK, menu)
+	}
+}
+
+// PublishScheduledMenus publishes the drafts whose publish time has come. It
+// is run periodically by the background scheduler.
+func PublishScheduledMenus(ctx context.Context) error {
+	result, err := menuCollection.Find(ctx, bson.M{"publish_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return err
+	}
+	var menus []models.Menu
+	if err = result.All(ctx, &menus); err != nil {
+		return err
+	}
+	for _, menu := range menus {
+		content, msg := menuToPublish(menu)
+		if msg == "" {
+			msg = checkMenuContent(content)
+		}
+		if msg != "" {
+			log.Printf("menus: not publishing menu %s: %s", menu.MenuID, msg)
+			_, err = menuCollection.UpdateOne(ctx, bson.M{"menu_id": menu.MenuID}, bson.D{{Key: "$unset", Value: bson.D{{Key: "publish_at", Value: ""}}}})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := publishMenu(ctx, menu, content, "scheduler", nil); err != nil && err != errMenuChanged {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishMenu makes content the menu served, as the menu's next version. The
+// menu document is changed in a single update guarded by the version it was
+// read at, so a menu changed meanwhile is left alone and errMenuChanged is
+// returned. Publishing a draft clears it; a rollback leaves it be.
+func publishMenu(ctx context.Context, menu models.Menu, content models.MenuContent, publishedBy string, rolledBackFrom *int) (models.Menu, error) {
+	record := newMenuVersion(menu.MenuID, menu.Version+1, content, publishedBy, rolledBackFrom)
+	if _, err := menuVersionCollection.InsertOne(ctx, record); err != nil {
+		return menu, err
+	}
+
+	published := "PUBLISHED"
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "name", Value: content.Name},
+			{Key: "category", Value: content.Category},
+			{Key: "start_date", Value: content.StartDate},
+			{Key: "end_date", Value: content.EndDate},
+			{Key: "schedules", Value: content.Schedules},
+			{Key: "status", Value: published},
+			{Key: "version", Value: record.Version},
+			{Key: "published_at", Value: record.PublishedAt},
+			{Key: "updated_at", Value: record.PublishedAt},
+		}},
+	}
+	if rolledBackFrom == nil {
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "draft", Value: ""}, {Key: "publish_at", Value: ""}}})
+	}
+	// menus from before versioning have no version field
+	filter := bson.M{"menu_id": menu.MenuID, "version": menu.Version}
+	if menu.Version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	result, err := menuCollection.UpdateOne(ctx, filter, update)
+	if err == nil && result.MatchedCount == 0 {
+		err = errMenuChanged
+	}
+	if err != nil {
+		if _, deleteErr := menuVersionCollection.DeleteOne(ctx, bson.M{"menu_version_id": record.MenuVersionID}); deleteErr != nil {
+			log.Printf("menus: version %d of menu %s left behind: %v", record.Version, menu.MenuID, deleteErr)
+		}
+		return menu, err
+	}
+
+	menu.Name, menu.Category = content.Name, content.Category
+	menu.StartDate, menu.EndDate, menu.Schedules = content.StartDate, content.EndDate, content.Schedules
+	menu.Status = &published
+	menu.Version = record.Version
+	menu.PublishedAt = &record.PublishedAt
+	menu.UpdatedAt = record.PublishedAt
+	if rolledBackFrom == nil {
+		menu.Draft = nil
+		menu.PublishAt = nil
+	}
+	return menu, nil
+}
+
+// menuToPublish is what publishing a menu would serve: its draft, or the menu
+// itself while it has never been published. The message says why there is
+// nothing to publish.
+func menuToPublish(menu models.Menu) (models.MenuContent, string) {
+	if menu.Draft != nil {
+		return *menu.Draft, ""
+	}
+	if menu.Status != nil && *menu.Status == "DRAFT" {
+		return menuContentOf(menu), ""
+	}
+	return models.MenuContent{}, "menu has no draft to publish"
+}
+
+func menuContentOf(menu models.Menu) models.MenuContent {
+	return models.MenuContent{
+		Name:      menu.Name,
+		Category:  menu.Category,
+		StartDate: menu.StartDate,
+		EndDate:   menu.EndDate,
+		Schedules: menu.Schedules,
+	}
+}
+
+func newMenuVersion(menuID string, version int, content models.MenuContent, publishedBy string, rolledBackFrom *int) models.MenuVersion {
+	record := models.MenuVersion{
+		ID:             primitive.NewObjectID(),
+		MenuID:         menuID,
+		Version:        version,
+		Content:        content,
+		RolledBackFrom: rolledBackFrom,
+		PublishedBy:    publishedBy,
+	}
+	record.PublishedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	record.MenuVersionID = record.ID.Hex()
+	return record
+}
+
+// findMenu loads the menu named in the path, answering with 404 or 500 and
+// returning false when it can't.
+func findMenu(c *gin.Context, ctx context.Context) (models.Menu, bool) {
+	var menu models.Menu
+	err := menuCollection.FindOne(ctx, bson.M{"menu_id": c.Param("menu_id")}).Decode(&menu)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Menu not found"})
+			return menu, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching menu details"})
+		return menu, false
+	}
+	return menu, true
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"atm1504.in/rms/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// legacyMoneyTypes are the BSON types earlier versions stored amounts as.
+var legacyMoneyTypes = bson.A{"double", "int", "long", "decimal", "string"}
+
+// MigrateMoney rewrites amounts stored as plain numbers by earlier versions
+// as {cents, currency} documents in the default currency. Only documents
+// still holding such amounts are touched, so it is cheap to run at every
+// start. A field written as "lines.amount" is the amount of every element of
+// the lines array.
+func MigrateMoney(ctx context.Context) error {
+	migrations := []struct {
+		collection *mongo.Collection
+		fields     []string
+	}{
+		{foodCollection, []string{"price"}},
+		{orderItemCollection, []string{"unit_price"}},
+		{orderCollection, []string{"delivery_fee"}},
+		{paymentCollection, []string{"amount", "tendered", "change", "tip", "refunded_amount"}},
+		{creditNoteCollection, []string{"amount", "lines.amount", "provider_refunds.amount"}},
+		{promotionCollection, []string{"min_order_amount"}},
+	}
+	for _, migration := range migrations {
+		for _, field := range migration.fields {
+			if err := migrateMoneyField(ctx, migration.collection, field); err != nil {
+				return fmt.Errorf("migrating %s.%s: %w", migration.collection.Name(), field, err)
+			}
+		}
+	}
+	if err := migrateFixedPromotions(ctx); err != nil {
+		return fmt.Errorf("migrating promotion.value: %w", err)
+	}
+	return nil
+}
+
+// migrateFixedPromotions moves the value of FIXED promotions, which earlier
+// versions kept as a plain number next to percentages, to their amount.
+func migrateFixedPromotions(ctx context.Context) error {
+	cursor, err := promotionCollection.Find(ctx, bson.M{
+		"discount_type": "FIXED",
+		"amount":        bson.M{"$exists": false},
+		"value":         bson.M{"$type": legacyMoneyTypes},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var amount money.Money
+		raw := cursor.Current.Lookup("value")
+		if err := amount.UnmarshalBSONValue(raw.Type, raw.Value); err != nil {
+			return err
+		}
+		_, err = promotionCollection.UpdateOne(ctx,
+			bson.M{"_id": cursor.Current.Lookup("_id")},
+			bson.D{
+				{Key: "$set", Value: bson.D{{Key: "amount", Value: amount}}},
+				{Key: "$unset", Value: bson.D{{Key: "value", Value: ""}}},
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func migrateMoneyField(ctx context.Context, collection *mongo.Collection, field string) error {
+	cursor, err := collection.Find(ctx, bson.M{field: bson.M{"$type": legacyMoneyTypes}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	array, key, nested := strings.Cut(field, ".")
+	for cursor.Next(ctx) {
+		target, value := field, interface{}(nil)
+		if nested {
+			var elements []bson.D
+			if err := cursor.Current.Lookup(array).Unmarshal(&elements); err != nil {
+				return err
+			}
+			for _, element := range elements {
+				for i := range element {
+					if element[i].Key != key || !money.Legacy(element[i].Value) {
+						continue
+					}
+					if element[i].Value, err = legacyMoney(element[i].Value); err != nil {
+						return err
+					}
+				}
+			}
+			target, value = array, elements
+		} else {
+			var amount money.Money
+			raw := cursor.Current.Lookup(field)
+			if err := amount.UnmarshalBSONValue(raw.Type, raw.Value); err != nil {
+				return err
+			}
+			value = amount
+		}
+
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": cursor.Current.Lookup("_id")},
+			bson.D{{Key: "$set", Value: bson.D{{Key: target, Value: value}}}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func legacyMoney(value interface{}) (money.Money, error) {
+	var amount money.Money
+	t, data, err := bson.MarshalValue(value)
+	if err != nil {
+		return amount, err
+	}
+	err = amount.UnmarshalBSONValue(t, data)
+	return amount, err
+}
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var noteCollection *mongo.Collection = database.OpenCollection(database.Client, "note")
+
+// noteEntities maps a note entity type to the collection and id field it refers to.
+var noteEntities = map[string]struct {
+	collection *mongo.Collection
+	idField    string
+}{
+	"ORDER":      {orderCollection, "order_id"},
+	"ORDER_ITEM": {orderItemCollection, "order_item_id"},
+	"TABLE":      {tableCollection, "table_id"},
+	"INVOICE":    {invoiceCollection, "invoice_id"},
+}
+
+func GetNotes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if entityType := c.Query("entity_type"); entityType != "" {
+			filter["entity_type"] = strings.ToUpper(entityType)
+		}
+		if entityID := c.Query("entity_id"); entityID != "" {
+			filter["entity_id"] = entityID
+		}
+		if noteType := c.Query("note_type"); noteType != "" {
+			filter["note_type"] = strings.ToUpper(noteType)
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		result, err := noteCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notes"})
+			return
+		}
+
+		allNotes := []models.Note{}
+		if err = result.All(ctx, &allNotes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing notes"})
+			return
+		}
+		c.JSON(http.StatusOK, allNotes)
+	}
+}
+
+func GetNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		noteID := c.Param("note_id")
+		var note models.Note
+
+		err := noteCollection.FindOne(ctx, bson.M{"note_id": noteID}).Decode(&note)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "Note not found",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching note details"})
+			return
+		}
+		c.JSON(http.StatusOK, note)
+	}
+}
+
+func CreateNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var note models.Note
+
+		if err := c.BindJSON(&note); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(note)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		entity := noteEntities[*note.EntityType]
+		count, err := entity.collection.CountDocuments(ctx, bson.M{entity.idField: note.EntityID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching note entity"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": strings.ToLower(*note.EntityType) + " not found"})
+			return
+		}
+
+		note.AuthorID = c.GetString("uid")
+		note.AuthorEmail = c.GetString("email")
+		note.AuthorName = strings.TrimSpace(c.GetString("first_name") + " " + c.GetString("last_name"))
+
+		note.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		note.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		note.ID = primitive.NewObjectID()
+		note.NoteID = note.ID.Hex()
+
+		_, insertErr := noteCollection.InsertOne(ctx, note)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Note was not created"})
+			return
+		}
+		c.JSON(http.StatusCreated, note)
+	}
+}
+
+func UpdateNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var note models.Note
+		noteID := c.Param("note_id")
+
+		if !canChangeNote(c, ctx, noteID) {
+			return
+		}
+		if err := c.BindJSON(&note); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if note.Text != "" {
+			updateObj = append(updateObj, bson.E{Key: "text", Value: note.Text})
+		}
+		if note.Title != "" {
+			updateObj = append(updateObj, bson.E{Key: "title", Value: note.Title})
+		}
+		if note.NoteType != nil {
+			if err := validate.Var(*note.NoteType, "eq=GENERAL|eq=SPECIAL_REQUEST|eq=ALLERGY|eq=COMPLAINT"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid note_type"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "note_type", Value: note.NoteType})
+		}
+
+		note.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: note.UpdatedAt})
+
+		result, err := noteCollection.UpdateOne(
+			ctx,
+			bson.M{"note_id": noteID},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "note update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Note not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func DeleteNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		noteID := c.Param("note_id")
+
+		if !canChangeNote(c, ctx, noteID) {
+			return
+		}
+		result, err := noteCollection.DeleteOne(ctx, bson.M{"note_id": noteID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "note deletion failed"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Note not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// canChangeNote answers and returns false unless the note exists and the
+// caller wrote it or is a manager. Allergy notes are relied on by the
+// kitchen, so nobody else may edit or remove them.
+func canChangeNote(c *gin.Context, ctx context.Context, noteID string) bool {
+	var note models.Note
+	err := noteCollection.FindOne(ctx, bson.M{"note_id": noteID}).Decode(&note)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Note not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching note details"})
+		return false
+	}
+	if note.AuthorID != "" && note.AuthorID == c.GetString("uid") {
+		return true
+	}
+	return requireManager(c, ctx, "only the author or a manager can change this note")
+}
+
+// NotesFor returns the notes attached to any of the given entity ids, oldest first.
+func NotesFor(ctx context.Context, entityType string, entityIDs []string) ([]models.Note, error) {
+	notes := []models.Note{}
+	if len(entityIDs) == 0 {
+		return notes, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	result, err := noteCollection.Find(ctx, bson.M{
+		"entity_type": entityType,
+		"entity_id":   bson.M{"$in": entityIDs},
+	}, opts)
+	if err != nil {
+		return notes, err
+	}
+	err = result.All(ctx, &notes)
+	return notes, err
+}
@@ -3,73 +3,55 @@ package controller
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
 	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
 	"atm1504.in/rms/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var orderCollection *mongo.Collection = database.OpenCollection(database.Client, "order")
-var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+
+var orderListSpec = listSpec{
+	filters: []listFilter{
+		{param: "status", field: "status", upper: true},
+		{param: "order_type", field: "order_type", upper: true},
+		{param: "delivery_status", field: "delivery_status", upper: true},
+		{param: "table_id", field: "table_id"},
+		{param: "server_id", field: "server_id"},
+	},
+	dateField:    "order_date",
+	searchFields: []string{"customer_name", "customer_phone", "order_id"},
+	sorts: map[string]string{
+		"order_date": "order_date",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	defaultSort: "order_date",
+}
 
 func GetOrders() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		recordPerPage, err := strconv.Atoi(c.DefaultQuery("recordPerPage", "10"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
-		}
-
-		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-		if err != nil || page < 1 {
-			page = 1
-		}
-
-		startIndex := (page - 1) * recordPerPage
-
-		matchStage := bson.D{{Key: "$match", Value: bson.D{}}}
-		groupStage := bson.D{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: nil},
-			{Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}},
-			{Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
-		}}}
-		projectStage := bson.D{
-			{Key: "$project", Value: bson.D{
-				{Key: "_id", Value: 0},
-				{Key: "total_count", Value: 1},
-				{Key: "orders", Value: bson.D{{Key: "$slice", Value: []interface{}{"$data", startIndex, recordPerPage}}}},
-			}},
+		query, ok := listRequest(c, ctx, orderListSpec)
+		if !ok {
+			return
 		}
-
-		result, err := orderCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, projectStage})
-		defer cancel()
+		allOrders := []models.Order{}
+		page, err := findPage(ctx, orderCollection, query, &allOrders, nil)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing menus"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing orders"})
 			return
 		}
-
-		var allOrders []bson.M
-		if err = result.All(ctx, &allOrders); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while processing menus"})
-			log.Fatal(err)
-		}
-
-		// Assuming you want to return the list of menus directly
-		if len(allOrders) > 0 {
-			c.JSON(http.StatusOK, allOrders[0])
-		} else {
-			c.JSON(http.StatusOK, []interface{}{}) // Return an empty array if no menus
-		}
+		c.JSON(http.StatusOK, pageResponse("orders", allOrders, query, page))
 	}
 }
 
@@ -100,7 +82,6 @@ func GetOrder() gin.HandlerFunc {
 func CreateOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		var table models.Table
 		var order models.Order
 
 		if err := c.BindJSON(&order); err != nil {
@@ -116,19 +97,15 @@ func CreateOrder() gin.HandlerFunc {
 			return
 		}
 
-		if order.TableID != nil {
-			err := tableCollection.FindOne(context.Background(), bson.M{"table_id": order.TableID}).Decode(&table)
+		if status, msg := checkOrderType(ctx, &order); msg != "" {
 			defer cancel()
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					c.JSON(http.StatusNotFound, gin.H{
-						"message": "Table data not found",
-					})
-					return
-				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching table details"})
-				return
-			}
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		if status, msg := checkServer(ctx, order.ServerID); msg != "" {
+			defer cancel()
+			c.JSON(status, gin.H{"error": msg})
+			return
 		}
 
 		order.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -136,6 +113,9 @@ func CreateOrder() gin.HandlerFunc {
 
 		order.ID = primitive.NewObjectID()
 		order.OrderID = order.ID.Hex()
+		status := "OPEN"
+		order.Status = &status
+		order.Adjustments = nil
 
 		result, insertErr := orderCollection.InsertOne(ctx, order)
 		defer cancel()
@@ -154,74 +134,206 @@ func UpdateOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
-		var table models.Table
-		var order models.Order
-
-		var updateObj primitive.D
+		var patch models.Order
 
 		orderID := c.Param("order_id")
 
-		if err := c.BindJSON(&order); err != nil {
+		if err := c.BindJSON(&patch); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			defer cancel()
 			return
 		}
 
-		if order.TableID != nil {
-			err := tableCollection.FindOne(ctx, bson.M{"table_id": order.TableID}).Decode(&table)
+		if validationErr := validate.StructPartial(patch, "OrderType", "DeliveryFee", "DeliveryStatus", "Allergies"); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			defer cancel()
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					c.JSON(http.StatusNotFound, gin.H{
-						"message": "Table data not found",
-					})
-					return
-				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching table details"})
+			return
+		}
+
+		filter := bson.M{"order_id": orderID}
+		order, status, msg := findOpenOrder(ctx, orderID)
+		defer cancel()
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		if patch.OrderType != nil {
+			order.OrderType = patch.OrderType
+			// an order leaving its table takes the table_id with it
+			if *patch.OrderType != "DINE_IN" && patch.TableID == nil {
+				order.TableID = nil
+			}
+		}
+		// an empty table_id clears it
+		if patch.TableID != nil {
+			order.TableID = patch.TableID
+		}
+		if patch.CustomerName != nil {
+			order.CustomerName = patch.CustomerName
+		}
+		if patch.CustomerPhone != nil {
+			order.CustomerPhone = patch.CustomerPhone
+		}
+		if patch.PickupTime != nil {
+			order.PickupTime = patch.PickupTime
+		}
+		if patch.DeliveryAddress != nil {
+			order.DeliveryAddress = patch.DeliveryAddress
+		}
+		if patch.DeliveryFee != nil {
+			order.DeliveryFee = patch.DeliveryFee
+		}
+		if patch.DeliveryStatus != nil {
+			order.DeliveryStatus = patch.DeliveryStatus
+		}
+		if patch.Allergies != nil {
+			order.Allergies = patch.Allergies
+		}
+		if patch.ServerID != nil {
+			if status, msg := checkServer(ctx, patch.ServerID); msg != "" {
+				c.JSON(status, gin.H{"error": msg})
 				return
 			}
-			updateObj = append(updateObj, bson.E{Key: "table_id", Value: order.TableID})
+			order.ServerID = patch.ServerID
+		}
+
+		if status, msg := checkOrderType(ctx, &order); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
 		}
 
+		var updateObj primitive.D
+		updateObj = append(updateObj, bson.E{Key: "order_type", Value: order.OrderType})
+		updateObj = append(updateObj, bson.E{Key: "table_id", Value: order.TableID})
+		updateObj = append(updateObj, bson.E{Key: "customer_name", Value: order.CustomerName})
+		updateObj = append(updateObj, bson.E{Key: "customer_phone", Value: order.CustomerPhone})
+		updateObj = append(updateObj, bson.E{Key: "pickup_time", Value: order.PickupTime})
+		updateObj = append(updateObj, bson.E{Key: "delivery_address", Value: order.DeliveryAddress})
+		updateObj = append(updateObj, bson.E{Key: "delivery_fee", Value: order.DeliveryFee})
+		updateObj = append(updateObj, bson.E{Key: "delivery_status", Value: order.DeliveryStatus})
+		updateObj = append(updateObj, bson.E{Key: "server_id", Value: order.ServerID})
+		updateObj = append(updateObj, bson.E{Key: "allergies", Value: order.Allergies})
+
 		order.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: order.UpdatedAt})
 
-		upsert := true
-		filter := bson.M{"order_id": orderID}
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
-		}
-
 		result, err := orderCollection.UpdateOne(
 			ctx, filter, bson.D{
 				{Key: "$set", Value: updateObj},
 			},
-			&opt,
 		)
 
-		defer cancel()
 		if err != nil {
 			msg := "order item update failed"
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		// delivery fees and customer details are on the bill
+		if err := rebillOrderInvoices(ctx, orderID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice could not be billed again"})
+			return
+		}
 		c.JSON(http.StatusOK, result)
 
 	}
 }
 
-func OrderItemOrderCreator(order models.Order) string {
+// checkServer checks that the server an order is assigned to is a known user.
+func checkServer(ctx context.Context, serverID *string) (status int, msg string) {
+	if serverID == nil {
+		return http.StatusOK, ""
+	}
+	if _, err := helper.UserByID(ctx, *serverID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return http.StatusNotFound, "Server not found"
+		}
+		return http.StatusInternalServerError, "Error in fetching user details"
+	}
+	return http.StatusOK, ""
+}
+
+// checkOrderType fills in the order type defaults and checks that the order
+// carries what its type needs: a table for dine-in, a contact and pickup time
+// for takeaway, and an address and fee for delivery. A non-empty msg is
+// returned together with the HTTP status to report.
+func checkOrderType(ctx context.Context, order *models.Order) (status int, msg string) {
+	if order.OrderType == nil {
+		orderType := "DINE_IN"
+		order.OrderType = &orderType
+	}
+	if order.TableID != nil && *order.TableID == "" {
+		order.TableID = nil
+	}
+
+	switch *order.OrderType {
+	case "DINE_IN":
+		if order.TableID == nil || *order.TableID == "" {
+			return http.StatusBadRequest, "table_id is required for dine-in orders"
+		}
+		var table models.Table
+		err := tableCollection.FindOne(ctx, bson.M{"table_id": order.TableID}).Decode(&table)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return http.StatusNotFound, "Table data not found"
+			}
+			return http.StatusInternalServerError, "Error in fetching table details"
+		}
+	case "TAKEAWAY":
+		if order.TableID != nil {
+			return http.StatusBadRequest, "takeaway orders cannot have a table_id"
+		}
+		if isBlank(order.CustomerName) && isBlank(order.CustomerPhone) {
+			return http.StatusBadRequest, "customer_name or customer_phone is required for takeaway orders"
+		}
+		if order.PickupTime == nil {
+			return http.StatusBadRequest, "pickup_time is required for takeaway orders"
+		}
+	case "DELIVERY":
+		if order.TableID != nil {
+			return http.StatusBadRequest, "delivery orders cannot have a table_id"
+		}
+		if isBlank(order.DeliveryAddress) {
+			return http.StatusBadRequest, "delivery_address is required for delivery orders"
+		}
+		if order.DeliveryFee == nil {
+			return http.StatusBadRequest, "delivery_fee is required for delivery orders"
+		}
+		if msg := baseCurrencyError("delivery_fee", order.DeliveryFee); msg != "" {
+			return http.StatusBadRequest, msg
+		}
+		if order.DeliveryStatus == nil {
+			deliveryStatus := "PENDING"
+			order.DeliveryStatus = &deliveryStatus
+		}
+	}
+
+	if *order.OrderType != "DELIVERY" {
+		order.DeliveryAddress = nil
+		order.DeliveryFee = nil
+		order.DeliveryStatus = nil
+	}
+	return http.StatusOK, ""
+}
+
+func isBlank(value *string) bool {
+	return value == nil || strings.TrimSpace(*value) == ""
+}
+
+func OrderItemOrderCreator(ctx context.Context, order models.Order) (string, error) {
 
 	order.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	order.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	order.ID = primitive.NewObjectID()
 	order.OrderID = order.ID.Hex()
+	status := "OPEN"
+	order.Status = &status
+	order.Adjustments = nil
 
-	err, _ := orderCollection.InsertOne(ctx, order)
+	_, err := orderCollection.InsertOne(ctx, order)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	defer cancel()
 
-	return order.OrderID
+	return order.OrderID, nil
 }
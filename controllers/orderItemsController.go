@@ -4,41 +4,62 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
 	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var orderItemCollection *mongo.Collection = database.OpenCollection(database.Client, "orderItem")
 
 type OrderItemPack struct {
-	TableID    *string            `bson:"table_id" json:"table_id"`
-	OrderItems []models.OrderItem `bson:"order_items" json:"order_items"`
+	models.Order `bson:",inline"`
+	OrderItems   []models.OrderItem `bson:"order_items" json:"order_items"`
+}
+
+var orderItemListSpec = listSpec{
+	filters: []listFilter{
+		{param: "order_id", field: "order_id"},
+		{param: "food_id", field: "food_id"},
+		{param: "status", field: "status", upper: true},
+		{param: "quantity", field: "quantity", upper: true},
+		{param: "course", field: "course", numeric: true},
+		{param: "seat", field: "seat", numeric: true},
+	},
+	priceField: "unit_price",
+	dateField:  "created_at",
+	sorts: map[string]string{
+		"unit_price": "unit_price.cents",
+		"course":     "course",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	defaultSort: "created_at",
 }
 
 func GetOrderItems() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		result, err := orderItemCollection.Find(context.TODO(), bson.M{})
-
 		defer cancel()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing ordered items"})
+
+		query, ok := listRequest(c, ctx, orderItemListSpec)
+		if !ok {
 			return
 		}
-		var allOrderItems []bson.M
-		if err = result.All(ctx, &allOrderItems); err != nil {
-			log.Fatal(err)
+		allOrderItems := []models.OrderItem{}
+		page, err := findPage(ctx, orderItemCollection, query, &allOrderItems, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing ordered items"})
 			return
 		}
-		c.JSON(http.StatusOK, allOrderItems)
+		c.JSON(http.StatusOK, pageResponse("order_items", allOrderItems, query, page))
 	}
 }
 
@@ -78,13 +99,47 @@ func GetOrderItemsByOrder() gin.HandlerFunc {
 	}
 }
 
-func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+// OrderLine is one order item joined with its food, menu, order and table,
+// as used for totals and invoices.
+type OrderLine struct {
+	OrderItemID     string              `bson:"order_item_id" json:"order_item_id"`
+	OrderID         string              `bson:"order_id" json:"order_id"`
+	FoodID          string              `bson:"food_id" json:"food_id"`
+	FoodName        string              `bson:"food_name" json:"food_name"`
+	FoodImage       string              `bson:"food_image" json:"food_image"`
+	MenuID          string              `bson:"menu_id" json:"menu_id"`
+	Category        string              `bson:"category" json:"category"`
+	TaxRateIDs      []string            `bson:"tax_rate_ids" json:"tax_rate_ids"`
+	TableNumber     *int                `bson:"table_number" json:"table_number"`
+	TableID         *string             `bson:"table_id" json:"table_id"`
+	OrderType       string              `bson:"order_type" json:"order_type"`
+	CustomerName    *string             `bson:"customer_name" json:"customer_name"`
+	CustomerPhone   *string             `bson:"customer_phone" json:"customer_phone"`
+	DeliveryAddress *string             `bson:"delivery_address" json:"delivery_address"`
+	DeliveryFee     *money.Money        `bson:"delivery_fee" json:"delivery_fee"`
+	Price           money.Money         `bson:"price" json:"price"`
+	Amount          money.Money         `bson:"amount" json:"amount"`
+	Quantity        string              `bson:"quantity" json:"quantity"`
+	Status          string              `bson:"status" json:"status"`
+	Adjustments     []models.Adjustment `bson:"adjustments" json:"adjustments,omitempty"`
+	Course          int                 `bson:"course" json:"course"`
+	Seat            *int                `bson:"seat" json:"seat"`
+	Hold            bool                `bson:"hold" json:"hold"`
+	FiredAt         *time.Time          `bson:"fired_at" json:"fired_at"`
+	CreatedAt       time.Time           `bson:"created_at" json:"created_at"`
+}
 
+// orderLinePipeline joins every item of an order with its food, menu, order
+// and table. Voided lines are kept out of amount so they can be reported on
+// their own, comped lines stay on the order at no charge.
+func orderLinePipeline(id string) mongo.Pipeline {
 	matchStage := bson.D{{Key: "$match", Value: bson.D{{Key: "order_id", Value: id}}}}
 	lookupStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "food"}, {Key: "localField", Value: "food_id"}, {Key: "foreignField", Value: "food_id"}, {Key: "as", Value: "food"}}}}
 	unwindStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$food"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
 
+	lookupMenuStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "menu"}, {Key: "localField", Value: "food.menu_id"}, {Key: "foreignField", Value: "menu_id"}, {Key: "as", Value: "menu"}}}}
+	unwindMenuStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$menu"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
+
 	lookupOrderStage := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "order"}, {Key: "localField", Value: "order_id"}, {Key: "foreignField", Value: "order_id"}, {Key: "as", Value: "order"}}}}
 	unwindOrderStage := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$order"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
 
@@ -93,54 +148,156 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 
 	projectStage := bson.D{
 		{Key: "$project", Value: bson.D{
-			{Key: "id", Value: 0},
-			{Key: "amount", Value: "$food.price"},
+			{Key: "_id", Value: 0},
 			{Key: "total_count", Value: 1},
+			{Key: "food_id", Value: 1},
 			{Key: "food_name", Value: "$food.name"},
 			{Key: "food_image", Value: "$food.food_image"},
+			{Key: "menu_id", Value: "$food.menu_id"},
+			{Key: "category", Value: "$menu.category"},
+			{Key: "tax_rate_ids", Value: "$food.tax_rate_ids"},
 			{Key: "table_number", Value: "$table.table_number"},
 			{Key: "table_id", Value: "$table.table_id"},
 			{Key: "order_id", Value: "$order.order_id"},
+			{Key: "order_type", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$order.order_type", "DINE_IN"}}}},
+			{Key: "customer_name", Value: "$order.customer_name"},
+			{Key: "customer_phone", Value: "$order.customer_phone"},
+			{Key: "delivery_address", Value: "$order.delivery_address"},
+			{Key: "delivery_fee", Value: "$order.delivery_fee"},
 			{Key: "price", Value: "$food.price"},
 			{Key: "quantity", Value: 1},
+			{Key: "order_item_id", Value: 1},
+			{Key: "status", Value: "$status"},
+			{Key: "adjustments", Value: 1},
+			{Key: "course", Value: 1},
+			{Key: "seat", Value: 1},
+			{Key: "hold", Value: 1},
+			{Key: "fired_at", Value: 1},
+			{Key: "created_at", Value: 1},
 		}}}
 
-	groupStage := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "order_id", Value: "$order_id"}, {Key: "table_id", Value: "$table_id"}, {Key: "table_number", Value: "$table_number"}}}, {Key: "payment_due", Value: bson.D{{Key: "$sum", Value: "$amount"}}}, {Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}}, {Key: "order_items", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}}}}}
-
-	projectStage2 := bson.D{
-		{Key: "$project", Value: bson.D{
-
-			{Key: "id", Value: 0},
-			{Key: "payment_due", Value: 1},
-			{Key: "total_count", Value: 1},
-			{Key: "table_number", Value: "$_id.table_number"},
-			{Key: "order_items", Value: 1},
-		}}}
-
-	result, err := orderItemCollection.Aggregate(ctx, mongo.Pipeline{
+	statusStage := bson.D{{Key: "$addFields", Value: bson.D{
+		{Key: "status", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$status", "ACTIVE"}}}},
+	}}}
+	amountStage := bson.D{{Key: "$addFields", Value: bson.D{
+		{Key: "amount", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{"$status", "ACTIVE"}}},
+			"$price",
+			bson.D{{Key: "cents", Value: 0}, {Key: "currency", Value: "$price.currency"}},
+		}}}},
+	}}}
+
+	return mongo.Pipeline{
 		matchStage,
 		lookupStage,
 		unwindStage,
+		lookupMenuStage,
+		unwindMenuStage,
 		lookupOrderStage,
 		unwindOrderStage,
 		lookupTableStage,
 		unwindTableStage,
 		projectStage,
-		groupStage,
-		projectStage2})
+		statusStage,
+		amountStage,
+	}
+}
 
+// OrderLines returns the joined lines of an order, voided ones included.
+func OrderLines(ctx context.Context, id string) ([]OrderLine, error) {
+	lines := []OrderLine{}
+	result, err := orderItemCollection.Aggregate(ctx, orderLinePipeline(id))
 	if err != nil {
-		panic(err)
+		return lines, err
 	}
+	err = result.All(ctx, &lines)
+	return lines, err
+}
 
-	if err = result.All(ctx, &OrderItems); err != nil {
-		panic(err)
-	}
+// OrderItemsSummary is an order's items with their totals. Amounts are
+// summed in cents.
+type OrderItemsSummary struct {
+	ItemsTotal      money.Money  `bson:"items_total" json:"items_total"`
+	PaymentDue      money.Money  `bson:"payment_due" json:"payment_due"`
+	TotalCount      int          `bson:"total_count" json:"total_count"`
+	TableNumber     *int         `bson:"table_number" json:"table_number"`
+	OrderType       string       `bson:"order_type" json:"order_type"`
+	CustomerName    *string      `bson:"customer_name" json:"customer_name"`
+	CustomerPhone   *string      `bson:"customer_phone" json:"customer_phone"`
+	DeliveryAddress *string      `bson:"delivery_address" json:"delivery_address"`
+	DeliveryFee     *money.Money `bson:"delivery_fee" json:"delivery_fee"`
+	OrderItems      []OrderLine  `bson:"order_items" json:"order_items"`
+	VoidedItems     []OrderLine  `bson:"voided_items" json:"voided_items"`
+	VoidedTotal     money.Money  `bson:"voided_total" json:"voided_total"`
+	VoidedCount     int          `bson:"voided_count" json:"voided_count"`
+	CompedTotal     money.Money  `bson:"comped_total" json:"comped_total"`
+	CompedCount     int          `bson:"comped_count" json:"comped_count"`
+}
 
+func ItemsByOrder(id string) (OrderItems []OrderItemsSummary, err error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
 
-	return OrderItems, err
+	centsIn := func(cents interface{}) bson.D {
+		return bson.D{{Key: "cents", Value: cents}, {Key: "currency", Value: "$currency"}}
+	}
+	sumWhereStatus := func(status string, value interface{}) bson.D {
+		return bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$status", status}}}, value, 0}}}}}
+	}
+	groupStage := bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: bson.D{{Key: "order_id", Value: "$order_id"}, {Key: "table_id", Value: "$table_id"}, {Key: "table_number", Value: "$table_number"}}},
+		{Key: "payment_due", Value: bson.D{{Key: "$sum", Value: "$amount.cents"}}},
+		{Key: "currency", Value: bson.D{{Key: "$first", Value: "$price.currency"}}},
+		{Key: "total_count", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$status", "VOIDED"}}}, 0, 1}}}}}},
+		{Key: "voided_total", Value: sumWhereStatus("VOIDED", "$price.cents")},
+		{Key: "voided_count", Value: sumWhereStatus("VOIDED", 1)},
+		{Key: "comped_total", Value: sumWhereStatus("COMPED", "$price.cents")},
+		{Key: "comped_count", Value: sumWhereStatus("COMPED", 1)},
+		{Key: "order_type", Value: bson.D{{Key: "$first", Value: "$order_type"}}},
+		{Key: "customer_name", Value: bson.D{{Key: "$first", Value: "$customer_name"}}},
+		{Key: "customer_phone", Value: bson.D{{Key: "$first", Value: "$customer_phone"}}},
+		{Key: "delivery_address", Value: bson.D{{Key: "$first", Value: "$delivery_address"}}},
+		{Key: "delivery_fee", Value: bson.D{{Key: "$first", Value: "$delivery_fee"}}},
+		{Key: "order_items", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
+	}}}
+
+	projectStage2 := bson.D{
+		{Key: "$project", Value: bson.D{
 
+			{Key: "_id", Value: 0},
+			{Key: "items_total", Value: centsIn("$payment_due")},
+			{Key: "payment_due", Value: centsIn(bson.D{{Key: "$add", Value: bson.A{"$payment_due", bson.D{{Key: "$ifNull", Value: bson.A{"$delivery_fee.cents", 0}}}}}})},
+			{Key: "total_count", Value: 1},
+			{Key: "table_number", Value: "$_id.table_number"},
+			{Key: "order_type", Value: 1},
+			{Key: "customer_name", Value: 1},
+			{Key: "customer_phone", Value: 1},
+			{Key: "delivery_address", Value: 1},
+			{Key: "delivery_fee", Value: 1},
+			{Key: "order_items", Value: bson.D{{Key: "$filter", Value: bson.D{
+				{Key: "input", Value: "$order_items"},
+				{Key: "as", Value: "item"},
+				{Key: "cond", Value: bson.D{{Key: "$ne", Value: bson.A{"$$item.status", "VOIDED"}}}},
+			}}}},
+			{Key: "voided_items", Value: bson.D{{Key: "$filter", Value: bson.D{
+				{Key: "input", Value: "$order_items"},
+				{Key: "as", Value: "item"},
+				{Key: "cond", Value: bson.D{{Key: "$eq", Value: bson.A{"$$item.status", "VOIDED"}}}},
+			}}}},
+			{Key: "voided_total", Value: centsIn("$voided_total")},
+			{Key: "voided_count", Value: 1},
+			{Key: "comped_total", Value: centsIn("$comped_total")},
+			{Key: "comped_count", Value: 1},
+		}}}
+
+	pipeline := append(orderLinePipeline(id), groupStage, projectStage2)
+	result, err := orderItemCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return OrderItems, err
+	}
+
+	err = result.All(ctx, &OrderItems)
+	return OrderItems, err
 }
 
 func CreateOrderItem() gin.HandlerFunc {
@@ -148,7 +305,6 @@ func CreateOrderItem() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
 		var orderItemPack OrderItemPack
-		var order models.Order
 
 		if err := c.BindJSON(&orderItemPack); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -156,66 +312,233 @@ func CreateOrderItem() gin.HandlerFunc {
 			return
 		}
 
+		order := orderItemPack.Order
 		order.OrderDate, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		orderItemsToBeInserted := []interface{}{}
-		order.TableID = orderItemPack.TableID
-		orderID := OrderItemOrderCreator(order)
-
-		for _, orderItem := range orderItemPack.OrderItems {
-			orderItem.OrderID = orderID
-			validationErr := validate.Struct((orderItem))
-			if validationErr != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-				defer cancel()
-				return
-			}
-			orderItem.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			orderItem.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			orderItem.ID = primitive.NewObjectID()
-			orderItem.OrderItemID = orderItem.ID.Hex()
-			var num = toFixed(*orderItem.UnitPrice, 2)
-			orderItem.UnitPrice = &num
-			orderItemsToBeInserted = append(orderItemsToBeInserted, orderItem)
-		}
-		insertedOrderItems, err := orderItemCollection.InsertMany(ctx, orderItemsToBeInserted)
-		if err != nil {
-			log.Fatal(err)
+		if validationErr := validate.Struct(order); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			defer cancel()
+			return
+		}
+		if status, msg := checkOrderType(ctx, &order); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			defer cancel()
+			return
 		}
+
+		if msg := validateNewOrderItems(orderItemPack.OrderItems); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			defer cancel()
+			return
+		}
+		if status, msg := checkFoodsAvailable(ctx, orderItemPack.OrderItems, time.Now()); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			defer cancel()
+			return
+		}
+		warnings, status, msg := checkAllergens(ctx, order.Allergies, orderItemPack.OrderItems)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			defer cancel()
+			return
+		}
+
+		orderID, err := OrderItemOrderCreator(ctx, order)
 		defer cancel()
-		c.JSON(http.StatusOK, insertedOrderItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order was not created"})
+			return
+		}
+
+		insertedIDs, err := insertOrderItems(ctx, orderID, orderItemPack.OrderItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order items were not created"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID, "inserted_ids": insertedIDs, "warnings": allergenWarnings(warnings, insertedIDs)})
+	}
+}
+
+func validateNewOrderItems(orderItems []models.OrderItem) string {
+	if len(orderItems) == 0 {
+		return "order_items must not be empty"
+	}
+	for _, orderItem := range orderItems {
+		validationErr := validate.StructExcept(orderItem, "OrderID")
+		if validationErr != nil {
+			return validationErr.Error()
+		}
+		if msg := baseCurrencyError("unit_price", orderItem.UnitPrice); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// AllergenWarning flags an order item with something in it that the guests
+// declared an allergy to.
+type AllergenWarning struct {
+	OrderItemID string   `json:"order_item_id"`
+	FoodID      string   `json:"food_id"`
+	FoodName    string   `json:"food_name"`
+	Allergens   []string `json:"allergens"`
+	item        int
+}
+
+// checkAllergens makes sure the modifiers ordered are offered with their
+// foods and warns about items containing the declared allergies. The
+// warnings don't stop the order; it is up to the server to check with the
+// guests.
+func checkAllergens(ctx context.Context, allergies []string, orderItems []models.OrderItem) ([]AllergenWarning, int, string) {
+	var warnings []AllergenWarning
+	for i, orderItem := range orderItems {
+		var food models.Food
+		if err := foodCollection.FindOne(ctx, bson.M{"food_id": orderItem.FoodID}).Decode(&food); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return warnings, http.StatusNotFound, "food " + *orderItem.FoodID + " not found"
+			}
+			return warnings, http.StatusInternalServerError, "Error in fetching food details"
+		}
+		for _, name := range orderItem.Modifiers {
+			if _, ok := helper.FoodModifier(food, name); !ok {
+				return warnings, http.StatusBadRequest, *food.Name + " has no modifier " + name
+			}
+		}
+		if conflicts := helper.AllergenConflicts(allergies, food, orderItem.Modifiers); len(conflicts) > 0 {
+			warnings = append(warnings, AllergenWarning{FoodID: food.FoodID, FoodName: *food.Name, Allergens: conflicts, item: i})
+		}
+	}
+	return warnings, http.StatusOK, ""
+}
+
+// allergenWarnings fills in the ids of the items warned about once they are
+// stored.
+func allergenWarnings(warnings []AllergenWarning, insertedIDs []string) []AllergenWarning {
+	for i := range warnings {
+		warnings[i].OrderItemID = insertedIDs[warnings[i].item]
+	}
+	if warnings == nil {
+		return []AllergenWarning{}
+	}
+	return warnings
+}
+
+// insertOrderItems stores already validated items on an order, prints a
+// kitchen ticket for the ones not held and schedules the next held course
+// when auto-fire is enabled.
+func insertOrderItems(ctx context.Context, orderID string, orderItems []models.OrderItem) ([]string, error) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	firedCourse := 0
+	insertedIDs := []string{}
+	firedIDs := []string{}
+	orderItemsToBeInserted := []interface{}{}
+	for _, orderItem := range orderItems {
+		orderItem.OrderID = orderID
+		orderItem.CreatedAt = now
+		orderItem.UpdatedAt = now
+		orderItem.ID = primitive.NewObjectID()
+		orderItem.OrderItemID = orderItem.ID.Hex()
+		status := "ACTIVE"
+		orderItem.Status = &status
+		orderItem.Adjustments = nil
+		setCourseDefaults(&orderItem, now)
+		if !*orderItem.Hold {
+			firedIDs = append(firedIDs, orderItem.OrderItemID)
+			if *orderItem.Course > firedCourse {
+				firedCourse = *orderItem.Course
+			}
+		}
+		insertedIDs = append(insertedIDs, orderItem.OrderItemID)
+		orderItemsToBeInserted = append(orderItemsToBeInserted, orderItem)
 	}
+	if _, err := orderItemCollection.InsertMany(ctx, orderItemsToBeInserted); err != nil {
+		return nil, err
+	}
+	autoPrintKitchenTicket(orderID, firedIDs)
+	if delay := autoFireDelay(); delay > 0 && firedCourse > 0 {
+		// the items are stored by now, so failing here would only make a
+		// retry order them twice; the next course can still be fired by hand
+		if err := scheduleNextCourse(ctx, orderID, firedCourse, now.Add(delay)); err != nil {
+			log.Printf("courses: next course of order %s not scheduled: %v", orderID, err)
+		}
+	}
+	return insertedIDs, nil
 }
 
 func UpdateOrderItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		var orderItem models.OrderItem
+		var existing models.OrderItem
 		orderItemID := c.Param("order_item_id")
 
+		if err := c.BindJSON(&orderItem); err != nil {
+			defer cancel()
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		filter := bson.M{"order_item_id": orderItemID}
+		err := orderItemCollection.FindOne(ctx, filter).Decode(&existing)
+		defer cancel()
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "OrderItem not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order item details"})
+			return
+		}
+		if existing.Status != nil && *existing.Status != "ACTIVE" {
+			c.JSON(http.StatusConflict, gin.H{"error": "a " + strings.ToLower(*existing.Status) + " order item cannot be changed"})
+			return
+		}
+		// once billed, items only change through voids, comps and refunds
+		invoiced, err := orderIsInvoiced(ctx, existing.OrderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		if invoiced {
+			c.JSON(http.StatusConflict, gin.H{"error": "order " + existing.OrderID + " is already invoiced, its items can't be changed"})
+			return
+		}
+
 		var updateObj primitive.D
-		if orderItem.UnitPrice != nil {
-			updateObj = append(updateObj, bson.E{Key: "unit_price", Value: orderItem.UnitPrice})
+		if orderItem.UnitPrice != nil && (existing.UnitPrice == nil || *orderItem.UnitPrice != *existing.UnitPrice) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unit_price cannot be changed, void the item and order it again"})
+			return
+		}
+		// a different food needs its own price, availability and allergen
+		// checks, which only a new order item gets
+		if orderItem.FoodID != nil && (existing.FoodID == nil || *orderItem.FoodID != *existing.FoodID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "food_id cannot be changed, void the item and order it again"})
+			return
+		}
+		if orderItem.Status != nil || orderItem.Adjustments != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "use the void and comp actions to change the status of an order item"})
+			return
 		}
 		if orderItem.Quantity != nil {
+			if err := validate.Var(*orderItem.Quantity, "eq=S|eq=M|eq=L"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quantity"})
+				return
+			}
 			updateObj = append(updateObj, bson.E{Key: "quantity", Value: orderItem.Quantity})
 		}
-		if orderItem.FoodID != nil {
-			updateObj = append(updateObj, bson.E{Key: "food_id", Value: orderItem.FoodID})
+		if orderItem.Seat != nil {
+			if *orderItem.Seat < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "seat must be at least 1"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "seat", Value: orderItem.Seat})
 		}
 
 		orderItem.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: orderItem.UpdatedAt})
 
-		upsert := true
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
-		}
-
 		result, err := orderItemCollection.UpdateOne(ctx, filter, bson.D{
 			{Key: "$set", Value: updateObj},
-		}, &opt)
-		defer cancel()
+		})
 		if err != nil {
 			msg := "Order item update failed"
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OrderItemTransfer struct {
+	OrderItemIDs  []string `json:"order_item_ids" validate:"required,min=1"`
+	TargetOrderID string   `json:"target_order_id"`
+	TargetTableID string   `json:"target_table_id"`
+}
+
+type OrderMerge struct {
+	SourceOrderID string `json:"source_order_id" validate:"required"`
+}
+
+// AddOrderItems appends a new round of items to an open order.
+func AddOrderItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+
+		var body struct {
+			OrderItems []models.OrderItem `json:"order_items"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if msg := validateNewOrderItems(body.OrderItems); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if status, msg := checkFoodsAvailable(ctx, body.OrderItems, time.Now()); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		order, status, msg := openOrderForIncoming(ctx, orderID)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		warnings, status, msg := checkAllergens(ctx, order.Allergies, body.OrderItems)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		insertedIDs, err := insertOrderItems(ctx, orderID, body.OrderItems)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order items were not created"})
+			return
+		}
+
+		err = recordAudit(ctx, models.AuditEntry{
+			Action:       "ITEMS_ADDED",
+			OrderID:      orderID,
+			OrderItemIDs: insertedIDs,
+			PerformedBy:  c.GetString("uid"),
+			Details:      fmt.Sprintf("%d item(s) added", len(insertedIDs)),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "audit entry was not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID, "inserted_ids": insertedIDs, "warnings": allergenWarnings(warnings, insertedIDs)})
+	}
+}
+
+// TransferOrderItems moves items to another order, or to the open order of
+// another table. A new dine-in order is opened when the table has none.
+func TransferOrderItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+
+		var transfer OrderItemTransfer
+		if err := c.BindJSON(&transfer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(transfer); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if (transfer.TargetOrderID == "") == (transfer.TargetTableID == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "give either target_order_id or target_table_id"})
+			return
+		}
+
+		if _, status, msg := openOrderForOutgoing(ctx, orderID); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		count, err := orderItemCollection.CountDocuments(ctx, bson.M{
+			"order_id":      orderID,
+			"order_item_id": bson.M{"$in": transfer.OrderItemIDs},
+			"status":        bson.M{"$ne": "VOIDED"},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order item details"})
+			return
+		}
+		if count != int64(len(transfer.OrderItemIDs)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "every order item must belong to this order and not be voided"})
+			return
+		}
+
+		targetOrderID := transfer.TargetOrderID
+		if transfer.TargetTableID != "" {
+			var status int
+			var msg string
+			targetOrderID, status, msg = openOrderForTable(ctx, transfer.TargetTableID)
+			if msg != "" {
+				c.JSON(status, gin.H{"error": msg})
+				return
+			}
+		}
+		if targetOrderID == orderID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "items are already on this order"})
+			return
+		}
+		if _, status, msg := openOrderForIncoming(ctx, targetOrderID); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		moved, err := moveOrderItems(ctx, bson.M{
+			"order_id":      orderID,
+			"order_item_id": bson.M{"$in": transfer.OrderItemIDs},
+		}, targetOrderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order items were not moved"})
+			return
+		}
+
+		err = recordAudit(ctx, models.AuditEntry{
+			Action:        "ITEMS_TRANSFERRED",
+			OrderID:       orderID,
+			TargetOrderID: targetOrderID,
+			OrderItemIDs:  transfer.OrderItemIDs,
+			PerformedBy:   c.GetString("uid"),
+			Details:       fmt.Sprintf("%d item(s) moved", moved),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "audit entry was not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID, "target_order_id": targetOrderID, "moved": moved})
+	}
+}
+
+// MergeOrders moves every item and order note of the source order into this
+// order and marks the source as merged.
+func MergeOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+
+		var merge OrderMerge
+		if err := c.BindJSON(&merge); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(merge); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if merge.SourceOrderID == orderID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an order cannot be merged into itself"})
+			return
+		}
+
+		if _, status, msg := openOrderForOutgoing(ctx, merge.SourceOrderID); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		if _, status, msg := openOrderForIncoming(ctx, orderID); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		var itemIDs []string
+		result, err := orderItemCollection.Find(ctx, bson.M{"order_id": merge.SourceOrderID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order item details"})
+			return
+		}
+		var sourceItems []models.OrderItem
+		if err = result.All(ctx, &sourceItems); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching order item details"})
+			return
+		}
+		for _, item := range sourceItems {
+			itemIDs = append(itemIDs, item.OrderItemID)
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		orderResult, err := orderCollection.UpdateOne(
+			ctx,
+			bson.M{"order_id": merge.SourceOrderID, "status": bson.M{"$nin": []string{"CANCELLED", "MERGED"}}},
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "status", Value: "MERGED"},
+				{Key: "merged_into", Value: orderID},
+				{Key: "updated_at", Value: updatedAt},
+			}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "orders were not merged"})
+			return
+		}
+		if orderResult.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "source order was changed by another request"})
+			return
+		}
+
+		moved, err := moveOrderItems(ctx, bson.M{"order_id": merge.SourceOrderID}, orderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order items were not moved"})
+			return
+		}
+		_, err = noteCollection.UpdateMany(
+			ctx,
+			bson.M{"entity_type": "ORDER", "entity_id": merge.SourceOrderID},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "entity_id", Value: orderID}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "order notes were not moved"})
+			return
+		}
+
+		err = recordAudit(ctx, models.AuditEntry{
+			Action:        "ORDERS_MERGED",
+			OrderID:       merge.SourceOrderID,
+			TargetOrderID: orderID,
+			OrderItemIDs:  itemIDs,
+			PerformedBy:   c.GetString("uid"),
+			Details:       fmt.Sprintf("order merged with %d item(s)", moved),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "audit entry was not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": orderID, "source_order_id": merge.SourceOrderID, "moved": moved})
+	}
+}
+
+func moveOrderItems(ctx context.Context, filter bson.M, targetOrderID string) (int64, error) {
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	result, err := orderItemCollection.UpdateMany(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "order_id", Value: targetOrderID},
+			{Key: "updated_at", Value: updatedAt},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func findOpenOrder(ctx context.Context, orderID string) (order models.Order, status int, msg string) {
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return order, http.StatusNotFound, "Order not found"
+		}
+		return order, http.StatusInternalServerError, "Error in fetching order details"
+	}
+	if order.Status != nil && *order.Status != "OPEN" {
+		return order, http.StatusConflict, "order " + orderID + " is " + strings.ToLower(*order.Status)
+	}
+	return order, http.StatusOK, ""
+}
+
+// openOrderForIncoming loads an order that items may be added or moved into.
+// Orders that already have an invoice are closed for new items.
+func openOrderForIncoming(ctx context.Context, orderID string) (models.Order, int, string) {
+	order, status, msg := findOpenOrder(ctx, orderID)
+	if msg != "" {
+		return order, status, msg
+	}
+	invoiced, err := orderIsInvoiced(ctx, orderID)
+	if err != nil {
+		return order, http.StatusInternalServerError, "Error in fetching invoice details"
+	}
+	if invoiced {
+		return order, http.StatusConflict, "order " + orderID + " is already invoiced"
+	}
+	return order, http.StatusOK, ""
+}
+
+// openOrderForOutgoing loads an order that items may be moved out of. Once
+// an order is invoiced its items stay on the bill the customer was given,
+// paid or not.
+func openOrderForOutgoing(ctx context.Context, orderID string) (models.Order, int, string) {
+	order, status, msg := findOpenOrder(ctx, orderID)
+	if msg != "" {
+		return order, status, msg
+	}
+	invoiced, err := orderIsInvoiced(ctx, orderID)
+	if err != nil {
+		return order, http.StatusInternalServerError, "Error in fetching invoice details"
+	}
+	if invoiced {
+		return order, http.StatusConflict, "order " + orderID + " is already invoiced, its items can't be moved"
+	}
+	return order, http.StatusOK, ""
+}
+
+// openOrderForTable returns the latest open, uninvoiced order of a table,
+// opening a new dine-in order when there is none.
+func openOrderForTable(ctx context.Context, tableID string) (string, int, string) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	result, err := orderCollection.Find(ctx, bson.M{
+		"table_id": tableID,
+		"status":   bson.M{"$nin": []string{"CANCELLED", "MERGED"}},
+	}, opts)
+	if err != nil {
+		return "", http.StatusInternalServerError, "Error in fetching order details"
+	}
+	var orders []models.Order
+	if err = result.All(ctx, &orders); err != nil {
+		return "", http.StatusInternalServerError, "Error in fetching order details"
+	}
+	for _, order := range orders {
+		invoiced, err := orderIsInvoiced(ctx, order.OrderID)
+		if err != nil {
+			return "", http.StatusInternalServerError, "Error in fetching invoice details"
+		}
+		if !invoiced {
+			return order.OrderID, http.StatusOK, ""
+		}
+	}
+
+	var order models.Order
+	order.OrderDate, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	order.TableID = &tableID
+	if status, msg := checkOrderType(ctx, &order); msg != "" {
+		return "", status, msg
+	}
+	orderID, err := OrderItemOrderCreator(ctx, order)
+	if err != nil {
+		return "", http.StatusInternalServerError, "order was not created"
+	}
+	return orderID, http.StatusOK, ""
+}
+
+func orderIsInvoiced(ctx context.Context, orderID string) (bool, error) {
+	count, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderID})
+	return count > 0, err
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+	"atm1504.in/rms/notifier"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultDunningSchedule sends reminders 1, 7, 14 and 30 days after the due
+// date.
+var defaultDunningSchedule = []int{1, 7, 14, 30}
+
+// OverdueInvoice is an invoice in the aging report.
+type OverdueInvoice struct {
+	InvoiceID      string      `json:"invoice_id"`
+	InvoiceNumber  string      `json:"invoice_number,omitempty"`
+	OrderID        string      `json:"order_id"`
+	CustomerName   *string     `json:"customer_name,omitempty"`
+	CustomerPhone  *string     `json:"customer_phone,omitempty"`
+	BillingEmail   *string     `json:"billing_email,omitempty"`
+	PaymentDueDate time.Time   `json:"payment_due_date"`
+	DaysOverdue    int         `json:"days_overdue"`
+	AgingBucket    string      `json:"aging_bucket"`
+	Balance        money.Money `json:"balance"`
+	RemindersSent  int         `json:"reminders_sent"`
+	LastRemindedAt *time.Time  `json:"last_reminded_at,omitempty"`
+}
+
+type AgingBucket struct {
+	Bucket  string      `json:"bucket"`
+	Count   int         `json:"count"`
+	Balance money.Money `json:"balance"`
+}
+
+// OverdueReport lists overdue invoices, oldest first, with their balances
+// summed per aging bucket.
+type OverdueReport struct {
+	AsOf         time.Time        `json:"as_of"`
+	Invoices     []OverdueInvoice `json:"invoices"`
+	Aging        []AgingBucket    `json:"aging"`
+	TotalBalance money.Money      `json:"total_balance"`
+}
+
+// agingBuckets group overdue invoices by days overdue. Each bucket but the
+// last runs up to maxDays.
+var agingBuckets = []struct {
+	name    string
+	maxDays int
+}{
+	{"0-30", 30},
+	{"31-60", 60},
+	{"61-90", 90},
+	{"90+", -1},
+}
+
+// MarkOverdueInvoices marks unpaid invoices past their due date OVERDUE and
+// sends the reminders due on the dunning schedule. It is run periodically by
+// the background scheduler.
+func MarkOverdueInvoices(ctx context.Context) error {
+	now := time.Now()
+	updatedAt, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	_, err := invoiceCollection.UpdateMany(ctx,
+		bson.M{
+			"payment_status":   bson.M{"$in": bson.A{"PENDING", "PARTIALLY_PAID"}},
+			"payment_due_date": bson.M{"$lt": now},
+			// invoices with nothing to pay are never overdue
+			"$or": bson.A{
+				bson.M{"bill": bson.M{"$exists": false}},
+				bson.M{"bill.payment_due.cents": bson.M{"$gt": 0}},
+			},
+		},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "payment_status", Value: "OVERDUE"},
+			{Key: "updated_at", Value: updatedAt},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+	return sendReminders(ctx, now)
+}
+
+// sendReminders sends each overdue invoice the reminder for the latest stage
+// of the dunning schedule it has reached. Stages missed while the server was
+// down are not caught up on one by one. A reminder that fails is tried again
+// on the next run.
+func sendReminders(ctx context.Context, now time.Time) error {
+	schedule := dunningSchedule()
+	if len(schedule) == 0 {
+		return nil
+	}
+	sender, err := notifier.Default()
+	if err != nil {
+		return err
+	}
+
+	result, err := invoiceCollection.Find(ctx, bson.M{
+		"payment_status": "OVERDUE",
+		"reminders_sent": bson.M{"$not": bson.M{"$gte": len(schedule)}},
+	})
+	if err != nil {
+		return err
+	}
+	var invoices []models.Invoice
+	if err = result.All(ctx, &invoices); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, invoice := range invoices {
+		stage := dunningStage(schedule, daysOverdue(invoice, now))
+		if stage <= invoice.RemindersSent {
+			continue
+		}
+		overdue, err := overdueInvoice(ctx, invoice, now)
+		if err != nil {
+			return err
+		}
+		if overdue.Balance.Cents <= 0 {
+			continue
+		}
+
+		reminder := notifier.Reminder{
+			InvoiceID:     invoice.InvoiceID,
+			InvoiceNumber: invoice.InvoiceNumber,
+			OrderID:       invoice.OrderID,
+			Balance:       overdue.Balance,
+			DueDate:       invoice.PaymentDueDate,
+			DaysOverdue:   overdue.DaysOverdue,
+			Stage:         stage,
+		}
+		if overdue.CustomerName != nil {
+			reminder.CustomerName = *overdue.CustomerName
+		}
+		if overdue.CustomerPhone != nil {
+			reminder.CustomerPhone = *overdue.CustomerPhone
+		}
+		if invoice.BillingEmail != nil {
+			reminder.BillingEmail = *invoice.BillingEmail
+		}
+		if err := sender.Notify(ctx, reminder); err != nil {
+			log.Printf("overdue: reminder for invoice %s failed: %v", invoice.InvoiceID, err)
+			failed++
+			continue
+		}
+
+		remindedAt, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+		_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoice.InvoiceID}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "reminders_sent", Value: stage},
+				{Key: "last_reminded_at", Value: remindedAt},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reminders failed", failed, len(invoices))
+	}
+	return nil
+}
+
+// getOverdueReport answers GET /invoices?status=overdue.
+func getOverdueReport(c *gin.Context, ctx context.Context) {
+	now := time.Now()
+	opts := options.Find().SetSort(bson.D{{Key: "payment_due_date", Value: 1}})
+	result, err := invoiceCollection.Find(ctx, bson.M{"payment_status": "OVERDUE"}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing overdue invoices"})
+		return
+	}
+	var invoices []models.Invoice
+	if err = result.All(ctx, &invoices); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing overdue invoices"})
+		return
+	}
+
+	report := OverdueReport{AsOf: now, Invoices: []OverdueInvoice{}, Aging: make([]AgingBucket, len(agingBuckets))}
+	for i, bucket := range agingBuckets {
+		report.Aging[i] = AgingBucket{Bucket: bucket.name, Balance: money.Of(0)}
+	}
+	var totalCents int64
+	for _, invoice := range invoices {
+		overdue, err := overdueInvoice(ctx, invoice, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while pricing overdue invoices"})
+			return
+		}
+		report.Invoices = append(report.Invoices, overdue)
+		bucket := &report.Aging[agingBucket(overdue.DaysOverdue)]
+		bucket.Count++
+		bucket.Balance = bucket.Balance.Add(overdue.Balance)
+		totalCents += overdue.Balance.Cents
+	}
+	report.TotalBalance = money.Of(totalCents)
+	c.JSON(http.StatusOK, report)
+}
+
+// overdueInvoice prices an overdue invoice and looks up who to chase for it.
+func overdueInvoice(ctx context.Context, invoice models.Invoice, now time.Time) (OverdueInvoice, error) {
+	overdue := OverdueInvoice{
+		InvoiceID:      invoice.InvoiceID,
+		InvoiceNumber:  invoice.InvoiceNumber,
+		OrderID:        invoice.OrderID,
+		BillingEmail:   invoice.BillingEmail,
+		PaymentDueDate: invoice.PaymentDueDate,
+		DaysOverdue:    daysOverdue(invoice, now),
+		RemindersSent:  invoice.RemindersSent,
+		LastRemindedAt: invoice.LastRemindedAt,
+	}
+	overdue.AgingBucket = agingBuckets[agingBucket(overdue.DaysOverdue)].name
+
+	view, err := buildInvoiceView(ctx, invoice)
+	if err != nil {
+		return overdue, err
+	}
+	overdue.Balance = view.Balance
+
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": invoice.OrderID}).Decode(&order); err == nil {
+		overdue.CustomerName = order.CustomerName
+		overdue.CustomerPhone = order.CustomerPhone
+	}
+	return overdue, nil
+}
+
+// invoiceIsPastDue reports whether an invoice's due date has passed.
+func invoiceIsPastDue(invoice models.Invoice, now time.Time) bool {
+	return !invoice.PaymentDueDate.IsZero() && invoice.PaymentDueDate.Before(now)
+}
+
+// daysOverdue counts the whole days since an invoice fell due.
+func daysOverdue(invoice models.Invoice, now time.Time) int {
+	if !invoiceIsPastDue(invoice, now) {
+		return 0
+	}
+	return int(now.Sub(invoice.PaymentDueDate).Hours() / 24)
+}
+
+// agingBucket is the index of the aging bucket for so many days overdue.
+func agingBucket(days int) int {
+	for i, bucket := range agingBuckets {
+		if bucket.maxDays >= 0 && days <= bucket.maxDays {
+			return i
+		}
+	}
+	return len(agingBuckets) - 1
+}
+
+// dunningStage is how many reminders are due for an invoice so many days
+// overdue.
+func dunningStage(schedule []int, days int) int {
+	stage := 0
+	for _, day := range schedule {
+		if days >= day {
+			stage++
+		}
+	}
+	return stage
+}
+
+// dunningSchedule reads DUNNING_SCHEDULE, the days after the due date on
+// which reminders go out such as "1,7,14,30". "none" turns reminders off.
+func dunningSchedule() []int {
+	value := strings.TrimSpace(os.Getenv("DUNNING_SCHEDULE"))
+	if value == "" {
+		return defaultDunningSchedule
+	}
+	if strings.EqualFold(value, "none") {
+		return nil
+	}
+	var schedule []int
+	for _, part := range strings.Split(value, ",") {
+		day, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || day < 0 {
+			log.Printf("overdue: ignoring DUNNING_SCHEDULE %q: %q is not a number of days", value, part)
+			return defaultDunningSchedule
+		}
+		schedule = append(schedule, day)
+	}
+	sort.Ints(schedule)
+	return schedule
+}
@@ -0,0 +1,797 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/gateway"
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var paymentCollection *mongo.Collection = database.OpenCollection(database.Client, "payment")
+
+// paymentLockTTL is how long a payment may hold its invoice. It outlasts the
+// request, so a lock is only left to expire when a server dies mid-payment.
+const paymentLockTTL = 2 * time.Minute
+
+var errInvoiceBusy = errors.New("another payment on this invoice is in progress, try again")
+
+func GetInvoicePayments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		payments, err := invoicePayments(ctx, c.Param("invoice_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing payments"})
+			return
+		}
+		c.JSON(http.StatusOK, payments)
+	}
+}
+
+func GetPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		paymentID := c.Param("payment_id")
+		var payment models.Payment
+
+		err := paymentCollection.FindOne(ctx, bson.M{"payment_id": paymentID}).Decode(&payment)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Payment not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching payment details"})
+			return
+		}
+		c.JSON(http.StatusOK, payment)
+	}
+}
+
+// CreatePayment records one tender against an invoice. Without an amount the
+// payment settles the remaining balance. Cash may be tendered above the
+// amount and the difference is given back as change; cards are charged the
+// exact amount through the payment provider. The invoice status follows from
+// the sum of its payments.
+func CreatePayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var payment models.Payment
+
+		if err := c.BindJSON(&payment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(payment); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if key := c.GetHeader("Idempotency-Key"); key != "" {
+			payment.IdempotencyKey = key
+		}
+		payment.ReceivedBy = c.GetString("uid")
+
+		result, status, msg := takePayment(ctx, c.Param("invoice_id"), payment)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(status, result)
+	}
+}
+
+// paymentResult is the answer to a payment attempt.
+type paymentResult struct {
+	Payment       models.Payment `json:"payment"`
+	PaymentStatus string         `json:"payment_status"`
+	Balance       money.Money    `json:"balance"`
+}
+
+// takePayment records a payment against an invoice, charging cards through
+// the payment provider. A payment retried with the same idempotency key
+// returns the first attempt instead of charging again. It returns the HTTP
+// status to answer with and, on failure, the error message.
+func takePayment(ctx context.Context, invoiceID string, payment models.Payment) (paymentResult, int, string) {
+	var result paymentResult
+	var invoice models.Invoice
+	err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return result, http.StatusNotFound, "Invoice not found"
+		}
+		return result, http.StatusInternalServerError, "Error in fetching invoice details"
+	}
+
+	if replay, status, msg := replayPayment(ctx, invoice, payment.IdempotencyKey); status != 0 {
+		return replay, status, msg
+	}
+
+	if invoiceIsSettled(invoice.PaymentStatus) {
+		return result, http.StatusConflict, "invoice is already " + strings.ToLower(*invoice.PaymentStatus)
+	}
+
+	// the balance is read, checked and paid holding the invoice, so
+	// payments made at the same time can't both take the same balance
+	invoice, unlock, err := lockInvoicePayments(ctx, invoiceID)
+	if err != nil {
+		if err == errInvoiceBusy {
+			return result, http.StatusConflict, err.Error()
+		}
+		return result, http.StatusInternalServerError, "Error in fetching invoice details"
+	}
+	defer unlock()
+	// a retry may have been waiting on the payment it repeats
+	if replay, status, msg := replayPayment(ctx, invoice, payment.IdempotencyKey); status != 0 {
+		return replay, status, msg
+	}
+	if invoiceIsSettled(invoice.PaymentStatus) {
+		return result, http.StatusConflict, "invoice is already " + strings.ToLower(*invoice.PaymentStatus)
+	}
+	// invoices from before bills were kept are billed as they are paid
+	if invoice.Bill == nil {
+		if err := billInvoice(ctx, &invoice); err != nil {
+			return result, http.StatusInternalServerError, "error occured while pricing the invoice"
+		}
+	}
+
+	base := money.DefaultCurrency()
+	if (payment.Amount != nil && !payment.Amount.In(base)) || (payment.Tip != nil && !payment.Tip.In(base)) {
+		return result, http.StatusBadRequest, "amount and tip are in the base currency " + base
+	}
+	if payment.Tendered != nil && !payment.Tendered.In(base) {
+		// foreign cash is converted at the table rate and change is given
+		// in the base currency
+		if *payment.Method != "CASH" {
+			return result, http.StatusBadRequest, "only cash can be tendered in another currency"
+		}
+		rate, exact, err := exchangeRateOf(ctx, payment.Tendered.Currency)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return result, http.StatusBadRequest, "there is no exchange rate for " + payment.Tendered.Currency
+			}
+			return result, http.StatusInternalServerError, "Error in fetching exchange rate details"
+		}
+		foreign, converted := *payment.Tendered, payment.Tendered.Convert(base, exact)
+		payment.ForeignTendered = &foreign
+		payment.ExchangeRate = &rate
+		payment.Tendered = &converted
+	}
+
+	invoiceView, err := buildInvoiceView(ctx, invoice)
+	if err != nil {
+		return result, http.StatusInternalServerError, "error occured while pricing the invoice"
+	}
+	dueCents := invoiceView.PaymentDue.Cents
+	balanceCents := dueCents - invoiceView.AmountPaid.Cents - reservedCents(invoiceView.Payments)
+	if balanceCents <= 0 {
+		return result, http.StatusConflict, "nothing is left to pay on this invoice"
+	}
+
+	var tip int64
+	if payment.Tip != nil {
+		tip = payment.Tip.Cents
+	}
+	amountCents := balanceCents
+	if payment.Amount != nil {
+		amountCents = payment.Amount.Cents
+	} else if *payment.Method == "CASH" && payment.Tendered != nil && payment.Tendered.Cents-tip < balanceCents {
+		amountCents = payment.Tendered.Cents - tip
+	}
+	if amountCents > balanceCents {
+		return result, http.StatusBadRequest, "amount is more than the balance of " + money.Of(balanceCents).String()
+	}
+	if amountCents <= 0 {
+		return result, http.StatusBadRequest, "amount must be positive"
+	}
+
+	tenderedCents := amountCents + tip
+	if payment.Tendered != nil {
+		tenderedCents = payment.Tendered.Cents
+	}
+	if *payment.Method == "CARD" && tenderedCents != amountCents+tip {
+		return result, http.StatusBadRequest, "card payments are taken for the exact amount and tip"
+	}
+	if tenderedCents < amountCents+tip {
+		return result, http.StatusBadRequest, "tendered amount is less than the payment amount and tip"
+	}
+
+	amount, tendered, tipAmount := money.Of(amountCents), money.Of(tenderedCents), money.Of(tip)
+	payment.Amount = &amount
+	payment.Tendered = &tendered
+	payment.Tip = &tipAmount
+	payment.Change = money.Of(tenderedCents - amountCents - tip)
+	payment.InvoiceID = invoiceID
+	payment.OrderID = invoice.OrderID
+	// tips go to the server who owned the order, or whoever took the payment
+	payment.ServerID = payment.ReceivedBy
+	if serverID, err := orderServer(ctx, invoice.OrderID); err == nil && serverID != "" {
+		payment.ServerID = serverID
+	}
+	payment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	payment.UpdatedAt = payment.CreatedAt
+	payment.ID = primitive.NewObjectID()
+	payment.PaymentID = payment.ID.Hex()
+	if payment.Capture == nil {
+		capture := true
+		payment.Capture = &capture
+	}
+
+	status := http.StatusCreated
+	payment.Status = gateway.StatusCaptured
+	if *payment.Method == "CARD" {
+		if payment.CardToken == "" {
+			return result, http.StatusBadRequest, "card_token is required for card payments"
+		}
+		// card payments are recorded before the card is charged, so a
+		// charge is never left without its payment
+		payment.Status = gateway.StatusPending
+	}
+
+	if _, err := paymentCollection.InsertOne(ctx, payment); err != nil {
+		// the unique index caught a retry recorded in the meantime
+		if mongo.IsDuplicateKeyError(err) && payment.IdempotencyKey != "" {
+			if replay, status, msg := replayPayment(ctx, invoice, payment.IdempotencyKey); status != 0 {
+				return replay, status, msg
+			}
+		}
+		return result, http.StatusInternalServerError, "Payment was not recorded"
+	}
+
+	if *payment.Method == "CARD" {
+		status, err = chargeCard(ctx, &payment, amountCents+tip)
+		if err != nil && payment.TransactionID == "" {
+			// the provider gave no transaction, so there is nothing to
+			// record; a retry with the same Idempotency-Key reaches it with
+			// the same key
+			if _, deleteErr := paymentCollection.DeleteOne(ctx, bson.M{"payment_id": payment.PaymentID}); deleteErr != nil {
+				log.Printf("payments: pending payment %s left after provider error: %v", payment.PaymentID, deleteErr)
+			}
+			return result, http.StatusBadGateway, "payment provider error: " + err.Error()
+		}
+		if recordErr := recordCharge(ctx, payment); recordErr != nil {
+			log.Printf("payments: payment %s is %s at %s as %s but was not updated: %v", payment.PaymentID, payment.Status, payment.Provider, payment.TransactionID, recordErr)
+			return result, http.StatusInternalServerError, "Payment was not recorded"
+		}
+		if err != nil {
+			// authorized but not captured: the payment stays authorized to
+			// be captured or voided
+			return result, http.StatusBadGateway, "payment provider error: " + err.Error()
+		}
+	}
+
+	result.Payment = payment
+	result.PaymentStatus, result.Balance, err = refreshPaymentStatus(ctx, invoiceID, dueCents)
+	if err != nil {
+		return result, http.StatusInternalServerError, "invoice status update failed"
+	}
+	return result, status, ""
+}
+
+// recordCharge stores what the provider did with a card payment recorded
+// before it was charged.
+func recordCharge(ctx context.Context, payment models.Payment) error {
+	_, err := paymentCollection.UpdateOne(ctx, bson.M{"payment_id": payment.PaymentID}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "provider", Value: payment.Provider},
+			{Key: "transaction_id", Value: payment.TransactionID},
+			{Key: "status", Value: payment.Status},
+			{Key: "decline_reason", Value: payment.DeclineReason},
+		}},
+	})
+	return err
+}
+
+// replayPayment looks for a payment already recorded on the invoice with the
+// idempotency key and answers with it. A zero status means there is none and
+// the payment should be taken.
+func replayPayment(ctx context.Context, invoice models.Invoice, key string) (paymentResult, int, string) {
+	var result paymentResult
+	if key == "" {
+		return result, 0, ""
+	}
+	var previous models.Payment
+	err := paymentCollection.FindOne(ctx, bson.M{"invoice_id": invoice.InvoiceID, "idempotency_key": key}).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return result, 0, ""
+	}
+	if err != nil {
+		return result, http.StatusInternalServerError, "Error in fetching payment details"
+	}
+	var current models.Invoice
+	if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoice.InvoiceID}).Decode(&current); err != nil {
+		return result, http.StatusInternalServerError, "Error in fetching invoice details"
+	}
+	result.Payment = previous
+	result.PaymentStatus = *current.PaymentStatus
+	return result, http.StatusOK, ""
+}
+
+// EnsurePaymentIndexes creates the unique index that lets an idempotency key
+// record only one payment per invoice, however many retries race.
+func EnsurePaymentIndexes(ctx context.Context) error {
+	_, err := paymentCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "invoice_id", Value: 1}, {Key: "idempotency_key", Value: 1}},
+		Options: options.Index().
+			SetName("payment_idempotency_key").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$type": "string"}}),
+	})
+	return err
+}
+
+// lockInvoicePayments holds an invoice for one payment at a time and returns
+// it as it is once held, with the function that lets it go. An invoice held
+// by another payment gives errInvoiceBusy.
+func lockInvoicePayments(ctx context.Context, invoiceID string) (models.Invoice, func(), error) {
+	var invoice models.Invoice
+	lockID := primitive.NewObjectID().Hex()
+	now := time.Now()
+	err := invoiceCollection.FindOneAndUpdate(ctx,
+		bson.M{"invoice_id": invoiceID, "$or": bson.A{
+			bson.M{"payment_lock_until": nil},
+			bson.M{"payment_lock_until": bson.M{"$lt": now}},
+		}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "payment_lock", Value: lockID},
+			{Key: "payment_lock_until", Value: now.Add(paymentLockTTL)},
+		}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		err = errInvoiceBusy
+	}
+	if err != nil {
+		return invoice, nil, err
+	}
+	unlock := func() {
+		// the request's context may be done by now
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := invoiceCollection.UpdateOne(ctx,
+			bson.M{"invoice_id": invoiceID, "payment_lock": lockID},
+			bson.D{{Key: "$unset", Value: bson.D{{Key: "payment_lock", Value: ""}, {Key: "payment_lock_until", Value: ""}}}},
+		)
+		if err != nil {
+			log.Printf("payments: invoice %s left locked: %v", invoiceID, err)
+		}
+	}
+	return invoice, unlock, nil
+}
+
+// chargeCard authorizes a card payment with the provider and captures it
+// straight away unless the payment asks to only authorize. Declines are
+// recorded on the payment and answered with 402, payments waiting on the
+// provider's webhook with 202.
+func chargeCard(ctx context.Context, payment *models.Payment, amountCents int64) (int, error) {
+	provider, err := gateway.Default()
+	if err != nil {
+		return 0, err
+	}
+	key := providerKey(*payment)
+	payment.Provider = provider.Name()
+
+	transaction, err := provider.Authorize(ctx, gateway.AuthorizeRequest{
+		AmountCents:    amountCents,
+		CardToken:      payment.CardToken,
+		Reference:      payment.InvoiceID,
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		return 0, err
+	}
+	payment.TransactionID = transaction.ID
+	payment.Status = transaction.Status
+	payment.DeclineReason = transaction.DeclineReason
+
+	switch transaction.Status {
+	case gateway.StatusDeclined:
+		return http.StatusPaymentRequired, nil
+	case gateway.StatusPending:
+		return http.StatusAccepted, nil
+	case gateway.StatusAuthorized:
+		if !*payment.Capture {
+			return http.StatusCreated, nil
+		}
+		transaction, err = provider.Capture(ctx, transaction.ID, amountCents, key)
+		if err != nil {
+			return 0, err
+		}
+		payment.Status = transaction.Status
+	}
+	return http.StatusCreated, nil
+}
+
+// CapturePayment captures a card payment that was only authorized.
+func CapturePayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		payment, provider, status, msg := providerPayment(ctx, c.Param("payment_id"), gateway.StatusAuthorized)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		transaction, err := provider.Capture(ctx, payment.TransactionID, chargeCents(payment), providerKey(payment))
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider error: " + err.Error()})
+			return
+		}
+		result, err := settleProviderPayment(ctx, payment, transaction.Status, transaction.DeclineReason)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "payment update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// VoidPayment releases a card authorization that has not been captured.
+func VoidPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		payment, provider, status, msg := providerPayment(ctx, c.Param("payment_id"), gateway.StatusAuthorized, gateway.StatusPending)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		transaction, err := provider.Void(ctx, payment.TransactionID, providerKey(payment))
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider error: " + err.Error()})
+			return
+		}
+		result, err := settleProviderPayment(ctx, payment, transaction.Status, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "payment update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// PaymentWebhook takes the asynchronous outcome of a card payment from a
+// provider. Events are matched to payments by transaction and only move a
+// payment forward, so repeated deliveries are harmless.
+func PaymentWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		provider, err := gateway.Get(c.Param("provider"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		payload, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		event, err := provider.VerifyWebhook(payload, c.Request.Header)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		var payment models.Payment
+		err = paymentCollection.FindOne(ctx, bson.M{"provider": provider.Name(), "transaction_id": event.TransactionID}).Decode(&payment)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Payment not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching payment details"})
+			return
+		}
+		if payment.Status != gateway.StatusPending {
+			c.JSON(http.StatusOK, gin.H{"event_id": event.ID, "payment_id": payment.PaymentID, "status": payment.Status})
+			return
+		}
+
+		status := event.Status
+		if status == gateway.StatusAuthorized && payment.Capture != nil && *payment.Capture {
+			transaction, err := provider.Capture(ctx, payment.TransactionID, chargeCents(payment), providerKey(payment))
+			if err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider error: " + err.Error()})
+				return
+			}
+			status = transaction.Status
+		}
+		if _, err := settleProviderPayment(ctx, payment, status, event.DeclineReason); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "payment update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"event_id": event.ID, "payment_id": payment.PaymentID, "status": status})
+	}
+}
+
+// providerKey is the idempotency key a payment's provider calls are made
+// with: the client's key when it sent one, the payment id otherwise.
+func providerKey(payment models.Payment) string {
+	if payment.IdempotencyKey != "" {
+		return payment.IdempotencyKey
+	}
+	return payment.PaymentID
+}
+
+// providerPayment loads a card payment and its provider, checking that the
+// payment is in one of the given states.
+func providerPayment(ctx context.Context, paymentID string, states ...string) (models.Payment, gateway.Provider, int, string) {
+	var payment models.Payment
+	err := paymentCollection.FindOne(ctx, bson.M{"payment_id": paymentID}).Decode(&payment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return payment, nil, http.StatusNotFound, "Payment not found"
+		}
+		return payment, nil, http.StatusInternalServerError, "Error in fetching payment details"
+	}
+	if payment.TransactionID == "" {
+		return payment, nil, http.StatusConflict, "payment was not taken through a payment provider"
+	}
+	provider, err := gateway.Get(payment.Provider)
+	if err != nil {
+		return payment, nil, http.StatusInternalServerError, err.Error()
+	}
+	for _, state := range states {
+		if payment.Status == state {
+			return payment, provider, http.StatusOK, ""
+		}
+	}
+	return payment, provider, http.StatusConflict, "payment is " + strings.ToLower(payment.Status)
+}
+
+// settleProviderPayment stores the new state of a card payment and refreshes
+// the status of its invoice.
+func settleProviderPayment(ctx context.Context, payment models.Payment, status string, declineReason string) (paymentResult, error) {
+	var result paymentResult
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err := paymentCollection.UpdateOne(ctx, bson.M{"payment_id": payment.PaymentID}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: status},
+			{Key: "decline_reason", Value: declineReason},
+			{Key: "updated_at", Value: updatedAt},
+		}},
+	})
+	if err != nil {
+		return result, err
+	}
+	payment.Status, payment.DeclineReason, payment.UpdatedAt = status, declineReason, updatedAt
+	result.Payment = payment
+
+	var invoice models.Invoice
+	if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": payment.InvoiceID}).Decode(&invoice); err != nil {
+		return result, err
+	}
+	invoiceView, err := buildInvoiceView(ctx, invoice)
+	if err != nil {
+		return result, err
+	}
+	result.PaymentStatus, result.Balance, err = refreshPaymentStatus(ctx, payment.InvoiceID, invoiceView.PaymentDue.Cents)
+	return result, err
+}
+
+type TipPayout struct {
+	ServerID   string      `json:"server_id"`
+	ServerName string      `json:"server_name"`
+	Tips       money.Money `json:"tips"`
+	Payments   int         `json:"payments"`
+}
+
+// GetTipReport totals the tips received per server, optionally between the
+// RFC3339 times in from and to, for tip payouts.
+func GetTipReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if !requireManager(c, ctx, "only a manager can see tip reports") {
+			return
+		}
+		filter := bson.M{"tip.cents": bson.M{"$gt": 0}, "status": bson.M{"$in": bson.A{nil, "", gateway.StatusCaptured}}}
+		createdAt := bson.M{}
+		for param, operator := range map[string]string{"from": "$gte", "to": "$lt"} {
+			if value := c.Query(param); value != "" {
+				at, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC3339 time"})
+					return
+				}
+				createdAt[operator] = at
+			}
+		}
+		if len(createdAt) > 0 {
+			filter["created_at"] = createdAt
+		}
+
+		result, err := paymentCollection.Find(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing payments"})
+			return
+		}
+		payments := []models.Payment{}
+		if err = result.All(ctx, &payments); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing payments"})
+			return
+		}
+
+		cents := map[string]int64{}
+		counts := map[string]int{}
+		var servers []string
+		for _, payment := range payments {
+			if _, ok := cents[payment.ServerID]; !ok {
+				servers = append(servers, payment.ServerID)
+			}
+			cents[payment.ServerID] += payment.Tip.Cents
+			counts[payment.ServerID]++
+		}
+		sort.Strings(servers)
+
+		payouts := []TipPayout{}
+		var total int64
+		for _, serverID := range servers {
+			payout := TipPayout{ServerID: serverID, Tips: money.Of(cents[serverID]), Payments: counts[serverID]}
+			if user, err := helper.UserByID(ctx, serverID); err == nil && user.FirstName != nil {
+				payout.ServerName = *user.FirstName
+				if user.LastName != nil {
+					payout.ServerName += " " + *user.LastName
+				}
+			}
+			payouts = append(payouts, payout)
+			total += cents[serverID]
+		}
+		c.JSON(http.StatusOK, gin.H{"servers": payouts, "total_tips": money.Of(total)})
+	}
+}
+
+func invoicePayments(ctx context.Context, invoiceID string) ([]models.Payment, error) {
+	payments := []models.Payment{}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	result, err := paymentCollection.Find(ctx, bson.M{"invoice_id": invoiceID}, opts)
+	if err != nil {
+		return payments, err
+	}
+	err = result.All(ctx, &payments)
+	return payments, err
+}
+
+// paymentSettled reports whether the money of a payment has been received.
+// Payments recorded before card payments went through a provider have no
+// status and count as received.
+func paymentSettled(payment models.Payment) bool {
+	return payment.Status == "" || payment.Status == gateway.StatusCaptured
+}
+
+func paidCents(payments []models.Payment) int64 {
+	var cents int64
+	for _, payment := range payments {
+		if payment.Amount != nil && paymentSettled(payment) {
+			cents += payment.Amount.Cents
+		}
+	}
+	return cents
+}
+
+// tipCents is the tips received with the settled payments.
+func tipCents(payments []models.Payment) int64 {
+	var cents int64
+	for _, payment := range payments {
+		if payment.Tip != nil && paymentSettled(payment) {
+			cents += payment.Tip.Cents
+		}
+	}
+	return cents
+}
+
+// chargeCents is what a card payment puts on the card: the amount and the tip.
+func chargeCents(payment models.Payment) int64 {
+	cents := payment.Amount.Cents
+	if payment.Tip != nil {
+		cents += payment.Tip.Cents
+	}
+	return cents
+}
+
+func orderServer(ctx context.Context, orderID string) (string, error) {
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order); err != nil {
+		return "", err
+	}
+	if order.ServerID == nil {
+		return "", nil
+	}
+	return *order.ServerID, nil
+}
+
+// reservedCents is what is held by card payments still waiting to be
+// captured.
+func reservedCents(payments []models.Payment) int64 {
+	var cents int64
+	for _, payment := range payments {
+		if payment.Amount != nil && (payment.Status == gateway.StatusPending || payment.Status == gateway.StatusAuthorized) {
+			cents += payment.Amount.Cents
+		}
+	}
+	return cents
+}
+
+// paymentStatus derives the status of an invoice from what has been paid
+// against what is due. An invoice with nothing due, such as a fully comped
+// order, is paid.
+func paymentStatus(paid, due int64) string {
+	switch {
+	case due <= 0, paid >= due && paid > 0:
+		return "PAID"
+	case paid > 0:
+		return "PARTIALLY_PAID"
+	}
+	return "PENDING"
+}
+
+// paymentMethodOf describes how an invoice was paid: the one method used, or
+// MIXED when several were.
+func paymentMethodOf(payments []models.Payment) string {
+	method := ""
+	for _, payment := range payments {
+		if !paymentSettled(payment) {
+			continue
+		}
+		if method != "" && method != *payment.Method {
+			return "MIXED"
+		}
+		method = *payment.Method
+	}
+	return method
+}
+
+// refreshPaymentStatus stores the status that follows from an invoice's
+// payments and returns it with the remaining balance. An invoice not paid in
+// full by its due date stays OVERDUE. The receipt is printed when this makes
+// the invoice paid.
+func refreshPaymentStatus(ctx context.Context, invoiceID string, dueCents int64) (string, money.Money, error) {
+	var invoice models.Invoice
+	if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice); err != nil {
+		return "", money.Money{}, err
+	}
+	payments, err := invoicePayments(ctx, invoiceID)
+	if err != nil {
+		return "", money.Money{}, err
+	}
+	paid := paidCents(payments)
+	status := paymentStatus(paid, dueCents)
+	if status != "PAID" && invoiceIsPastDue(invoice, time.Now()) {
+		status = "OVERDUE"
+	}
+
+	updateObj := bson.D{{Key: "payment_status", Value: status}}
+	if method := paymentMethodOf(payments); method == "CASH" || method == "CARD" {
+		updateObj = append(updateObj, bson.E{Key: "payment_method", Value: method})
+	}
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: updatedAt})
+
+	var previous models.Invoice
+	err = invoiceCollection.FindOneAndUpdate(ctx, bson.M{"invoice_id": invoiceID}, bson.D{{Key: "$set", Value: updateObj}}).Decode(&previous)
+	if err == nil && status == "PAID" && (previous.PaymentStatus == nil || *previous.PaymentStatus != "PAID") {
+		autoPrintReceipt(invoiceID)
+	}
+	return status, money.Of(dueCents - paid), err
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/printer"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func GetPrinters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"printers": printer.Names(),
+			"routes": gin.H{
+				printer.KitchenTickets: printer.Route(printer.KitchenTickets),
+				printer.Receipts:       printer.Route(printer.Receipts),
+			},
+		})
+	}
+}
+
+// PrintKitchenTicket sends an order's kitchen ticket to the kitchen printer,
+// or to the printer named by ?printer=.
+func PrintKitchenTicket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+		name := c.DefaultQuery("printer", printer.Route(printer.KitchenTickets))
+
+		target, err := printer.Get(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "printer " + name + " is not configured"})
+			return
+		}
+		ticket, err := BuildKitchenTicket(ctx, orderID)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building kitchen ticket"})
+			return
+		}
+		if err := target.Print(ctx, kitchenTicketJob(ticket, nil)); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "printing failed: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"printer": name})
+	}
+}
+
+// PrintInvoiceReceipt sends an invoice's receipt to the receipt printer, or
+// to the printer named by ?printer=.
+func PrintInvoiceReceipt() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		invoiceID := c.Param("invoice_id")
+		name := c.DefaultQuery("printer", printer.Route(printer.Receipts))
+
+		target, err := printer.Get(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "printer " + name + " is not configured"})
+			return
+		}
+		var invoice models.Invoice
+		err = invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		job, err := receiptJob(ctx, invoice)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while preparing the receipt"})
+			return
+		}
+		if err := target.Print(ctx, job); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "printing failed: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"printer": name})
+	}
+}
+
+// autoPrintKitchenTicket prints a ticket for items just sent to the kitchen.
+// It runs in the background and does nothing when no kitchen printer is
+// configured; failures are logged, they don't undo the order.
+func autoPrintKitchenTicket(orderID string, orderItemIDs []string) {
+	target, err := printer.Get(printer.Route(printer.KitchenTickets))
+	if err != nil || len(orderItemIDs) == 0 {
+		return
+	}
+	go func() {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		ticket, err := BuildKitchenTicket(ctx, orderID)
+		if err == nil {
+			err = target.Print(ctx, kitchenTicketJob(ticket, orderItemIDs))
+		}
+		if err != nil {
+			log.Printf("printer: kitchen ticket for order %s failed: %v", orderID, err)
+		}
+	}()
+}
+
+// autoPrintReceipt prints the receipt of an invoice that has just been paid,
+// in the background, when a receipt printer is configured.
+func autoPrintReceipt(invoiceID string) {
+	target, err := printer.Get(printer.Route(printer.Receipts))
+	if err != nil {
+		return
+	}
+	go func() {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+		var job []byte
+		if err == nil {
+			job, err = receiptJob(ctx, invoice)
+		}
+		if err == nil {
+			err = target.Print(ctx, job)
+		}
+		if err != nil {
+			log.Printf("printer: receipt for invoice %s failed: %v", invoiceID, err)
+		}
+	}()
+}
+
+// kitchenTicketJob lays out a kitchen ticket for the printer. With
+// orderItemIDs only those items are printed, as when new items are sent to
+// the kitchen.
+func kitchenTicketJob(ticket KitchenTicket, orderItemIDs []string) []byte {
+	var document printer.KitchenTicket
+	document.Heading = strings.ReplaceAll(ticket.OrderType, "_", " ")
+	if ticket.TableNumber != nil {
+		document.Heading = fmt.Sprintf("TABLE %d", *ticket.TableNumber)
+	} else if ticket.CustomerName != nil {
+		document.Details = append(document.Details, *ticket.CustomerName)
+	}
+	if ticket.PickupTime != nil {
+		document.Details = append(document.Details, "Pickup "+ticket.PickupTime.Local().Format("15:04"))
+	}
+	document.Details = append(document.Details, "Order "+ticket.OrderID)
+	document.PrintedAt = ticket.PrintedAt
+
+	if len(ticket.Allergies) > 0 {
+		document.AllergyAlerts = append(document.AllergyAlerts, strings.ReplaceAll(strings.Join(ticket.Allergies, ", "), "_", " "))
+	}
+	for _, note := range ticket.AllergyAlerts {
+		document.AllergyAlerts = append(document.AllergyAlerts, note.Text)
+	}
+	for _, note := range ticket.Notes {
+		document.Notes = append(document.Notes, note.Text)
+	}
+	for _, item := range ticket.Items {
+		if orderItemIDs != nil && !containsString(orderItemIDs, item.OrderItemID) {
+			continue
+		}
+		entry := printer.KitchenItem{
+			Name:     item.FoodName,
+			Quantity: item.Quantity,
+			Course:   item.Course,
+			Seat:     item.Seat,
+			Hold:     item.Hold,
+			Status:   item.Status,
+		}
+		entry.Modifiers = item.Modifiers
+		for _, allergen := range item.AllergenWarnings {
+			entry.Warnings = append(entry.Warnings, strings.ReplaceAll(allergen, "_", " "))
+		}
+		for _, note := range item.Notes {
+			entry.Notes = append(entry.Notes, note.Text)
+		}
+		document.Items = append(document.Items, entry)
+	}
+	return printer.RenderKitchenTicket(document)
+}
+
+func receiptJob(ctx context.Context, invoice models.Invoice) ([]byte, error) {
+	document, err := invoiceReceipt(ctx, invoice)
+	if err != nil {
+		return nil, err
+	}
+	return printer.RenderReceipt(document)
+}
@@ -0,0 +1,437 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var promotionCollection *mongo.Collection = database.OpenCollection(database.Client, "promotion")
+
+func GetPromotions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}})
+		result, err := promotionCollection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing promotions"})
+			return
+		}
+
+		allPromotions := []models.Promotion{}
+		if err = result.All(ctx, &allPromotions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing promotions"})
+			return
+		}
+		c.JSON(http.StatusOK, allPromotions)
+	}
+}
+
+func GetPromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		promotionID := c.Param("promotion_id")
+		var promotion models.Promotion
+
+		err := promotionCollection.FindOne(ctx, bson.M{"promotion_id": promotionID}).Decode(&promotion)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Promotion not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching promotion details"})
+			return
+		}
+		c.JSON(http.StatusOK, promotion)
+	}
+}
+
+func CreatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var promotion models.Promotion
+
+		if !requireManager(c, ctx, "only a manager can change promotions") {
+			return
+		}
+		if err := c.BindJSON(&promotion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(promotion)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if msg := checkPromotionRules(promotion); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if promotion.CouponCode != nil {
+			code := strings.ToUpper(strings.TrimSpace(*promotion.CouponCode))
+			promotion.CouponCode = &code
+			codeCount, err := promotionCollection.CountDocuments(ctx, bson.M{"coupon_code": code})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching promotion details"})
+				return
+			}
+			if codeCount > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon code already exists"})
+				return
+			}
+		}
+
+		if promotion.Active == nil {
+			active := true
+			promotion.Active = &active
+		}
+		if promotion.Stackable == nil {
+			stackable := false
+			promotion.Stackable = &stackable
+		}
+		promotion.UsageCount = 0
+		promotion.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		promotion.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		promotion.ID = primitive.NewObjectID()
+		promotion.PromotionID = promotion.ID.Hex()
+
+		result, err := promotionCollection.InsertOne(ctx, promotion)
+		if mongo.IsDuplicateKeyError(err) {
+			// another promotion took the code since it was checked
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon code already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Promotion was not created"})
+			return
+		}
+		c.JSON(http.StatusCreated, result)
+	}
+}
+
+// UpdatePromotion replaces the rules of a promotion. The coupon code and the
+// usage count are left alone.
+func UpdatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		promotionID := c.Param("promotion_id")
+		var promotion models.Promotion
+
+		if !requireManager(c, ctx, "only a manager can change promotions") {
+			return
+		}
+		err := promotionCollection.FindOne(ctx, bson.M{"promotion_id": promotionID}).Decode(&promotion)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Promotion not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching promotion details"})
+			return
+		}
+
+		couponCode, usageCount := promotion.CouponCode, promotion.UsageCount
+		if err := c.BindJSON(&promotion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		promotion.CouponCode, promotion.UsageCount = couponCode, usageCount
+
+		if validationErr := validate.Struct(promotion); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if msg := checkPromotionRules(promotion); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		promotion.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj := primitive.D{
+			{Key: "name", Value: promotion.Name},
+			{Key: "description", Value: promotion.Description},
+			{Key: "discount_type", Value: promotion.DiscountType},
+			{Key: "value", Value: promotion.Value},
+			{Key: "amount", Value: promotion.Amount},
+			{Key: "scope", Value: promotion.Scope},
+			{Key: "food_ids", Value: promotion.FoodIDs},
+			{Key: "categories", Value: promotion.Categories},
+			{Key: "buy_quantity", Value: promotion.BuyQuantity},
+			{Key: "get_quantity", Value: promotion.GetQuantity},
+			{Key: "min_order_amount", Value: promotion.MinOrderAmount},
+			{Key: "start_date", Value: promotion.StartDate},
+			{Key: "end_date", Value: promotion.EndDate},
+			{Key: "days_of_week", Value: promotion.DaysOfWeek},
+			{Key: "start_time", Value: promotion.StartTime},
+			{Key: "end_time", Value: promotion.EndTime},
+			{Key: "usage_limit", Value: promotion.UsageLimit},
+			{Key: "stackable", Value: promotion.Stackable},
+			{Key: "priority", Value: promotion.Priority},
+			{Key: "active", Value: promotion.Active},
+			{Key: "updated_at", Value: promotion.UpdatedAt},
+		}
+
+		result, err := promotionCollection.UpdateOne(
+			ctx,
+			bson.M{"promotion_id": promotionID},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "promotion update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// ApplyCoupon redeems a coupon code on an unpaid invoice. The usage count is
+// taken atomically, so a coupon can't be used past its limit.
+func ApplyCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		invoiceID := c.Param("invoice_id")
+
+		var body struct {
+			CouponCode string `json:"coupon_code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		code := strings.ToUpper(strings.TrimSpace(body.CouponCode))
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		if invoiceIsSettled(invoice.PaymentStatus) {
+			c.JSON(http.StatusConflict, gin.H{"error": "invoice is already " + strings.ToLower(*invoice.PaymentStatus)})
+			return
+		}
+		applied := invoice.CouponCodes
+		if invoice.SplitType != nil {
+			splits, err := splitInvoices(ctx, invoice.OrderID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+				return
+			}
+			applied = splitCouponCodes(splits)
+		}
+		for _, applied := range applied {
+			if applied == code {
+				c.JSON(http.StatusConflict, gin.H{"error": "coupon is already applied"})
+				return
+			}
+		}
+
+		var promotion models.Promotion
+		err = promotionCollection.FindOne(ctx, bson.M{"coupon_code": code}).Decode(&promotion)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Coupon not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching promotion details"})
+			return
+		}
+		now := time.Now()
+		if (promotion.Active != nil && !*promotion.Active) ||
+			(promotion.StartDate != nil && now.Before(*promotion.StartDate)) ||
+			(promotion.EndDate != nil && now.After(*promotion.EndDate)) {
+			c.JSON(http.StatusConflict, gin.H{"error": "coupon is not valid at this time"})
+			return
+		}
+
+		result, err := promotionCollection.UpdateOne(
+			ctx,
+			bson.M{"promotion_id": promotion.PromotionID, "$or": bson.A{
+				bson.M{"usage_limit": nil},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$usage_count", "$usage_limit"}}},
+			}},
+			bson.D{{Key: "$inc", Value: bson.D{{Key: "usage_count", Value: 1}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "coupon could not be redeemed"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "coupon usage limit reached"})
+			return
+		}
+
+		// the coupon is only added if nobody applied it, or closed the
+		// invoice, since it was read; otherwise the redemption is given back
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		applyResult, err := invoiceCollection.UpdateOne(ctx,
+			bson.M{"invoice_id": invoiceID, "coupon_codes": bson.M{"$ne": code}, "payment_status": bson.M{"$nin": bson.A{"PAID", "REFUNDED", "VOIDED"}}},
+			bson.D{
+				{Key: "$push", Value: bson.D{{Key: "coupon_codes", Value: code}}},
+				{Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt}}},
+			},
+		)
+		if err != nil || applyResult.ModifiedCount == 0 {
+			_, rollbackErr := promotionCollection.UpdateOne(ctx, bson.M{"promotion_id": promotion.PromotionID}, bson.D{{Key: "$inc", Value: bson.D{{Key: "usage_count", Value: -1}}}})
+			if rollbackErr != nil {
+				log.Printf("promotions: redemption of coupon %s on invoice %s not released: %v", code, invoiceID, rollbackErr)
+			}
+			if err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "coupon is already applied or the invoice is closed"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "coupon could not be applied"})
+			return
+		}
+		if err := rebillOrderInvoices(ctx, invoice.OrderID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice could not be billed again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"invoice_id": invoiceID, "coupon_code": code, "promotion_id": promotion.PromotionID})
+	}
+}
+
+func RemoveCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		invoiceID := c.Param("invoice_id")
+		code := strings.ToUpper(c.Param("coupon_code"))
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := invoiceCollection.UpdateOne(
+			ctx,
+			bson.M{"invoice_id": invoiceID, "coupon_codes": code, "payment_status": bson.M{"$nin": bson.A{"PAID", "REFUNDED", "VOIDED"}}},
+			bson.D{
+				{Key: "$pull", Value: bson.D{{Key: "coupon_codes", Value: code}}},
+				{Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt}}},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "coupon could not be removed"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Coupon is not applied to an open invoice"})
+			return
+		}
+
+		_, err = promotionCollection.UpdateOne(
+			ctx,
+			bson.M{"coupon_code": code, "usage_count": bson.M{"$gt": 0}},
+			bson.D{{Key: "$inc", Value: bson.D{{Key: "usage_count", Value: -1}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "coupon usage could not be released"})
+			return
+		}
+		var invoice models.Invoice
+		err = invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+		if err == nil {
+			err = rebillOrderInvoices(ctx, invoice.OrderID)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice could not be billed again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"invoice_id": invoiceID, "coupon_code": code})
+	}
+}
+
+// checkPromotionRules checks the fields each discount type and scope needs.
+func checkPromotionRules(promotion models.Promotion) string {
+	switch *promotion.DiscountType {
+	case "PERCENTAGE":
+		if promotion.Value == nil || *promotion.Value <= 0 || *promotion.Value > 100 {
+			return "percentage promotions need a value between 0 and 100"
+		}
+	case "FIXED":
+		if promotion.Amount == nil || promotion.Amount.Cents <= 0 {
+			return "fixed promotions need a positive amount"
+		}
+		if promotion.Value != nil {
+			return "fixed promotions take an amount, not a value"
+		}
+	case "BUY_X_GET_Y":
+		if promotion.BuyQuantity == nil || promotion.GetQuantity == nil {
+			return "buy-x-get-y promotions need buy_quantity and get_quantity"
+		}
+		if promotion.Value != nil && *promotion.Value > 100 {
+			return "the value of a buy-x-get-y promotion is the percentage off the free items"
+		}
+	}
+
+	if msg := baseCurrencyError("amount", promotion.Amount); msg != "" {
+		return msg
+	}
+	if msg := baseCurrencyError("min_order_amount", promotion.MinOrderAmount); msg != "" {
+		return msg
+	}
+
+	switch *promotion.Scope {
+	case "ITEM":
+		if len(promotion.FoodIDs) == 0 {
+			return "item promotions need food_ids"
+		}
+	case "CATEGORY":
+		if len(promotion.Categories) == 0 {
+			return "category promotions need categories"
+		}
+	}
+
+	if promotion.StartDate != nil && promotion.EndDate != nil && promotion.EndDate.Before(*promotion.StartDate) {
+		return "end_date must be after start_date"
+	}
+	return ""
+}
+
+func activePromotions(ctx context.Context) ([]models.Promotion, error) {
+	promotions := []models.Promotion{}
+	result, err := promotionCollection.Find(ctx, bson.M{"active": bson.M{"$ne": false}})
+	if err != nil {
+		return promotions, err
+	}
+	err = result.All(ctx, &promotions)
+	return promotions, err
+}
+
+// EnsurePromotionIndexes creates the unique index that keeps two promotions
+// from sharing a coupon code.
+func EnsurePromotionIndexes(ctx context.Context) error {
+	_, err := promotionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "coupon_code", Value: 1}},
+		Options: options.Index().
+			SetName("promotion_coupon_code_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"coupon_code": bson.M{"$type": "string"}}),
+	})
+	return err
+}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/receipt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetInvoiceReceipt renders an invoice as a printable receipt. The format
+// query parameter picks pdf, html (the default) or txt.
+func GetInvoiceReceipt() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		invoiceID := c.Param("invoice_id")
+
+		format := c.DefaultQuery("format", "html")
+		if format != "pdf" && format != "html" && format != "txt" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf, html or txt"})
+			return
+		}
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+
+		document, err := invoiceReceipt(ctx, invoice)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while preparing the receipt"})
+			return
+		}
+
+		var out bytes.Buffer
+		contentType := "text/html; charset=utf-8"
+		switch format {
+		case "pdf":
+			err = receipt.PDF(&out, document)
+			contentType = "application/pdf"
+			c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="receipt-%s.pdf"`, invoiceID))
+		case "txt":
+			err = receipt.Text(&out, document)
+			contentType = "text/plain; charset=utf-8"
+		default:
+			err = receipt.HTML(&out, document)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while rendering the receipt"})
+			return
+		}
+		c.Data(http.StatusOK, contentType, out.Bytes())
+	}
+}
+
+// invoiceReceipt gathers what goes on an invoice's receipt: the restaurant's
+// details, the invoice as GetInvoice shows it and the payments taken.
+func invoiceReceipt(ctx context.Context, invoice models.Invoice) (receipt.Receipt, error) {
+	var document receipt.Receipt
+	restaurant, err := restaurantSettings(ctx)
+	if err != nil {
+		return document, err
+	}
+	view, err := buildInvoiceView(ctx, invoice)
+	if err != nil {
+		return document, err
+	}
+
+	for _, line := range []*string{restaurant.Name, restaurant.Address, restaurant.Phone, restaurant.Email, restaurant.Website} {
+		if line != nil && *line != "" {
+			document.Header = append(document.Header, *line)
+		}
+	}
+	if restaurant.TaxID != nil && *restaurant.TaxID != "" {
+		document.Header = append(document.Header, "Tax ID: "+*restaurant.TaxID)
+	}
+	if restaurant.ReceiptFooter != nil && *restaurant.ReceiptFooter != "" {
+		document.Footer = strings.Split(*restaurant.ReceiptFooter, "\n")
+	}
+
+	document.Title = "Invoice"
+	if view.PaymentStatus != nil && *view.PaymentStatus == "VOIDED" {
+		document.Title = "Voided invoice"
+	} else if invoiceIsSettled(view.PaymentStatus) {
+		document.Title = "Receipt"
+	}
+	invoiceNumber := view.InvoiceNumber
+	if invoiceNumber == "" {
+		invoiceNumber = view.InvoiceID
+	}
+	document.Details = append(document.Details, receipt.Field{Label: "Invoice", Value: invoiceNumber})
+	document.Details = append(document.Details, receipt.Field{Label: "Order", Value: view.OrderID})
+	if view.TableNumber != nil {
+		document.Details = append(document.Details, receipt.Field{Label: "Table", Value: fmt.Sprint(*view.TableNumber)})
+	}
+	document.Details = append(document.Details, receipt.Field{Label: "Type", Value: strings.ReplaceAll(view.OrderType, "_", " ")})
+	if view.CustomerName != nil {
+		document.Details = append(document.Details, receipt.Field{Label: "Customer", Value: *view.CustomerName})
+	}
+	if view.ServerID != nil {
+		if server, err := helper.UserByID(ctx, *view.ServerID); err == nil && server.FirstName != nil {
+			document.Details = append(document.Details, receipt.Field{Label: "Server", Value: *server.FirstName})
+		}
+	}
+	if view.SplitType != nil && view.SplitIndex != nil && view.SplitCount != nil {
+		document.Details = append(document.Details, receipt.Field{Label: "Split", Value: fmt.Sprintf("%d of %d", *view.SplitIndex, *view.SplitCount)})
+	}
+	if view.PaymentStatus != nil {
+		document.Details = append(document.Details, receipt.Field{Label: "Status", Value: strings.ReplaceAll(*view.PaymentStatus, "_", " ")})
+	}
+
+	for _, line := range view.OrderDetails {
+		document.Items = append(document.Items, receipt.Item{
+			Name:      line.FoodName,
+			Quantity:  line.Quantity,
+			UnitPrice: line.Price,
+			Amount:    line.Amount,
+			Seat:      line.Seat,
+		})
+	}
+	document.Subtotal = view.Subtotal
+	for _, discount := range view.Discounts {
+		label := discount.Name
+		if discount.CouponCode != "" {
+			label += " (" + discount.CouponCode + ")"
+		}
+		document.Discounts = append(document.Discounts, receipt.Amount{Label: label, Amount: discount.Amount})
+	}
+	document.SharedAmount = view.SharedAmount
+	for _, serviceCharge := range view.ServiceCharges {
+		document.ServiceCharges = append(document.ServiceCharges, receipt.Amount{
+			Label:  fmt.Sprintf("%s %g%%", serviceCharge.Name, serviceCharge.Rate),
+			Amount: serviceCharge.Amount,
+		})
+	}
+	for _, tax := range view.TaxLines {
+		document.Taxes = append(document.Taxes, receipt.Amount{
+			Label:    fmt.Sprintf("%s %g%%", tax.Name, tax.Rate),
+			Amount:   tax.TaxAmount,
+			Included: tax.Inclusive,
+		})
+	}
+	if view.DeliveryFee != nil && view.SplitType == nil {
+		document.DeliveryFee = *view.DeliveryFee
+	}
+	document.Total = view.PaymentDue
+
+	for _, payment := range view.Payments {
+		if payment.Status == "DECLINED" || payment.Status == "VOIDED" {
+			continue
+		}
+		entry := receipt.Payment{Method: *payment.Method, Change: payment.Change}
+		if payment.Amount != nil {
+			entry.Amount = *payment.Amount
+		}
+		if payment.Tip != nil {
+			entry.Tip = *payment.Tip
+		}
+		if payment.ForeignTendered != nil && payment.ExchangeRate != nil {
+			entry.Foreign = *payment.ForeignTendered
+			entry.Rate = strconv.FormatFloat(*payment.ExchangeRate, 'f', -1, 64)
+		}
+		if payment.Tendered != nil {
+			entry.Tendered = *payment.Tendered
+		}
+		if payment.Reference != nil {
+			entry.Reference = *payment.Reference
+		}
+		if !paymentSettled(payment) {
+			entry.Status = payment.Status
+		}
+		document.Payments = append(document.Payments, entry)
+	}
+	document.TipTotal = view.TipTotal
+	document.AmountPaid = view.AmountPaid
+	document.RefundedTotal = view.RefundedTotal
+	document.Balance = view.Balance
+	document.PrintedAt = time.Now()
+	return document, nil
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var restaurantCollection *mongo.Collection = database.OpenCollection(database.Client, "restaurant")
+
+func GetRestaurant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		restaurant, err := restaurantSettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching restaurant details"})
+			return
+		}
+		c.JSON(http.StatusOK, restaurant)
+	}
+}
+
+// UpdateRestaurant changes the restaurant's details, creating the restaurant
+// document the first time it is called.
+func UpdateRestaurant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var restaurant models.Restaurant
+
+		if !requireManager(c, ctx, "only a manager can change the restaurant's settings") {
+			return
+		}
+		if err := c.BindJSON(&restaurant); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(restaurant); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if restaurant.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: restaurant.Name})
+		}
+		if restaurant.Address != nil {
+			updateObj = append(updateObj, bson.E{Key: "address", Value: restaurant.Address})
+		}
+		if restaurant.Phone != nil {
+			updateObj = append(updateObj, bson.E{Key: "phone", Value: restaurant.Phone})
+		}
+		if restaurant.Email != nil {
+			updateObj = append(updateObj, bson.E{Key: "email", Value: restaurant.Email})
+		}
+		if restaurant.Website != nil {
+			updateObj = append(updateObj, bson.E{Key: "website", Value: restaurant.Website})
+		}
+		if restaurant.TaxID != nil {
+			updateObj = append(updateObj, bson.E{Key: "tax_id", Value: restaurant.TaxID})
+		}
+		if restaurant.ReceiptFooter != nil {
+			updateObj = append(updateObj, bson.E{Key: "receipt_footer", Value: restaurant.ReceiptFooter})
+		}
+		if restaurant.InvoicePrefix != nil {
+			updateObj = append(updateObj, bson.E{Key: "invoice_prefix", Value: restaurant.InvoicePrefix})
+		}
+		if restaurant.InvoiceNumberFormat != nil {
+			if !helper.ValidInvoiceNumberFormat(*restaurant.InvoiceNumberFormat) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invoice_number_format must contain {SEQ} or {SEQ:n} and {FY} or {FY_END}"})
+				return
+			}
+			// each branch counts from 1, so their numbers only differ by
+			// the branch code
+			if !helper.FormatHasBranch(*restaurant.InvoiceNumberFormat) {
+				numbering, err := invoiceNumbering(ctx)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking invoice numbering"})
+					return
+				}
+				others, err := helper.OtherBranchesNumbered(ctx, numbering.Branch)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking invoice numbering"})
+					return
+				}
+				if others {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invoice_number_format must contain {BRANCH} when several branches number invoices"})
+					return
+				}
+			}
+			updateObj = append(updateObj, bson.E{Key: "invoice_number_format", Value: restaurant.InvoiceNumberFormat})
+		}
+		if restaurant.FiscalYearStartMonth != nil {
+			updateObj = append(updateObj, bson.E{Key: "fiscal_year_start_month", Value: restaurant.FiscalYearStartMonth})
+		}
+		if restaurant.BaseCurrency != nil {
+			currency, _ := currencyCode(*restaurant.BaseCurrency)
+			if currency != money.DefaultCurrency() {
+				// amounts already stored would silently change currency
+				msg, err := baseCurrencyInUse(ctx, currency)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking stored amounts"})
+					return
+				}
+				if msg != "" {
+					c.JSON(http.StatusConflict, gin.H{"error": msg})
+					return
+				}
+			}
+			restaurant.BaseCurrency = &currency
+			updateObj = append(updateObj, bson.E{Key: "base_currency", Value: restaurant.BaseCurrency})
+		}
+		if restaurant.Timezone != nil {
+			if _, err := time.LoadLocation(*restaurant.Timezone); err != nil || *restaurant.Timezone == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA timezone such as Europe/Paris"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "timezone", Value: restaurant.Timezone})
+		}
+		if restaurant.DisplayCurrencies != nil {
+			for i, currency := range restaurant.DisplayCurrencies {
+				restaurant.DisplayCurrencies[i], _ = currencyCode(currency)
+			}
+			updateObj = append(updateObj, bson.E{Key: "display_currencies", Value: restaurant.DisplayCurrencies})
+		}
+
+		restaurant.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: restaurant.UpdatedAt})
+
+		id := primitive.NewObjectID()
+		upsert := true
+		opt := options.UpdateOptions{Upsert: &upsert}
+		_, err := restaurantCollection.UpdateOne(
+			ctx,
+			bson.M{},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+				{Key: "$setOnInsert", Value: bson.D{
+					{Key: "_id", Value: id},
+					{Key: "restaurant_id", Value: id.Hex()},
+					{Key: "created_at", Value: restaurant.UpdatedAt},
+				}},
+			},
+			&opt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "restaurant update failed"})
+			return
+		}
+
+		restaurant, err = restaurantSettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching restaurant details"})
+			return
+		}
+		if restaurant.BaseCurrency != nil {
+			money.SetDefaultCurrency(*restaurant.BaseCurrency)
+		}
+		c.JSON(http.StatusOK, restaurant)
+	}
+}
+
+// UseBaseCurrency makes the restaurant's base currency, when it has one, the
+// currency amounts are in.
+func UseBaseCurrency(ctx context.Context) error {
+	restaurant, err := restaurantSettings(ctx)
+	if err != nil {
+		return err
+	}
+	if restaurant.BaseCurrency != nil {
+		money.SetDefaultCurrency(*restaurant.BaseCurrency)
+	}
+	return nil
+}
+
+// restaurantSettings loads the restaurant's details. Until they are set, an
+// empty restaurant is returned.
+func restaurantSettings(ctx context.Context) (models.Restaurant, error) {
+	var restaurant models.Restaurant
+	err := restaurantCollection.FindOne(ctx, bson.M{}).Decode(&restaurant)
+	if err == mongo.ErrNoDocuments {
+		return restaurant, nil
+	}
+	return restaurant, err
+}
+
+// restaurantLocation is the restaurant's timezone, the server's own until one
+// is set.
+func restaurantLocation(ctx context.Context) (*time.Location, error) {
+	restaurant, err := restaurantSettings(ctx)
+	if err != nil || restaurant.Timezone == nil {
+		return time.Local, err
+	}
+	return time.LoadLocation(*restaurant.Timezone)
+}
+
+// baseCurrencyInUse says why the base currency can't be changed to
+// currency: orders have been taken, foods or promotions have amounts in
+// another currency, or exchange rates are quoted against the current one.
+// An empty message means it can.
+func baseCurrencyInUse(ctx context.Context, currency string) (string, error) {
+	count, err := orderCollection.CountDocuments(ctx, bson.M{})
+	if err != nil || count > 0 {
+		return "base_currency can't be changed once orders have been taken", err
+	}
+	count, err = foodCollection.CountDocuments(ctx, bson.M{"price.currency": bson.M{"$ne": currency}})
+	if err != nil || count > 0 {
+		return "base_currency can't be changed while foods are priced in another currency", err
+	}
+	count, err = promotionCollection.CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"amount.currency": bson.M{"$exists": true, "$ne": currency}},
+		bson.M{"min_order_amount.currency": bson.M{"$exists": true, "$ne": currency}},
+	}})
+	if err != nil || count > 0 {
+		return "base_currency can't be changed while promotions have amounts in another currency", err
+	}
+	count, err = exchangeRateCollection.CountDocuments(ctx, bson.M{})
+	if err != nil || count > 0 {
+		return "base_currency can't be changed while exchange rates are set against the current one", err
+	}
+	return "", nil
+}
+
+// invoiceNumbering is how invoices are numbered: the prefix, format and
+// fiscal year from the restaurant's settings and the branch from
+// BRANCH_CODE.
+func invoiceNumbering(ctx context.Context) (helper.InvoiceNumbering, error) {
+	numbering := helper.InvoiceNumbering{Prefix: "INV", Branch: os.Getenv("BRANCH_CODE"), FiscalYearStartMonth: 1}
+	if numbering.Branch == "" {
+		numbering.Branch = "MAIN"
+	}
+	restaurant, err := restaurantSettings(ctx)
+	if err != nil {
+		return numbering, err
+	}
+	if restaurant.InvoicePrefix != nil {
+		numbering.Prefix = *restaurant.InvoicePrefix
+	}
+	if restaurant.InvoiceNumberFormat != nil {
+		numbering.Format = *restaurant.InvoiceNumberFormat
+	}
+	if restaurant.FiscalYearStartMonth != nil {
+		numbering.FiscalYearStartMonth = *restaurant.FiscalYearStartMonth
+	}
+	return numbering, nil
+}
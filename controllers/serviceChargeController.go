@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"atm1504.in/rms/database"
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var serviceChargeCollection *mongo.Collection = database.OpenCollection(database.Client, "serviceCharge")
+
+type ServiceChargeLine struct {
+	ServiceChargeID string      `bson:"service_charge_id" json:"service_charge_id"`
+	Name            string      `bson:"name" json:"name"`
+	Rate            float64     `bson:"rate" json:"rate"`
+	Amount          money.Money `bson:"amount" json:"amount"`
+	AmountCents     int64       `bson:"-" json:"-"`
+}
+
+func GetServiceCharges() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		result, err := serviceChargeCollection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing service charges"})
+			return
+		}
+
+		allServiceCharges := []models.ServiceCharge{}
+		if err = result.All(ctx, &allServiceCharges); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing service charges"})
+			return
+		}
+		c.JSON(http.StatusOK, allServiceCharges)
+	}
+}
+
+func GetServiceCharge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		serviceChargeID := c.Param("service_charge_id")
+		var serviceCharge models.ServiceCharge
+
+		err := serviceChargeCollection.FindOne(ctx, bson.M{"service_charge_id": serviceChargeID}).Decode(&serviceCharge)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Service charge not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching service charge details"})
+			return
+		}
+		c.JSON(http.StatusOK, serviceCharge)
+	}
+}
+
+func CreateServiceCharge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var serviceCharge models.ServiceCharge
+
+		if !requireManager(c, ctx, "only a manager can change service charges") {
+			return
+		}
+		if err := c.BindJSON(&serviceCharge); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(serviceCharge)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if serviceCharge.Active == nil {
+			active := true
+			serviceCharge.Active = &active
+		}
+		if serviceCharge.OrderTypes == nil {
+			serviceCharge.OrderTypes = []string{}
+		}
+		serviceCharge.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		serviceCharge.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		serviceCharge.ID = primitive.NewObjectID()
+		serviceCharge.ServiceChargeID = serviceCharge.ID.Hex()
+
+		result, err := serviceChargeCollection.InsertOne(ctx, serviceCharge)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Service charge was not created"})
+			return
+		}
+		c.JSON(http.StatusCreated, result)
+	}
+}
+
+func UpdateServiceCharge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var serviceCharge models.ServiceCharge
+		serviceChargeID := c.Param("service_charge_id")
+
+		if !requireManager(c, ctx, "only a manager can change service charges") {
+			return
+		}
+		if err := c.BindJSON(&serviceCharge); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.StructPartial(serviceCharge, "MinPartySize", "OrderTypes"); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if serviceCharge.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: serviceCharge.Name})
+		}
+		if serviceCharge.Rate != nil {
+			if *serviceCharge.Rate < 0 || *serviceCharge.Rate > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "rate must be between 0 and 100"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "rate", Value: serviceCharge.Rate})
+		}
+		if serviceCharge.MinPartySize != nil {
+			updateObj = append(updateObj, bson.E{Key: "min_party_size", Value: serviceCharge.MinPartySize})
+		}
+		if serviceCharge.OrderTypes != nil {
+			updateObj = append(updateObj, bson.E{Key: "order_types", Value: serviceCharge.OrderTypes})
+		}
+		if serviceCharge.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: serviceCharge.Active})
+		}
+
+		serviceCharge.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: serviceCharge.UpdatedAt})
+
+		result, err := serviceChargeCollection.UpdateOne(
+			ctx,
+			bson.M{"service_charge_id": serviceChargeID},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "service charge update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Service charge not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// serviceChargesFor works out the automatic service charges of an order. A
+// rule applies to the order types it lists (all when it lists none) and,
+// when it has a minimum party size, only to tables seating at least that
+// many guests. The charge is a percentage of the subtotal after discounts.
+func serviceChargesFor(rules []models.ServiceCharge, orderType string, guests *int, baseCents int64) ([]ServiceChargeLine, int64) {
+	lines := []ServiceChargeLine{}
+	var total int64
+	for _, rule := range rules {
+		if len(rule.OrderTypes) > 0 && !containsString(rule.OrderTypes, orderType) {
+			continue
+		}
+		if rule.MinPartySize != nil && (guests == nil || *guests < *rule.MinPartySize) {
+			continue
+		}
+		cents := helper.PercentOf(baseCents, *rule.Rate)
+		if cents == 0 {
+			continue
+		}
+		lines = append(lines, ServiceChargeLine{
+			ServiceChargeID: rule.ServiceChargeID,
+			Name:            *rule.Name,
+			Rate:            *rule.Rate,
+			Amount:          money.Of(cents),
+			AmountCents:     cents,
+		})
+		total += cents
+	}
+	return lines, total
+}
+
+func activeServiceCharges(ctx context.Context) ([]models.ServiceCharge, error) {
+	serviceCharges := []models.ServiceCharge{}
+	result, err := serviceChargeCollection.Find(ctx, bson.M{"active": bson.M{"$ne": false}})
+	if err != nil {
+		return serviceCharges, err
+	}
+	err = result.All(ctx, &serviceCharges)
+	return serviceCharges, err
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,416 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"atm1504.in/rms/gateway"
+	helper "atm1504.in/rms/helpers"
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxSplitShares = 50
+
+type BillSplit struct {
+	SplitType *string    `json:"split_type" validate:"required,eq=ITEM|eq=SEAT|eq=EVEN"`
+	Groups    [][]string `json:"groups"`
+	Shares    int        `json:"shares"`
+}
+
+// splitShare is the part of an order billed on one split invoice: its own
+// lines priced on their own, plus an even part of everything shared.
+type splitShare struct {
+	Lines         []OrderLine
+	SharedLines   []OrderLine
+	SubtotalCents int64
+	Discounts     []helper.DiscountLine
+	DiscountCents int64
+	Taxes         helper.TaxSummary
+	SharedCents   int64
+	DueCents      int64
+}
+
+// SplitOrder replaces the invoice of an order with one invoice per group of
+// items, per seat or per even share. The split invoices get new numbers and
+// the invoices they replace are kept as voided. Coupons already redeemed on
+// the order are carried over to the split.
+func SplitOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		orderID := c.Param("order_id")
+		var split BillSplit
+
+		if err := c.BindJSON(&split); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(split); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if _, status, msg := findOpenOrder(ctx, orderID); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		paid, err := orderIsPaid(ctx, orderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		if paid {
+			c.JSON(http.StatusConflict, gin.H{"error": "order " + orderID + " is already paid"})
+			return
+		}
+
+		var previous []models.Invoice
+		result, err := invoiceCollection.Find(ctx, bson.M{"order_id": orderID, "payment_status": bson.M{"$ne": "VOIDED"}})
+		if err == nil {
+			err = result.All(ctx, &previous)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching invoice details"})
+			return
+		}
+		// the invoices are replaced, so none of them may have money against it
+		taken, err := invoicesHaveMoneyTaken(ctx, previous)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching payment details"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "order " + orderID + " already has payments or refunds against its invoices"})
+			return
+		}
+
+		lines, err := OrderLines(ctx, orderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing order items by order ID"})
+			return
+		}
+		invoices, msg := splitInvoiceDrafts(split, lines)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		dueDate, _ := time.Parse(time.RFC3339, time.Now().AddDate(0, 0, 1).Format(time.RFC3339))
+		var billingEmail *string
+		// splitting doesn't shorten the credit terms of the bill it replaces
+		for _, invoice := range previous {
+			if invoice.PaymentDueDate.After(dueDate) {
+				dueDate = invoice.PaymentDueDate
+			}
+			if invoice.BillingEmail != nil {
+				billingEmail = invoice.BillingEmail
+			}
+		}
+		pending := "PENDING"
+		count := len(invoices)
+		docs := make([]interface{}, count)
+		for i := range invoices {
+			index := i + 1
+			invoices[i].ID = primitive.NewObjectID()
+			invoices[i].InvoiceID = invoices[i].ID.Hex()
+			invoices[i].OrderID = orderID
+			invoices[i].PaymentStatus = &pending
+			invoices[i].PaymentDueDate = dueDate
+			invoices[i].BillingEmail = billingEmail
+			invoices[i].CouponCodes = []string{}
+			invoices[i].SplitType = split.SplitType
+			invoices[i].SplitIndex = &index
+			invoices[i].SplitCount = &count
+			invoices[i].CreatedAt = now
+			invoices[i].UpdatedAt = now
+		}
+		invoices[0].CouponCodes = splitCouponCodes(previous)
+
+		invoices, err = numberInvoices(ctx, invoices)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice numbers were not assigned"})
+			return
+		}
+		for i := range invoices {
+			docs[i] = invoices[i]
+		}
+
+		if _, err := invoiceCollection.InsertMany(ctx, docs); err != nil {
+			abandonSplitInvoices(ctx, invoices)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "split invoices were not created"})
+			return
+		}
+		// the invoices replaced are kept, voided, so their numbers stay
+		// accounted for
+		previousIDs := make([]string, len(previous))
+		for i, invoice := range previous {
+			previousIDs[i] = invoice.InvoiceID
+		}
+		_, err = invoiceCollection.UpdateMany(ctx,
+			bson.M{"invoice_id": bson.M{"$in": previousIDs}},
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "payment_status", Value: "VOIDED"},
+				{Key: "updated_at", Value: now},
+			}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "previous invoices were not voided"})
+			return
+		}
+
+		views := []InvoiceViewFormat{}
+		for i := range invoices {
+			if err := billInvoice(ctx, &invoices[i]); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while pricing split invoices"})
+				return
+			}
+		}
+		for _, invoice := range invoices {
+			view, err := buildInvoiceView(ctx, invoice)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while pricing split invoices"})
+				return
+			}
+			views = append(views, view)
+		}
+		c.JSON(http.StatusCreated, gin.H{"order_id": orderID, "invoices": views})
+	}
+}
+
+// splitInvoiceDrafts turns a split request into one invoice per share,
+// checking the groups against the charged lines of the order.
+func splitInvoiceDrafts(split BillSplit, lines []OrderLine) ([]models.Invoice, string) {
+	charged := map[string]OrderLine{}
+	for _, line := range lines {
+		if line.Status != "VOIDED" {
+			charged[line.OrderItemID] = line
+		}
+	}
+
+	var invoices []models.Invoice
+	switch *split.SplitType {
+	case "ITEM":
+		if len(split.Groups) < 2 {
+			return nil, "an item split needs at least two groups"
+		}
+		assigned := map[string]bool{}
+		for _, group := range split.Groups {
+			if len(group) == 0 {
+				return nil, "every group needs at least one order item"
+			}
+			for _, orderItemID := range group {
+				if _, ok := charged[orderItemID]; !ok {
+					return nil, "order item " + orderItemID + " is not a charged item of this order"
+				}
+				if assigned[orderItemID] {
+					return nil, "order item " + orderItemID + " is in more than one group"
+				}
+				assigned[orderItemID] = true
+			}
+			invoices = append(invoices, models.Invoice{OrderItemIDs: group})
+		}
+	case "SEAT":
+		seen := map[int]bool{}
+		var seats []int
+		for _, line := range lines {
+			if line.Status == "VOIDED" || line.Seat == nil || seen[*line.Seat] {
+				continue
+			}
+			seen[*line.Seat] = true
+			seats = append(seats, *line.Seat)
+		}
+		if len(seats) < 2 {
+			return nil, "a seat split needs items on at least two seats"
+		}
+		sort.Ints(seats)
+		for i := range seats {
+			invoices = append(invoices, models.Invoice{Seat: &seats[i]})
+		}
+	case "EVEN":
+		if split.Shares < 2 || split.Shares > maxSplitShares {
+			return nil, "shares must be between 2 and " + strconv.Itoa(maxSplitShares)
+		}
+		invoices = make([]models.Invoice, split.Shares)
+	}
+	return invoices, ""
+}
+
+// splitShares works out what each split invoice of an order pays. Lines that
+// belong to an invoice (by item or by seat) are priced for it on their own;
+// everything else, including delivery fees and the cents lost to rounding
+// the parts separately, is spread evenly. The shares add up to the order
+// total exactly.
+func splitShares(pricing orderPricing, splits []models.Invoice) map[string]splitShare {
+	shares := make([]splitShare, len(splits))
+	var sharedLines []OrderLine
+	for _, line := range pricing.Lines {
+		owner := splitOwner(splits, line)
+		if owner < 0 {
+			sharedLines = append(sharedLines, line)
+			continue
+		}
+		shares[owner].Lines = append(shares[owner].Lines, line)
+	}
+
+	var ownCents int64
+	weights := make([]int64, len(splits))
+	for i := range shares {
+		share := &shares[i]
+		weights[i] = 1
+		if share.Lines == nil {
+			share.Lines = []OrderLine{}
+		}
+		share.SharedLines = sharedLines
+		for _, line := range share.Lines {
+			share.SubtotalCents += line.Amount.Cents
+		}
+
+		share.Discounts = []helper.DiscountLine{}
+		for _, discount := range pricing.Discounts.Lines {
+			var cents int64
+			for _, line := range share.Lines {
+				cents += discount.LineCents[line.OrderItemID]
+			}
+			if cents == 0 {
+				continue
+			}
+			discount.AmountCents = cents
+			discount.Amount = money.Of(cents)
+			share.Discounts = append(share.Discounts, discount)
+			share.DiscountCents += cents
+		}
+
+		share.Taxes = pricing.taxesFor(share.Lines)
+		share.DueCents = share.SubtotalCents - share.DiscountCents + share.Taxes.ExclusiveTaxCents
+		ownCents += share.DueCents
+	}
+
+	for i, part := range helper.AllocateCents(pricing.DueCents-ownCents, weights) {
+		shares[i].SharedCents = part
+		shares[i].DueCents += part
+	}
+
+	byInvoice := map[string]splitShare{}
+	for i, split := range splits {
+		byInvoice[split.InvoiceID] = shares[i]
+	}
+	return byInvoice
+}
+
+// splitOwner returns the index of the split invoice a line belongs to, or -1
+// when it is shared.
+func splitOwner(splits []models.Invoice, line OrderLine) int {
+	for i, split := range splits {
+		switch *split.SplitType {
+		case "ITEM":
+			for _, orderItemID := range split.OrderItemIDs {
+				if orderItemID == line.OrderItemID {
+					return i
+				}
+			}
+		case "SEAT":
+			if split.Seat != nil && line.Seat != nil && *split.Seat == *line.Seat {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// invoicesHaveMoneyTaken reports whether any of invoices has a payment that
+// went through, or may still, or a credit note.
+func invoicesHaveMoneyTaken(ctx context.Context, invoices []models.Invoice) (bool, error) {
+	if len(invoices) == 0 {
+		return false, nil
+	}
+	invoiceIDs := []string{}
+	for _, invoice := range invoices {
+		invoiceIDs = append(invoiceIDs, invoice.InvoiceID)
+	}
+	count, err := paymentCollection.CountDocuments(ctx, bson.M{
+		"invoice_id": bson.M{"$in": invoiceIDs},
+		"status":     bson.M{"$nin": bson.A{gateway.StatusDeclined, gateway.StatusVoided}},
+	})
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	count, err = creditNoteCollection.CountDocuments(ctx, bson.M{"invoice_id": bson.M{"$in": invoiceIDs}})
+	return count > 0, err
+}
+
+func splitInvoices(ctx context.Context, orderID string) ([]models.Invoice, error) {
+	invoices := []models.Invoice{}
+	opts := options.Find().SetSort(bson.D{{Key: "split_index", Value: 1}})
+	result, err := invoiceCollection.Find(ctx, bson.M{"order_id": orderID, "split_type": bson.M{"$exists": true}, "payment_status": bson.M{"$ne": "VOIDED"}}, opts)
+	if err != nil {
+		return invoices, err
+	}
+	err = result.All(ctx, &invoices)
+	return invoices, err
+}
+
+// splitCouponCodes collects the coupons redeemed on any invoice of an order.
+func splitCouponCodes(invoices []models.Invoice) []string {
+	codes := []string{}
+	seen := map[string]bool{}
+	for _, invoice := range invoices {
+		for _, code := range invoice.CouponCodes {
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	return codes
+}
+
+// abandonSplitInvoices cleans up after split invoices that were not all
+// stored. The ones stored are voided and keep their numbers; the numbers of
+// the others are handed back.
+func abandonSplitInvoices(ctx context.Context, invoices []models.Invoice) {
+	ids := make([]string, len(invoices))
+	for i, invoice := range invoices {
+		ids[i] = invoice.InvoiceID
+	}
+	var stored []models.Invoice
+	result, err := invoiceCollection.Find(ctx, bson.M{"invoice_id": bson.M{"$in": ids}})
+	if err == nil {
+		err = result.All(ctx, &stored)
+	}
+	if err != nil {
+		// numbers that may be on an invoice are never handed back
+		log.Printf("split invoices %v could not be checked: %v", ids, err)
+		return
+	}
+	isStored := map[string]bool{}
+	for _, invoice := range stored {
+		isStored[invoice.InvoiceID] = true
+	}
+	var unstored []models.Invoice
+	for _, invoice := range invoices {
+		if !isStored[invoice.InvoiceID] {
+			unstored = append(unstored, invoice)
+		}
+	}
+	releaseInvoiceNumbers(ctx, unstored)
+	if len(stored) == 0 {
+		return
+	}
+	_, err = invoiceCollection.UpdateMany(ctx,
+		bson.M{"invoice_id": bson.M{"$in": ids}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "payment_status", Value: "VOIDED"}}}},
+	)
+	if err != nil {
+		log.Printf("split invoices %v could not be voided: %v", ids, err)
+	}
+}
@@ -2,7 +2,6 @@ package controller
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"time"
 
@@ -17,20 +16,36 @@ import (
 
 var tableCollection *mongo.Collection = database.OpenCollection(database.Client, "table")
 
+var tableListSpec = listSpec{
+	filters: []listFilter{
+		{param: "table_number", field: "table_number", numeric: true},
+		{param: "number_of_guests", field: "number_of_guests", numeric: true},
+	},
+	dateField: "created_at",
+	sorts: map[string]string{
+		"table_number":     "table_number",
+		"number_of_guests": "number_of_guests",
+		"created_at":       "created_at",
+	},
+	defaultSort: "table_number",
+}
+
 func GetTables() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		result, err := tableCollection.Find(context.TODO(), bson.M{})
 		defer cancel()
+
+		query, ok := listRequest(c, ctx, tableListSpec)
+		if !ok {
+			return
+		}
+		allTables := []models.Table{}
+		page, err := findPage(ctx, tableCollection, query, &allTables, nil)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing table items"})
+			return
 		}
-		var allTables []bson.M
-		if err = result.All(ctx, &allTables); err != nil {
-			log.Fatal(err)
-		}
-		c.JSON(http.StatusOK, allTables)
+		c.JSON(http.StatusOK, pageResponse("tables", allTables, query, page))
 	}
 }
 
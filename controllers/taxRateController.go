@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var taxRateCollection *mongo.Collection = database.OpenCollection(database.Client, "taxRate")
+
+func GetTaxRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "code", Value: 1}})
+		result, err := taxRateCollection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing tax rates"})
+			return
+		}
+
+		allTaxRates := []models.TaxRate{}
+		if err = result.All(ctx, &allTaxRates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while processing tax rates"})
+			return
+		}
+		c.JSON(http.StatusOK, allTaxRates)
+	}
+}
+
+func GetTaxRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		taxRateID := c.Param("tax_rate_id")
+		var taxRate models.TaxRate
+
+		err := taxRateCollection.FindOne(ctx, bson.M{"tax_rate_id": taxRateID}).Decode(&taxRate)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Tax rate not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching tax rate details"})
+			return
+		}
+		c.JSON(http.StatusOK, taxRate)
+	}
+}
+
+func CreateTaxRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var taxRate models.TaxRate
+
+		if !requireManager(c, ctx, "only a manager can change tax rates") {
+			return
+		}
+		if err := c.BindJSON(&taxRate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(taxRate)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		code := strings.ToUpper(*taxRate.Code)
+		taxRate.Code = &code
+		codeCount, err := taxRateCollection.CountDocuments(ctx, bson.M{"code": code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching tax rate details"})
+			return
+		}
+		if codeCount > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tax rate code already exists"})
+			return
+		}
+
+		if taxRate.Active == nil {
+			active := true
+			taxRate.Active = &active
+		}
+		if taxRate.Categories == nil {
+			taxRate.Categories = []string{}
+		}
+		taxRate.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		taxRate.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		taxRate.ID = primitive.NewObjectID()
+		taxRate.TaxRateID = taxRate.ID.Hex()
+
+		result, err := taxRateCollection.InsertOne(ctx, taxRate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Tax rate was not created"})
+			return
+		}
+		c.JSON(http.StatusCreated, result)
+	}
+}
+
+func UpdateTaxRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var taxRate models.TaxRate
+		taxRateID := c.Param("tax_rate_id")
+
+		if !requireManager(c, ctx, "only a manager can change tax rates") {
+			return
+		}
+		if err := c.BindJSON(&taxRate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if taxRate.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: taxRate.Name})
+		}
+		if taxRate.Rate != nil {
+			if *taxRate.Rate < 0 || *taxRate.Rate > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "rate must be between 0 and 100"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "rate", Value: taxRate.Rate})
+		}
+		if taxRate.Inclusive != nil {
+			updateObj = append(updateObj, bson.E{Key: "inclusive", Value: taxRate.Inclusive})
+		}
+		if taxRate.Categories != nil {
+			updateObj = append(updateObj, bson.E{Key: "categories", Value: taxRate.Categories})
+		}
+		if taxRate.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: taxRate.Active})
+		}
+
+		taxRate.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: taxRate.UpdatedAt})
+
+		result, err := taxRateCollection.UpdateOne(
+			ctx,
+			bson.M{"tax_rate_id": taxRateID},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "tax rate update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Tax rate not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// checkTaxRateIDs reports the first of the given ids that is not a known tax rate.
+func checkTaxRateIDs(ctx context.Context, taxRateIDs []string) (string, error) {
+	for _, taxRateID := range taxRateIDs {
+		count, err := taxRateCollection.CountDocuments(ctx, bson.M{"tax_rate_id": taxRateID})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return taxRateID, nil
+		}
+	}
+	return "", nil
+}
+
+// taxRatesForLine picks the rates that apply to an order line: the rates set
+// on the food itself, or otherwise the rates assigned to its menu category.
+func taxRatesForLine(line OrderLine, taxRates []models.TaxRate) []models.TaxRate {
+	var rates []models.TaxRate
+	if len(line.TaxRateIDs) > 0 {
+		for _, taxRate := range taxRates {
+			for _, taxRateID := range line.TaxRateIDs {
+				if taxRate.TaxRateID == taxRateID {
+					rates = append(rates, taxRate)
+				}
+			}
+		}
+		return rates
+	}
+	for _, taxRate := range taxRates {
+		for _, category := range taxRate.Categories {
+			if line.Category != "" && strings.EqualFold(category, line.Category) {
+				rates = append(rates, taxRate)
+				break
+			}
+		}
+	}
+	return rates
+}
+
+func activeTaxRates(ctx context.Context) ([]models.TaxRate, error) {
+	taxRates := []models.TaxRate{}
+	result, err := taxRateCollection.Find(ctx, bson.M{"active": bson.M{"$ne": false}})
+	if err != nil {
+		return taxRates, err
+	}
+	err = result.All(ctx, &taxRates)
+	return taxRates, err
+}
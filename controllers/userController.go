@@ -4,7 +4,6 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"strconv"
 	"time"
 
 	"atm1504.in/rms/database"
@@ -19,56 +18,39 @@ import (
 
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
+var userListSpec = listSpec{
+	filters: []listFilter{
+		{param: "role", field: "role", upper: true},
+	},
+	dateField:    "created_at",
+	searchFields: []string{"first_name", "last_name", "email", "phone"},
+	sorts: map[string]string{
+		"first_name": "first_name",
+		"last_name":  "last_name",
+		"email":      "email",
+		"created_at": "created_at",
+	},
+	defaultSort: "created_at",
+}
+
 func GetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		recordPerPage, err := strconv.Atoi(c.DefaultQuery("recordPerPage", "10"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
-		}
-
-		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-		if err != nil || page < 1 {
-			page = 1
-		}
-
-		startIndex := (page - 1) * recordPerPage
-
-		matchStage := bson.D{{Key: "$match", Value: bson.D{}}}
-		groupStage := bson.D{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: nil},
-			{Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}},
-			{Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
-		}}}
-		projectStage := bson.D{
-			{Key: "$project", Value: bson.D{
-				{Key: "_id", Value: 0},
-				{Key: "total_count", Value: 1},
-				{Key: "user_items", Value: bson.D{{Key: "$slice", Value: []interface{}{"$data", startIndex, recordPerPage}}}},
-			}},
+		query, ok := listRequest(c, ctx, userListSpec)
+		if !ok {
+			return
 		}
-
-		result, err := userCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, projectStage})
-		defer cancel()
+		// credentials never leave the server
+		projection := bson.M{"password": 0, "pin": 0, "token": 0, "refresh_token": 0}
+		allUsers := []models.User{}
+		page, err := findPage(ctx, userCollection, query, &allUsers, projection)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing menus"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing users"})
 			return
 		}
-
-		var allUsers []bson.M
-		if err = result.All(ctx, &allUsers); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while processing menus"})
-			log.Fatal(err)
-		}
-
-		// Assuming you want to return the list of menus directly
-		if len(allUsers) > 0 {
-			c.JSON(http.StatusOK, allUsers[0])
-		} else {
-			c.JSON(http.StatusOK, []interface{}{}) // Return an empty array if no menus
-		}
+		c.JSON(http.StatusOK, pageResponse("user_items", allUsers, query, page))
 	}
 }
 
@@ -100,14 +82,18 @@ func SignUp() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
+		var body struct {
+			models.User
+			Pin *string `json:"pin" validate:"omitempty,numeric,min=4,max=8"`
+		}
+		if err := c.BindJSON(&body); err != nil {
 			defer cancel()
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		user := body.User
 
-		validationErr := validate.Struct(user)
+		validationErr := validate.Struct(body)
 		if validationErr != nil {
 			defer cancel()
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
@@ -143,6 +129,23 @@ func SignUp() gin.HandlerFunc {
 		password := HashPassword(*user.Password)
 		user.Password = &password
 
+		if body.Pin != nil {
+			pin := HashPassword(*body.Pin)
+			user.Pin = &pin
+		}
+
+		userCount, err := userCollection.CountDocuments(ctx, bson.M{})
+		if err != nil {
+			defer cancel()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		role := "STAFF"
+		if userCount == 0 {
+			role = "ADMIN"
+		}
+		user.Role = &role
+
 		user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
@@ -203,6 +206,49 @@ func Login() gin.HandlerFunc {
 	}
 }
 
+func UpdateUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		userID := c.Param("user_id")
+
+		var body struct {
+			Role *string `json:"role" validate:"required,eq=ADMIN|eq=MANAGER|eq=STAFF"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		caller, err := helper.UserByID(ctx, c.GetString("uid"))
+		if err != nil || caller.Role == nil || *caller.Role != "ADMIN" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only an admin can change user roles"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "role", Value: body.Role},
+				{Key: "updated_at", Value: updatedAt},
+			}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user role update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
 func HashPassword(password string) string {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
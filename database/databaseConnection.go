@@ -12,12 +12,16 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// DBinstance creates the client without reaching the server, so packages
+// using it can be loaded, and tested, without a database. The server checks
+// the connection with Ping when it starts.
 func DBinstance() *mongo.Client {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+	// main insists on the .env file; here the environment may hold it all
+	_ = godotenv.Load()
 	MongoDbURI := os.Getenv("MONGO_DB_URI")
+	if MongoDbURI == "" {
+		MongoDbURI = "mongodb://localhost:27017"
+	}
 	fmt.Println("URL is: " + MongoDbURI)
 
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
@@ -29,14 +33,17 @@ func DBinstance() *mongo.Client {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Send a ping to confirm a successful connection
+	return client
+}
+
+// Ping sends a ping to confirm a successful connection.
+func Ping(ctx context.Context) error {
 	var result bson.M
-	if err := client.Database("admin").RunCommand(context.TODO(), bson.D{{Key: "ping", Value: 1}}).Decode(&result); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+	if err := Client.Database("admin").RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Decode(&result); err != nil {
+		return err
 	}
 	fmt.Println("Pinged your deployment. You successfully connected to MongoDB!")
-
-	return client
+	return nil
 }
 
 var Client *mongo.Client = DBinstance()
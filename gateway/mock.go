@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// SignatureHeader carries the signature of the mock provider's webhooks.
+const SignatureHeader = "X-Mock-Signature"
+
+// MockProvider is a local stand-in for a card gateway. The card token picks
+// the outcome:
+//
+//	tok_decline, tok_insufficient_funds  declined straight away
+//	tok_async                            pending, authorized later by webhook
+//	tok_async_decline                    pending, declined later by webhook
+//	anything else                        authorized
+//
+// Webhooks are signed with MOCK_WEBHOOK_SECRET and posted to
+// MOCK_WEBHOOK_URL (by default this server's /webhooks/payments/mock) after
+// MOCK_WEBHOOK_DELAY.
+type MockProvider struct {
+	mu           sync.Mutex
+	transactions map[string]*Transaction
+	results      map[string]Transaction
+	sequence     int
+
+	// Deliver sends a signed webhook. It can be replaced to catch webhooks
+	// without going over HTTP.
+	Deliver func(payload []byte, signature string) error
+}
+
+func NewMockProvider() *MockProvider {
+	provider := &MockProvider{
+		transactions: map[string]*Transaction{},
+		results:      map[string]Transaction{},
+	}
+	provider.Deliver = provider.post
+	return provider
+}
+
+func (m *MockProvider) Name() string {
+	return "mock"
+}
+
+func (m *MockProvider) Authorize(ctx context.Context, request AuthorizeRequest) (Transaction, error) {
+	return m.idempotent("authorize", request.IdempotencyKey, func() (Transaction, error) {
+		m.sequence++
+		transaction := &Transaction{ID: fmt.Sprintf("mock_txn_%06d", m.sequence), AmountCents: request.AmountCents}
+		switch request.CardToken {
+		case "tok_decline":
+			transaction.Status, transaction.DeclineReason = StatusDeclined, "card_declined"
+		case "tok_insufficient_funds":
+			transaction.Status, transaction.DeclineReason = StatusDeclined, "insufficient_funds"
+		case "tok_async":
+			transaction.Status = StatusPending
+			m.later(transaction.ID, StatusAuthorized, "")
+		case "tok_async_decline":
+			transaction.Status = StatusPending
+			m.later(transaction.ID, StatusDeclined, "card_declined")
+		default:
+			transaction.Status = StatusAuthorized
+		}
+		m.transactions[transaction.ID] = transaction
+		return *transaction, nil
+	})
+}
+
+func (m *MockProvider) Capture(ctx context.Context, transactionID string, amountCents int64, idempotencyKey string) (Transaction, error) {
+	return m.idempotent("capture", idempotencyKey, func() (Transaction, error) {
+		transaction, ok := m.transactions[transactionID]
+		if !ok {
+			return Transaction{}, ErrUnknownTransaction
+		}
+		if transaction.Status != StatusAuthorized || amountCents > transaction.AmountCents {
+			return *transaction, ErrInvalidState
+		}
+		transaction.Status = StatusCaptured
+		transaction.CapturedCents = amountCents
+		return *transaction, nil
+	})
+}
+
+func (m *MockProvider) Void(ctx context.Context, transactionID string, idempotencyKey string) (Transaction, error) {
+	return m.idempotent("void", idempotencyKey, func() (Transaction, error) {
+		transaction, ok := m.transactions[transactionID]
+		if !ok {
+			return Transaction{}, ErrUnknownTransaction
+		}
+		if transaction.Status != StatusAuthorized && transaction.Status != StatusPending {
+			return *transaction, ErrInvalidState
+		}
+		transaction.Status = StatusVoided
+		return *transaction, nil
+	})
+}
+
+func (m *MockProvider) Refund(ctx context.Context, transactionID string, amountCents int64, idempotencyKey string) (Transaction, error) {
+	return m.idempotent("refund", idempotencyKey, func() (Transaction, error) {
+		transaction, ok := m.transactions[transactionID]
+		if !ok {
+			return Transaction{}, ErrUnknownTransaction
+		}
+		if transaction.Status != StatusCaptured || transaction.RefundedCents+amountCents > transaction.CapturedCents {
+			return *transaction, ErrInvalidState
+		}
+		transaction.RefundedCents += amountCents
+		return *transaction, nil
+	})
+}
+
+func (m *MockProvider) VerifyWebhook(payload []byte, header http.Header) (WebhookEvent, error) {
+	var event WebhookEvent
+	expected, err := hex.DecodeString(header.Get(SignatureHeader))
+	if err != nil || !hmac.Equal(expected, m.sign(payload)) {
+		return event, ErrInvalidSignature
+	}
+	err = json.Unmarshal(payload, &event)
+	return event, err
+}
+
+// idempotent runs an operation once per key. A reused key gets the result of
+// the first call; an empty key always runs the operation.
+func (m *MockProvider) idempotent(operation string, key string, run func() (Transaction, error)) (Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key != "" {
+		if result, ok := m.results[operation+":"+key]; ok {
+			return result, nil
+		}
+	}
+	transaction, err := run()
+	if err == nil && key != "" {
+		m.results[operation+":"+key] = transaction
+	}
+	return transaction, err
+}
+
+// later settles a pending transaction and sends its webhook after the
+// configured delay.
+func (m *MockProvider) later(transactionID string, status string, declineReason string) {
+	delay, err := time.ParseDuration(os.Getenv("MOCK_WEBHOOK_DELAY"))
+	if err != nil {
+		delay = 2 * time.Second
+	}
+	go func() {
+		time.Sleep(delay)
+		m.mu.Lock()
+		transaction, ok := m.transactions[transactionID]
+		if !ok || transaction.Status != StatusPending {
+			m.mu.Unlock()
+			return
+		}
+		transaction.Status, transaction.DeclineReason = status, declineReason
+		m.sequence++
+		event := WebhookEvent{
+			ID:            fmt.Sprintf("mock_evt_%06d", m.sequence),
+			Type:          "transaction.updated",
+			TransactionID: transactionID,
+			Status:        status,
+			DeclineReason: declineReason,
+		}
+		m.mu.Unlock()
+
+		payload, _ := json.Marshal(event)
+		if err := m.Deliver(payload, hex.EncodeToString(m.sign(payload))); err != nil {
+			log.Printf("gateway: mock webhook %s failed: %v", event.ID, err)
+		}
+	}()
+}
+
+// CheckConfig requires MOCK_WEBHOOK_SECRET outside dev mode, so webhooks
+// can't be forged with the built-in secret.
+func (m *MockProvider) CheckConfig() error {
+	if os.Getenv("MOCK_WEBHOOK_SECRET") == "" && !DevMode() {
+		return errors.New("MOCK_WEBHOOK_SECRET is not set")
+	}
+	return nil
+}
+
+func (m *MockProvider) sign(payload []byte) []byte {
+	secret := os.Getenv("MOCK_WEBHOOK_SECRET")
+	if secret == "" && DevMode() {
+		secret = "mock-webhook-secret"
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (m *MockProvider) post(payload []byte, signature string) error {
+	url := os.Getenv("MOCK_WEBHOOK_URL")
+	if url == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		url = "http://localhost:" + port + "/webhooks/payments/mock"
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, signature)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint answered %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Transaction statuses reported by providers.
+const (
+	StatusPending    = "PENDING"
+	StatusAuthorized = "AUTHORIZED"
+	StatusCaptured   = "CAPTURED"
+	StatusVoided     = "VOIDED"
+	StatusDeclined   = "DECLINED"
+)
+
+var (
+	ErrUnknownTransaction = errors.New("unknown transaction")
+	ErrInvalidState       = errors.New("transaction is not in a state that allows this")
+	ErrInvalidSignature   = errors.New("webhook signature is invalid")
+	ErrUnknownProvider    = errors.New("unknown payment provider")
+	ErrNoProvider         = errors.New("PAYMENT_PROVIDER is not set")
+)
+
+// AuthorizeRequest asks a provider to reserve an amount on a card. The
+// idempotency key makes a retried request return the first result instead of
+// charging again.
+type AuthorizeRequest struct {
+	AmountCents    int64
+	Currency       string
+	CardToken      string
+	Reference      string
+	IdempotencyKey string
+}
+
+type Transaction struct {
+	ID            string
+	Status        string
+	AmountCents   int64
+	CapturedCents int64
+	RefundedCents int64
+	DeclineReason string
+}
+
+// WebhookEvent is a verified notification from a provider about a change to
+// one of its transactions.
+type WebhookEvent struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	TransactionID string `json:"transaction_id"`
+	Status        string `json:"status"`
+	DeclineReason string `json:"decline_reason,omitempty"`
+}
+
+// Provider is a card payment gateway. Every call that moves money takes an
+// idempotency key, and providers must return the original result when a key
+// is reused.
+type Provider interface {
+	Name() string
+	Authorize(ctx context.Context, request AuthorizeRequest) (Transaction, error)
+	Capture(ctx context.Context, transactionID string, amountCents int64, idempotencyKey string) (Transaction, error)
+	Void(ctx context.Context, transactionID string, idempotencyKey string) (Transaction, error)
+	Refund(ctx context.Context, transactionID string, amountCents int64, idempotencyKey string) (Transaction, error)
+	VerifyWebhook(payload []byte, header http.Header) (WebhookEvent, error)
+}
+
+// configChecker is implemented by providers that need settings, such as a
+// webhook secret, to be safe to take payments with.
+type configChecker interface {
+	CheckConfig() error
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+// Register makes a provider available under its name.
+func Register(provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[provider.Name()] = provider
+}
+
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// Default returns the provider named by PAYMENT_PROVIDER. Only in dev mode
+// is the mock provider used when it is not set.
+func Default() (Provider, error) {
+	name := os.Getenv("PAYMENT_PROVIDER")
+	if name == "" {
+		if !DevMode() {
+			return nil, ErrNoProvider
+		}
+		name = "mock"
+	}
+	return Get(name)
+}
+
+// DevMode reports whether PAYMENT_DEV_MODE is set, allowing the mock
+// provider and its built-in webhook secret to be used without configuring
+// them.
+func DevMode() bool {
+	dev, _ := strconv.ParseBool(os.Getenv("PAYMENT_DEV_MODE"))
+	return dev
+}
+
+// CheckConfig makes sure the default provider is configured well enough to
+// take payments. It is checked at startup so a missing setting fails there
+// rather than approving or rejecting payments later.
+func CheckConfig() error {
+	provider, err := Default()
+	if err != nil {
+		return err
+	}
+	if checker, ok := provider.(configChecker); ok {
+		return checker.CheckConfig()
+	}
+	return nil
+}
+
+func init() {
+	Register(NewMockProvider())
+}
@@ -0,0 +1,35 @@
+package helper
+
+import (
+	"strings"
+
+	"atm1504.in/rms/models"
+)
+
+// AllergenConflicts lists the declared allergies that a food, with the
+// modifiers ordered with it, contains.
+func AllergenConflicts(declared []string, food models.Food, modifiers []string) []string {
+	contains := append([]string{}, food.Allergens...)
+	for _, name := range modifiers {
+		if modifier, ok := FoodModifier(food, name); ok {
+			contains = append(contains, modifier.Allergens...)
+		}
+	}
+	var conflicts []string
+	for _, allergy := range declared {
+		if containsFold(contains, allergy) && !containsFold(conflicts, allergy) {
+			conflicts = append(conflicts, allergy)
+		}
+	}
+	return conflicts
+}
+
+// FoodModifier finds one of a food's modifiers by name, ignoring case.
+func FoodModifier(food models.Food, name string) (models.FoodModifier, bool) {
+	for _, modifier := range food.Modifiers {
+		if strings.EqualFold(modifier.Name, name) {
+			return modifier, true
+		}
+	}
+	return models.FoodModifier{}, false
+}
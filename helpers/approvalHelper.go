@@ -0,0 +1,53 @@
+package helper
+
+import (
+	"context"
+	"time"
+
+	"atm1504.in/rms/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func IsManager(user models.User) bool {
+	return user.Role != nil && (*user.Role == "MANAGER" || *user.Role == "ADMIN")
+}
+
+func UserByID(ctx context.Context, userID string) (user models.User, err error) {
+	err = userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	return user, err
+}
+
+// VerifyManagerApproval checks a manager sign-off given either as the
+// manager's own token or as their user id and PIN, and returns the approving
+// manager.
+func VerifyManagerApproval(approval models.ManagerApproval) (manager models.User, msg string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	managerID := approval.ManagerID
+	if approval.ManagerToken != "" {
+		claims, tokenMsg := ValidateToken(approval.ManagerToken)
+		if tokenMsg != "" {
+			return manager, "manager token: " + tokenMsg
+		}
+		managerID = claims.UID
+	} else if approval.ManagerID == "" || approval.Pin == "" {
+		return manager, "manager approval requires a manager_token or a manager_id and pin"
+	}
+
+	manager, err := UserByID(ctx, managerID)
+	if err != nil {
+		return manager, "approving manager was not found"
+	}
+	if !IsManager(manager) {
+		return manager, "approving user is not a manager"
+	}
+
+	if approval.ManagerToken == "" {
+		if manager.Pin == nil || bcrypt.CompareHashAndPassword([]byte(*manager.Pin), []byte(approval.Pin)) != nil {
+			return manager, "manager pin is incorrect"
+		}
+	}
+	return manager, ""
+}
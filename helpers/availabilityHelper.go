@@ -0,0 +1,49 @@
+package helper
+
+import (
+	"time"
+
+	"atm1504.in/rms/models"
+)
+
+// MenuAvailableAt reports whether a menu can be ordered from at a moment,
+// reading its schedules in the restaurant's timezone. Menus never published
+// are not available.
+func MenuAvailableAt(menu models.Menu, at time.Time, location *time.Location) bool {
+	if menu.Status != nil && *menu.Status == "DRAFT" {
+		return false
+	}
+	if menu.StartDate != nil && at.Before(*menu.StartDate) {
+		return false
+	}
+	if menu.EndDate != nil && !at.Before(*menu.EndDate) {
+		return false
+	}
+	if len(menu.Schedules) == 0 {
+		return true
+	}
+	local := at.In(location)
+	for _, schedule := range menu.Schedules {
+		if scheduleCovers(schedule, local) {
+			return true
+		}
+	}
+	return false
+}
+
+func scheduleCovers(schedule models.MenuSchedule, local time.Time) bool {
+	minute := local.Hour()*60 + local.Minute()
+	from, to := clockMinutes(schedule.StartTime), clockMinutes(schedule.EndTime)
+	today := weekdays[local.Weekday()]
+	if from < to {
+		return onDay(schedule.Days, today) && minute >= from && minute < to
+	}
+	// past midnight: the late part belongs to today's window, the early part
+	// to yesterday's
+	yesterday := weekdays[(local.Weekday()+6)%7]
+	return (onDay(schedule.Days, today) && minute >= from) || (onDay(schedule.Days, yesterday) && minute < to)
+}
+
+func onDay(days []string, day string) bool {
+	return len(days) == 0 || containsFold(days, day)
+}
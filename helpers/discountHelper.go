@@ -0,0 +1,303 @@
+package helper
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+)
+
+// DiscountableLine is a charged order line as seen by the promotion rules.
+type DiscountableLine struct {
+	OrderItemID string
+	FoodID      string
+	Category    string
+	AmountCents int64
+	OrderedAt   time.Time
+}
+
+type DiscountLine struct {
+	PromotionID string      `bson:"promotion_id" json:"promotion_id"`
+	Name        string      `bson:"name" json:"name"`
+	CouponCode  string      `bson:"coupon_code,omitempty" json:"coupon_code,omitempty"`
+	Amount      money.Money `bson:"amount" json:"amount"`
+	AmountCents int64       `bson:"-" json:"-"`
+	// LineCents is what the promotion takes off each order item.
+	LineCents map[string]int64 `bson:"-" json:"-"`
+}
+
+type DiscountResult struct {
+	Lines         []DiscountLine
+	LineDiscounts map[string]int64
+	TotalCents    int64
+}
+
+var weekdays = [...]string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// PromotionActiveAt reports whether a promotion runs at the given moment:
+// it must be active, inside its date range, on one of its days and inside
+// its daily time window. Windows such as 22:00-02:00 run past midnight.
+func PromotionActiveAt(promotion models.Promotion, at time.Time) bool {
+	if promotion.Active != nil && !*promotion.Active {
+		return false
+	}
+	if promotion.StartDate != nil && at.Before(*promotion.StartDate) {
+		return false
+	}
+	if promotion.EndDate != nil && at.After(*promotion.EndDate) {
+		return false
+	}
+	if len(promotion.DaysOfWeek) > 0 && !containsFold(promotion.DaysOfWeek, weekdays[at.Weekday()]) {
+		return false
+	}
+	if promotion.StartTime != nil || promotion.EndTime != nil {
+		minute := at.Hour()*60 + at.Minute()
+		start, end := 0, 24*60
+		if promotion.StartTime != nil {
+			start = clockMinutes(*promotion.StartTime)
+		}
+		if promotion.EndTime != nil {
+			end = clockMinutes(*promotion.EndTime)
+		}
+		if start <= end && (minute < start || minute >= end) {
+			return false
+		}
+		if start > end && minute < start && minute >= end {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyPromotions prices the promotions that apply to an order. Line scoped
+// promotions (item, category, buy-x-get-y) are applied before order scoped
+// ones, so an order discount is taken off what is left. Stackable
+// promotions are combined; a non-stackable promotion is only used on its
+// own, and only when it beats the combined stackable discount.
+func ApplyPromotions(promotions []models.Promotion, lines []DiscountableLine, orderedAt time.Time, couponCodes []string) DiscountResult {
+	var candidates []models.Promotion
+	for _, promotion := range promotions {
+		if promotion.CouponCode != nil && !containsFold(couponCodes, *promotion.CouponCode) {
+			continue
+		}
+		candidates = append(candidates, promotion)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iOrder, jOrder := *candidates[i].Scope == "ORDER", *candidates[j].Scope == "ORDER"
+		if iOrder != jOrder {
+			return !iOrder
+		}
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority > candidates[j].Priority
+		}
+		return candidates[i].PromotionID < candidates[j].PromotionID
+	})
+
+	stacked := newDiscountResult()
+	remaining := lineAmounts(lines)
+	for _, promotion := range candidates {
+		if promotion.Stackable == nil || !*promotion.Stackable {
+			continue
+		}
+		stacked.add(promotion, promotionDiscounts(promotion, lines, remaining, orderedAt), remaining)
+	}
+
+	best := stacked
+	for _, promotion := range candidates {
+		if promotion.Stackable != nil && *promotion.Stackable {
+			continue
+		}
+		single := newDiscountResult()
+		singleRemaining := lineAmounts(lines)
+		single.add(promotion, promotionDiscounts(promotion, lines, singleRemaining, orderedAt), singleRemaining)
+		if single.TotalCents > best.TotalCents {
+			best = single
+		}
+	}
+	return best
+}
+
+func newDiscountResult() DiscountResult {
+	return DiscountResult{Lines: []DiscountLine{}, LineDiscounts: map[string]int64{}}
+}
+
+func (result *DiscountResult) add(promotion models.Promotion, discounts map[string]int64, remaining map[string]int64) {
+	var total int64
+	lineCents := map[string]int64{}
+	for orderItemID, discount := range discounts {
+		if discount != 0 {
+			lineCents[orderItemID] = discount
+		}
+		result.LineDiscounts[orderItemID] += discount
+		remaining[orderItemID] -= discount
+		total += discount
+	}
+	if total == 0 {
+		return
+	}
+	line := DiscountLine{PromotionID: promotion.PromotionID, Amount: money.Of(total), AmountCents: total, LineCents: lineCents}
+	if promotion.Name != nil {
+		line.Name = *promotion.Name
+	}
+	if promotion.CouponCode != nil {
+		line.CouponCode = *promotion.CouponCode
+	}
+	result.Lines = append(result.Lines, line)
+	result.TotalCents += total
+}
+
+// promotionDiscounts works out what one promotion takes off each line, given
+// what is still left to pay on every line.
+func promotionDiscounts(promotion models.Promotion, lines []DiscountableLine, remaining map[string]int64, orderedAt time.Time) map[string]int64 {
+	discounts := map[string]int64{}
+	orderScope := *promotion.Scope == "ORDER"
+	if orderScope && *promotion.DiscountType != "BUY_X_GET_Y" && !PromotionActiveAt(promotion, orderedAt) {
+		return discounts
+	}
+
+	var orderTotal int64
+	for _, line := range lines {
+		orderTotal += remaining[line.OrderItemID]
+	}
+	if promotion.MinOrderAmount != nil && orderTotal < promotion.MinOrderAmount.Cents {
+		return discounts
+	}
+
+	var eligible []DiscountableLine
+	for _, line := range lines {
+		if remaining[line.OrderItemID] <= 0 || !promotionCoversLine(promotion, line) {
+			continue
+		}
+		if (!orderScope || *promotion.DiscountType == "BUY_X_GET_Y") && !PromotionActiveAt(promotion, line.OrderedAt) {
+			continue
+		}
+		eligible = append(eligible, line)
+	}
+	if len(eligible) == 0 {
+		return discounts
+	}
+
+	var value float64
+	if promotion.Value != nil {
+		value = *promotion.Value
+	}
+
+	switch *promotion.DiscountType {
+	case "PERCENTAGE":
+		if orderScope {
+			var subtotal int64
+			for _, line := range eligible {
+				subtotal += remaining[line.OrderItemID]
+			}
+			allocate(discounts, eligible, remaining, percentOf(subtotal, value))
+			break
+		}
+		for _, line := range eligible {
+			discounts[line.OrderItemID] = percentOf(remaining[line.OrderItemID], value)
+		}
+	case "FIXED":
+		if orderScope {
+			var subtotal int64
+			for _, line := range eligible {
+				subtotal += remaining[line.OrderItemID]
+			}
+			allocate(discounts, eligible, remaining, minCents(fixedCents(promotion), subtotal))
+			break
+		}
+		for _, line := range eligible {
+			discounts[line.OrderItemID] = minCents(fixedCents(promotion), remaining[line.OrderItemID])
+		}
+	case "BUY_X_GET_Y":
+		if promotion.BuyQuantity == nil || promotion.GetQuantity == nil {
+			return discounts
+		}
+		percent := 100.0
+		if promotion.Value != nil && *promotion.Value > 0 {
+			percent = *promotion.Value
+		}
+		// the cheapest items of every group of buy+get items are the free ones
+		sort.SliceStable(eligible, func(i, j int) bool {
+			return remaining[eligible[i].OrderItemID] > remaining[eligible[j].OrderItemID]
+		})
+		group := *promotion.BuyQuantity + *promotion.GetQuantity
+		for i, line := range eligible {
+			if i%group >= *promotion.BuyQuantity && len(eligible)-(i-i%group) >= group {
+				discounts[line.OrderItemID] = percentOf(remaining[line.OrderItemID], percent)
+			}
+		}
+	}
+	return discounts
+}
+
+func promotionCoversLine(promotion models.Promotion, line DiscountableLine) bool {
+	switch *promotion.Scope {
+	case "ITEM":
+		return containsFold(promotion.FoodIDs, line.FoodID)
+	case "CATEGORY":
+		return line.Category != "" && containsFold(promotion.Categories, line.Category)
+	}
+	if len(promotion.FoodIDs) > 0 || len(promotion.Categories) > 0 {
+		return containsFold(promotion.FoodIDs, line.FoodID) || (line.Category != "" && containsFold(promotion.Categories, line.Category))
+	}
+	return true
+}
+
+// allocate spreads an order level discount over the eligible lines in
+// proportion to what is left on each of them.
+func allocate(discounts map[string]int64, eligible []DiscountableLine, remaining map[string]int64, total int64) {
+	weights := make([]int64, len(eligible))
+	for i, line := range eligible {
+		weights[i] = remaining[line.OrderItemID]
+	}
+	for i, part := range AllocateCents(total, weights) {
+		discounts[eligible[i].OrderItemID] += part
+	}
+}
+
+// fixedCents is the amount a FIXED promotion takes off. Promotions are only
+// saved with amounts in the base currency; one in another currency takes
+// nothing off rather than the wrong amount.
+func fixedCents(promotion models.Promotion) int64 {
+	if promotion.Amount == nil || !promotion.Amount.In(money.DefaultCurrency()) {
+		return 0
+	}
+	return promotion.Amount.Cents
+}
+
+func percentOf(cents int64, percent float64) int64 {
+	return minCents(PercentOf(cents, percent), cents)
+}
+
+func lineAmounts(lines []DiscountableLine) map[string]int64 {
+	amounts := map[string]int64{}
+	for _, line := range lines {
+		amounts[line.OrderItemID] = line.AmountCents
+	}
+	return amounts
+}
+
+func minCents(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func clockMinutes(clock string) int {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0
+	}
+	return t.Hour()*60 + t.Minute()
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
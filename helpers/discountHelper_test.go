@@ -0,0 +1,195 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+)
+
+func promotion(id string, discountType string, scope string) models.Promotion {
+	return models.Promotion{PromotionID: id, DiscountType: &discountType, Scope: &scope}
+}
+
+func percentage(id string, scope string, value float64) models.Promotion {
+	p := promotion(id, "PERCENTAGE", scope)
+	p.Value = &value
+	return p
+}
+
+func fixed(id string, scope string, amount money.Money) models.Promotion {
+	p := promotion(id, "FIXED", scope)
+	p.Amount = &amount
+	return p
+}
+
+func stackable(p models.Promotion) models.Promotion {
+	yes := true
+	p.Stackable = &yes
+	return p
+}
+
+func TestApplyPromotions(t *testing.T) {
+	money.SetDefaultCurrency("USD")
+	defer money.SetDefaultCurrency("")
+
+	orderedAt := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	line := func(id string, foodID string, category string, cents int64) DiscountableLine {
+		return DiscountableLine{OrderItemID: id, FoodID: foodID, Category: category, AmountCents: cents, OrderedAt: orderedAt}
+	}
+	meal := []DiscountableLine{line("a", "steak", "Mains", 1000), line("b", "soda", "Drinks", 500)}
+
+	itemPercent := percentage("item", "ITEM", 10)
+	itemPercent.FoodIDs = []string{"steak"}
+	odd := percentage("odd", "ITEM", 12.5)
+	odd.FoodIDs = []string{"soup"}
+	drinks := fixed("drinks", "CATEGORY", money.Of(2000))
+	drinks.Categories = []string{"drinks"}
+	minimum := fixed("min", "ORDER", money.Of(300))
+	minimum.MinOrderAmount = &money.Money{Cents: 2000, Currency: "USD"}
+	coupon := percentage("coupon", "ORDER", 20)
+	code := "SAVE20"
+	coupon.CouponCode = &code
+	buy, get := 1, 1
+	bogo := promotion("bogo", "BUY_X_GET_Y", "CATEGORY")
+	bogo.Categories = []string{"Mains"}
+	bogo.BuyQuantity, bogo.GetQuantity = &buy, &get
+
+	tests := []struct {
+		name        string
+		promotions  []models.Promotion
+		lines       []DiscountableLine
+		couponCodes []string
+		want        map[string]int64
+	}{
+		{
+			name:       "percentage on an item",
+			promotions: []models.Promotion{itemPercent},
+			lines:      meal,
+			want:       map[string]int64{"a": 100},
+		},
+		{
+			name:       "percentage rounds half away from zero",
+			promotions: []models.Promotion{odd},
+			lines:      []DiscountableLine{line("s", "soup", "Starters", 999)},
+			want:       map[string]int64{"s": 125},
+		},
+		{
+			name:       "fixed on an order is spread by line amounts",
+			promotions: []models.Promotion{fixed("order", "ORDER", money.Of(300))},
+			lines:      meal,
+			want:       map[string]int64{"a": 200, "b": 100},
+		},
+		{
+			name:       "fixed on an order hands out the remainder",
+			promotions: []models.Promotion{fixed("order", "ORDER", money.Of(100))},
+			lines:      []DiscountableLine{line("x", "tea", "Drinks", 100), line("y", "tea", "Drinks", 100), line("z", "tea", "Drinks", 100)},
+			want:       map[string]int64{"x": 34, "y": 33, "z": 33},
+		},
+		{
+			name:       "fixed is capped at the line",
+			promotions: []models.Promotion{drinks},
+			lines:      meal,
+			want:       map[string]int64{"b": 500},
+		},
+		{
+			name:       "fixed in another currency takes nothing off",
+			promotions: []models.Promotion{fixed("euro", "ORDER", money.New(300, "EUR"))},
+			lines:      meal,
+			want:       map[string]int64{},
+		},
+		{
+			name:       "minimum order amount not reached",
+			promotions: []models.Promotion{minimum},
+			lines:      meal,
+			want:       map[string]int64{},
+		},
+		{
+			name:       "stackable promotions add up",
+			promotions: []models.Promotion{stackable(itemPercent), stackable(fixed("order", "ORDER", money.Of(140)))},
+			lines:      meal,
+			// the order discount is spread over the 900 and 500 left
+			want: map[string]int64{"a": 190, "b": 50},
+		},
+		{
+			name:       "non-stackable promotion beats a smaller stack",
+			promotions: []models.Promotion{stackable(percentage("five", "ORDER", 5)), fixed("order", "ORDER", money.Of(150))},
+			lines:      meal,
+			want:       map[string]int64{"a": 100, "b": 50},
+		},
+		{
+			name:       "coupon promotion needs its code",
+			promotions: []models.Promotion{coupon},
+			lines:      meal,
+			want:       map[string]int64{},
+		},
+		{
+			name:        "coupon code is matched ignoring case",
+			promotions:  []models.Promotion{coupon},
+			lines:       meal,
+			couponCodes: []string{"save20"},
+			want:        map[string]int64{"a": 200, "b": 100},
+		},
+		{
+			name:       "buy one get one frees the cheaper of each pair",
+			promotions: []models.Promotion{bogo},
+			lines:      []DiscountableLine{line("m1", "steak", "Mains", 1000), line("m2", "fish", "Mains", 800), line("m3", "pasta", "Mains", 600)},
+			want:       map[string]int64{"m2": 800},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ApplyPromotions(tt.promotions, tt.lines, orderedAt, tt.couponCodes)
+			got := map[string]int64{}
+			var total int64
+			for orderItemID, cents := range result.LineDiscounts {
+				if cents != 0 {
+					got[orderItemID] = cents
+				}
+				total += cents
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("line discounts = %v, want %v", got, tt.want)
+			}
+			if result.TotalCents != total {
+				t.Errorf("total = %d, lines add up to %d", result.TotalCents, total)
+			}
+		})
+	}
+}
+
+func TestPromotionActiveAt(t *testing.T) {
+	at := func(day int, hour int) time.Time {
+		// March 1 2026 is a Sunday
+		return time.Date(2026, 3, day, hour, 30, 0, 0, time.UTC)
+	}
+	late := promotion("late", "PERCENTAGE", "ORDER")
+	start, end := "22:00", "02:00"
+	late.StartTime, late.EndTime = &start, &end
+	weekend := promotion("weekend", "PERCENTAGE", "ORDER")
+	weekend.DaysOfWeek = []string{"sat", "SUN"}
+	inactive := promotion("off", "PERCENTAGE", "ORDER")
+	no := false
+	inactive.Active = &no
+
+	tests := []struct {
+		name      string
+		promotion models.Promotion
+		at        time.Time
+		want      bool
+	}{
+		{name: "before midnight", promotion: late, at: at(2, 23), want: true},
+		{name: "after midnight", promotion: late, at: at(3, 1), want: true},
+		{name: "outside the window", promotion: late, at: at(3, 12), want: false},
+		{name: "on the day", promotion: weekend, at: at(1, 12), want: true},
+		{name: "on another day", promotion: weekend, at: at(2, 12), want: false},
+		{name: "inactive", promotion: inactive, at: at(1, 12), want: false},
+	}
+	for _, tt := range tests {
+		if got := PromotionActiveAt(tt.promotion, tt.at); got != tt.want {
+			t.Errorf("%s: PromotionActiveAt = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var counterCollection *mongo.Collection = database.OpenCollection(database.Client, "counter")
+
+// DefaultInvoiceNumberFormat gives numbers like INV-2026-000123.
+const DefaultInvoiceNumberFormat = "{PREFIX}-{FY}-{SEQ:6}"
+
+var sequencePlaceholder = regexp.MustCompile(`\{SEQ(?::(\d+))?\}`)
+
+// InvoiceNumbering describes how invoice numbers are made. Each branch and
+// fiscal year is its own series, counted from 1. The format may use
+// {PREFIX}, {BRANCH}, {FY} (the year the fiscal year starts), {FY_END} (the
+// year it ends) and {SEQ} or {SEQ:n} for the sequence padded to n digits.
+type InvoiceNumbering struct {
+	Prefix               string
+	Format               string
+	Branch               string
+	FiscalYearStartMonth int
+}
+
+type InvoiceNumber struct {
+	Number   string
+	Series   string
+	Sequence int64
+}
+
+// ValidInvoiceNumberFormat reports whether a format has the sequence and
+// the fiscal year in it, without which numbers would repeat once the
+// sequence starts again.
+func ValidInvoiceNumberFormat(format string) bool {
+	return sequencePlaceholder.MatchString(format) && (strings.Contains(format, "{FY}") || strings.Contains(format, "{FY_END}"))
+}
+
+// FormatHasBranch reports whether a format tells the branches apart, which
+// numbers need once more than one branch counts invoices.
+func FormatHasBranch(format string) bool {
+	if format == "" {
+		format = DefaultInvoiceNumberFormat
+	}
+	return strings.Contains(format, "{BRANCH}")
+}
+
+// OtherBranchesNumbered reports whether any branch but this one has taken
+// invoice numbers.
+func OtherBranchesNumbered(ctx context.Context, branch string) (bool, error) {
+	count, err := counterCollection.CountDocuments(ctx, bson.M{
+		"_id": bson.M{"$regex": "/", "$not": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(branch+"/")}},
+	})
+	return count > 0, err
+}
+
+// FiscalYear returns the years in which the fiscal year containing t starts
+// and ends.
+func (n InvoiceNumbering) FiscalYear(t time.Time) (start int, end int) {
+	startMonth := n.FiscalYearStartMonth
+	if startMonth < 1 || startMonth > 12 {
+		startMonth = 1
+	}
+	start = t.Year()
+	if int(t.Month()) < startMonth {
+		start--
+	}
+	end = start
+	if startMonth > 1 {
+		end = start + 1
+	}
+	return start, end
+}
+
+// Series names the counter an invoice created at t is numbered from.
+func (n InvoiceNumbering) Series(t time.Time) string {
+	start, _ := n.FiscalYear(t)
+	return n.Branch + "/" + strconv.Itoa(start)
+}
+
+// Next takes the next number of the series for t. The counter is changed
+// atomically, so concurrent callers never get the same number, and numbers
+// are given out in the order they are taken.
+func (n InvoiceNumbering) Next(ctx context.Context, t time.Time) (InvoiceNumber, error) {
+	series := n.Series(t)
+
+	var counter struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	for attempt := 0; ; attempt++ {
+		err := counterCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": series},
+			bson.M{"$inc": bson.M{"sequence": int64(1)}},
+			opts,
+		).Decode(&counter)
+		// two first numbers of a series taken at once can both try to
+		// create the counter; the one that loses simply tries again
+		if mongo.IsDuplicateKeyError(err) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return InvoiceNumber{}, err
+		}
+		return n.number(t, series, counter.Sequence), nil
+	}
+}
+
+// ReleaseInvoiceNumbers hands back numbers that were taken but never stored
+// on an invoice. A number still the last of its series is taken back off the
+// counter; any other is recorded as skipped, so the gap it leaves is
+// accounted for rather than filled out of order. Numbers are released last
+// first, so a run of numbers taken together can all be taken back.
+func ReleaseInvoiceNumbers(ctx context.Context, numbers []InvoiceNumber) error {
+	for i := len(numbers) - 1; i >= 0; i-- {
+		number := numbers[i]
+		result, err := counterCollection.UpdateOne(ctx,
+			bson.M{"_id": number.Series, "sequence": number.Sequence},
+			bson.M{"$inc": bson.M{"sequence": int64(-1)}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 1 {
+			continue
+		}
+		_, err = counterCollection.UpdateOne(ctx,
+			bson.M{"_id": number.Series},
+			bson.M{"$addToSet": bson.M{"skipped": number.Sequence}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n InvoiceNumbering) number(t time.Time, series string, sequence int64) InvoiceNumber {
+	format := n.Format
+	if format == "" {
+		format = DefaultInvoiceNumberFormat
+	}
+	start, end := n.FiscalYear(t)
+	number := strings.NewReplacer(
+		"{PREFIX}", n.Prefix,
+		"{BRANCH}", n.Branch,
+		"{FY}", strconv.Itoa(start),
+		"{FY_END}", strconv.Itoa(end),
+	).Replace(format)
+	number = sequencePlaceholder.ReplaceAllStringFunc(number, func(placeholder string) string {
+		width, _ := strconv.Atoi(sequencePlaceholder.FindStringSubmatch(placeholder)[1])
+		return fmt.Sprintf("%0*d", width, sequence)
+	})
+	return InvoiceNumber{Number: number, Series: series, Sequence: sequence}
+}
@@ -0,0 +1,69 @@
+package helper
+
+import (
+	"math/big"
+	"sort"
+	"strconv"
+
+	"atm1504.in/rms/money"
+)
+
+// RatFromFloat converts a rate as stored in the database into an exact
+// rational, going through its shortest decimal form so 0.1 stays 1/10.
+func RatFromFloat(amount float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(amount, 'f', -1, 64))
+	return r
+}
+
+// PercentOf takes a percentage of an amount in minor units, rounded half
+// away from zero to whole minor units of whatever currency it is in.
+func PercentOf(cents int64, percent float64) int64 {
+	amount := new(big.Rat).Mul(big.NewRat(cents, 1), RatFromFloat(percent))
+	return money.Round(amount.Quo(amount, big.NewRat(100, 1)))
+}
+
+// AllocateCents splits total across the given weights in proportion to
+// them, handing leftover cents to the largest remainders so the parts always
+// add up to total exactly. A negative total is split the same way as its
+// absolute value.
+func AllocateCents(total int64, weights []int64) []int64 {
+	if total < 0 {
+		parts := AllocateCents(-total, weights)
+		for i := range parts {
+			parts[i] = -parts[i]
+		}
+		return parts
+	}
+
+	parts := make([]int64, len(weights))
+	var weightSum int64
+	for _, weight := range weights {
+		weightSum += weight
+	}
+	if weightSum == 0 {
+		return parts
+	}
+
+	remainders := make([]int64, len(weights))
+	var allocated int64
+	for i, weight := range weights {
+		product := new(big.Int).Mul(big.NewInt(total), big.NewInt(weight))
+		quotient, remainder := new(big.Int).QuoRem(product, big.NewInt(weightSum), new(big.Int))
+		parts[i] = quotient.Int64()
+		remainders[i] = remainder.Int64()
+		allocated += parts[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; allocated < total; i++ {
+		parts[order[i%len(order)]]++
+		allocated++
+	}
+	return parts
+}
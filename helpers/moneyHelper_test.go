@@ -0,0 +1,68 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		cents   int64
+		percent float64
+		want    int64
+	}{
+		{cents: 1000, percent: 10, want: 100},
+		{cents: 999, percent: 12.5, want: 125},
+		{cents: -999, percent: 12.5, want: -125},
+		{cents: 1, percent: 50, want: 1},
+		{cents: 333, percent: 0.1, want: 0},
+		{cents: 1999, percent: 8.875, want: 177},
+		{cents: 1000, percent: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := PercentOf(tt.cents, tt.percent); got != tt.want {
+			t.Errorf("PercentOf(%d, %v) = %d, want %d", tt.cents, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestAllocateCents(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int64
+		weights []int64
+		want    []int64
+	}{
+		{name: "exact", total: 7, weights: []int64{1, 2, 4}, want: []int64{1, 2, 4}},
+		{name: "remainder to the first of equal remainders", total: 100, weights: []int64{1, 1, 1}, want: []int64{34, 33, 33}},
+		{name: "remainder to the largest remainder", total: 10, weights: []int64{1, 2}, want: []int64{3, 7}},
+		{name: "single cent", total: 1, weights: []int64{1, 1, 2}, want: []int64{0, 0, 1}},
+		{name: "uneven weights", total: 300, weights: []int64{1000, 500}, want: []int64{200, 100}},
+		{name: "negative total", total: -100, weights: []int64{1, 1, 1}, want: []int64{-34, -33, -33}},
+		{name: "negative remainder", total: -10, weights: []int64{1, 2}, want: []int64{-3, -7}},
+		{name: "zero total", total: 0, weights: []int64{1, 2}, want: []int64{0, 0}},
+		{name: "zero weights", total: 5, weights: []int64{0, 0}, want: []int64{0, 0}},
+		{name: "no weights", total: 5, weights: []int64{}, want: []int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AllocateCents(tt.total, tt.weights)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("AllocateCents(%d, %v) = %v, want %v", tt.total, tt.weights, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateCentsAddsUp(t *testing.T) {
+	weights := []int64{333, 1, 0, 2499, 17, 17}
+	for _, total := range []int64{-1001, -1, 1, 2, 999, 1000, 123457} {
+		var sum int64
+		for _, part := range AllocateCents(total, weights) {
+			sum += part
+		}
+		if sum != total {
+			t.Errorf("AllocateCents(%d, %v) adds up to %d", total, weights, sum)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"math/big"
+	"sort"
+
+	"atm1504.in/rms/models"
+	"atm1504.in/rms/money"
+)
+
+// TaxableLine is an invoice line at its menu price together with the tax
+// rates that apply to it.
+type TaxableLine struct {
+	AmountCents int64
+	Rates       []models.TaxRate
+}
+
+type TaxLine struct {
+	TaxRateID     string      `bson:"tax_rate_id" json:"tax_rate_id"`
+	Name          string      `bson:"name" json:"name"`
+	Code          string      `bson:"code" json:"code"`
+	Rate          float64     `bson:"rate" json:"rate"`
+	Inclusive     bool        `bson:"inclusive" json:"inclusive"`
+	TaxableAmount money.Money `bson:"taxable_amount" json:"taxable_amount"`
+	TaxAmount     money.Money `bson:"tax_amount" json:"tax_amount"`
+	TaxCents      int64       `bson:"-" json:"-"`
+}
+
+type TaxSummary struct {
+	Lines             []TaxLine
+	InclusiveTaxCents int64
+	ExclusiveTaxCents int64
+}
+
+// ComputeTaxes builds one breakdown line per tax rate. Inclusive rates are
+// backed out of the line price first, so every rate is charged on the net
+// amount. Bases are summed exactly and each rate is rounded once, which keeps
+// the breakdown free of per-line rounding drift.
+func ComputeTaxes(lines []TaxableLine) TaxSummary {
+	type bucket struct {
+		rate models.TaxRate
+		base *big.Rat
+	}
+	buckets := map[string]*bucket{}
+
+	for _, line := range lines {
+		inclusive := new(big.Rat)
+		for _, rate := range line.Rates {
+			if rate.Inclusive != nil && *rate.Inclusive {
+				inclusive.Add(inclusive, ratePercent(rate))
+			}
+		}
+		// bases are kept in minor units, so rounding them needs no scale
+		net := new(big.Rat).Quo(big.NewRat(line.AmountCents, 1), new(big.Rat).Add(big.NewRat(1, 1), inclusive))
+
+		for _, rate := range line.Rates {
+			b, ok := buckets[rate.TaxRateID]
+			if !ok {
+				b = &bucket{rate: rate, base: new(big.Rat)}
+				buckets[rate.TaxRateID] = b
+			}
+			b.base.Add(b.base, net)
+		}
+	}
+
+	var summary TaxSummary
+	summary.Lines = []TaxLine{}
+	for _, b := range buckets {
+		taxCents := money.Round(new(big.Rat).Mul(b.base, ratePercent(b.rate)))
+		line := TaxLine{
+			TaxRateID:     b.rate.TaxRateID,
+			Rate:          *b.rate.Rate,
+			Inclusive:     b.rate.Inclusive != nil && *b.rate.Inclusive,
+			TaxableAmount: money.Of(money.Round(b.base)),
+			TaxAmount:     money.Of(taxCents),
+			TaxCents:      taxCents,
+		}
+		if b.rate.Name != nil {
+			line.Name = *b.rate.Name
+		}
+		if b.rate.Code != nil {
+			line.Code = *b.rate.Code
+		}
+		if line.Inclusive {
+			summary.InclusiveTaxCents += taxCents
+		} else {
+			summary.ExclusiveTaxCents += taxCents
+		}
+		summary.Lines = append(summary.Lines, line)
+	}
+
+	sort.Slice(summary.Lines, func(i, j int) bool {
+		if summary.Lines[i].Inclusive != summary.Lines[j].Inclusive {
+			return summary.Lines[i].Inclusive
+		}
+		// lines sharing a code are told apart by rate, name and id, so
+		// the breakdown comes out the same on every render
+		a, b := summary.Lines[i], summary.Lines[j]
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		if a.Rate != b.Rate {
+			return a.Rate < b.Rate
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.TaxRateID < b.TaxRateID
+	})
+	return summary
+}
+
+func ratePercent(rate models.TaxRate) *big.Rat {
+	if rate.Rate == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Quo(RatFromFloat(*rate.Rate), big.NewRat(100, 1))
+}
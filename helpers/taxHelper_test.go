@@ -0,0 +1,136 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+
+	"atm1504.in/rms/models"
+)
+
+func taxRate(id string, code string, rate float64, inclusive bool) models.TaxRate {
+	return models.TaxRate{TaxRateID: id, Code: &code, Rate: &rate, Inclusive: &inclusive}
+}
+
+func TestComputeTaxes(t *testing.T) {
+	vat := taxRate("vat", "VAT", 10, false)
+	gst := taxRate("gst", "GST", 10, true)
+	city := taxRate("city", "CITY", 7.5, false)
+	included := taxRate("inc", "INC", 15, true)
+	added := taxRate("add", "ADD", 5, false)
+
+	tests := []struct {
+		name          string
+		lines         []TaxableLine
+		wantInclusive int64
+		wantExclusive int64
+		wantTaxable   map[string]int64
+	}{
+		{
+			name:          "exclusive",
+			lines:         []TaxableLine{{AmountCents: 1000, Rates: []models.TaxRate{vat}}},
+			wantExclusive: 100,
+			wantTaxable:   map[string]int64{"vat": 1000},
+		},
+		{
+			name:          "inclusive is backed out of the price",
+			lines:         []TaxableLine{{AmountCents: 1100, Rates: []models.TaxRate{gst}}},
+			wantInclusive: 100,
+			wantTaxable:   map[string]int64{"gst": 1000},
+		},
+		{
+			name:          "exclusive on the net of inclusive",
+			lines:         []TaxableLine{{AmountCents: 1150, Rates: []models.TaxRate{included, added}}},
+			wantInclusive: 150,
+			wantExclusive: 50,
+			wantTaxable:   map[string]int64{"inc": 1000, "add": 1000},
+		},
+		{
+			// per line 2.475 would round to 2 three times; the summed
+			// base of 99 gives 7.425
+			name: "rounded once per rate",
+			lines: []TaxableLine{
+				{AmountCents: 33, Rates: []models.TaxRate{city}},
+				{AmountCents: 33, Rates: []models.TaxRate{city}},
+				{AmountCents: 33, Rates: []models.TaxRate{city}},
+			},
+			wantExclusive: 7,
+			wantTaxable:   map[string]int64{"city": 99},
+		},
+		{
+			name:          "half a cent rounds up",
+			lines:         []TaxableLine{{AmountCents: 5, Rates: []models.TaxRate{vat}}},
+			wantExclusive: 1,
+			wantTaxable:   map[string]int64{"vat": 5},
+		},
+		{
+			name: "negative totals",
+			lines: []TaxableLine{
+				{AmountCents: -1000, Rates: []models.TaxRate{vat}},
+				{AmountCents: -1100, Rates: []models.TaxRate{gst}},
+			},
+			wantInclusive: -100,
+			wantExclusive: -100,
+			wantTaxable:   map[string]int64{"vat": -1000, "gst": -1000},
+		},
+		{
+			name: "credits cancel out",
+			lines: []TaxableLine{
+				{AmountCents: 1000, Rates: []models.TaxRate{vat}},
+				{AmountCents: -1000, Rates: []models.TaxRate{vat}},
+			},
+			wantTaxable: map[string]int64{"vat": 0},
+		},
+		{
+			name:        "untaxed line",
+			lines:       []TaxableLine{{AmountCents: 1000}},
+			wantTaxable: map[string]int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := ComputeTaxes(tt.lines)
+			if summary.InclusiveTaxCents != tt.wantInclusive || summary.ExclusiveTaxCents != tt.wantExclusive {
+				t.Errorf("taxes = %d inclusive, %d exclusive, want %d and %d", summary.InclusiveTaxCents, summary.ExclusiveTaxCents, tt.wantInclusive, tt.wantExclusive)
+			}
+			taxable := map[string]int64{}
+			var taxCents int64
+			for _, line := range summary.Lines {
+				taxable[line.TaxRateID] = line.TaxableAmount.Cents
+				taxCents += line.TaxCents
+				if line.TaxAmount.Cents != line.TaxCents {
+					t.Errorf("%s tax amount %d differs from tax cents %d", line.TaxRateID, line.TaxAmount.Cents, line.TaxCents)
+				}
+			}
+			if !reflect.DeepEqual(taxable, tt.wantTaxable) {
+				t.Errorf("taxable amounts = %v, want %v", taxable, tt.wantTaxable)
+			}
+			if taxCents != tt.wantInclusive+tt.wantExclusive {
+				t.Errorf("tax lines add up to %d, want %d", taxCents, tt.wantInclusive+tt.wantExclusive)
+			}
+		})
+	}
+}
+
+func TestComputeTaxesOrder(t *testing.T) {
+	lines := []TaxableLine{
+		{AmountCents: 1000, Rates: []models.TaxRate{
+			taxRate("state", "STATE", 5, false),
+			taxRate("city-b", "CITY", 1, false),
+			taxRate("city", "CITY", 2, false),
+			taxRate("city-a", "CITY", 1, false),
+		}},
+		{AmountCents: 1100, Rates: []models.TaxRate{taxRate("gst", "GST", 10, true)}},
+	}
+	want := []string{"gst", "city-a", "city-b", "city", "state"}
+
+	// rates are gathered in a map, so the order must not depend on it
+	for i := 0; i < 20; i++ {
+		var got []string
+		for _, line := range ComputeTaxes(lines).Lines {
+			got = append(got, line.TaxRateID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("tax lines ordered %v, want %v", got, want)
+		}
+	}
+}
@@ -99,16 +99,23 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 		},
 	)
 
+	if token == nil {
+		msg = "the token is invalid"
+		return
+	}
+
 	claims, ok := token.Claims.(*SignedDetails)
 	if !ok {
 		msg = "the token is invalid"
-		msg = err.Error()
 		return
 	}
 	//the token is expired
 	if claims.ExpiresAt < time.Now().Local().Unix() {
 		msg = "token is expired"
-		msg = err.Error()
+		return
+	}
+	if err != nil || !token.Valid {
+		msg = "the token is invalid"
 		return
 	}
 	return claims, msg
@@ -0,0 +1,100 @@
+// Package imaging checks uploaded images and makes thumbnails of them with
+// the standard library alone.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// MaxPixels caps the size of images decoded, so a small file that expands
+// into a huge image can't exhaust memory.
+const MaxPixels = 40_000_000
+
+var ErrUnsupported = errors.New("image must be a JPEG, PNG or GIF")
+
+// Formats are the content types accepted, with the file extension stored
+// for each.
+var Formats = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Decode sniffs the content type of an upload and decodes it, refusing
+// anything but the accepted formats and images over MaxPixels.
+func Decode(data []byte) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := Formats[contentType]; !ok {
+		return nil, contentType, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, contentType, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, contentType, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, contentType, err
+}
+
+// Thumbnail scales an image down to fit in a size by size square, keeping
+// its aspect ratio. Each pixel is the average of the pixels it covers.
+// Images already small enough are only copied.
+func Thumbnail(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/bounds.Dx())
+		} else {
+			width, height = max(1, width*size/bounds.Dy()), size
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*bounds.Dy()/height, (y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := x*bounds.Dx()/width, (x+1)*bounds.Dx()/width
+			var r, g, b, a, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+uint64(p[0]), g+uint64(p[1]), b+uint64(p[2]), a+uint64(p[3])
+					n++
+				}
+			}
+			p := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			p[0], p[1], p[2], p[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// Encode writes a thumbnail as PNG when the original may be transparent and
+// as JPEG otherwise, returning the content type used.
+func Encode(img *image.RGBA, originalType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if originalType == "image/jpeg" || img.Opaque() {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), "image/png", err
+}
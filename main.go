@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"os"
+	"time"
 
 	"log"
 
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/database"
+	"atm1504.in/rms/gateway"
 	routes "atm1504.in/rms/routes"
+	"atm1504.in/rms/scheduler"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -24,17 +30,58 @@ func main() {
 		port = "8080"
 	}
 
+	if err := database.Ping(context.Background()); err != nil {
+		log.Fatalf("Failed to ping database: %v", err)
+	}
+	if err := controller.UseBaseCurrency(context.Background()); err != nil {
+		log.Fatalf("Error loading the base currency: %v", err)
+	}
+	if err := controller.MigrateMoney(context.Background()); err != nil {
+		log.Fatalf("Error migrating stored amounts: %v", err)
+	}
+	if err := controller.EnsureListIndexes(context.Background()); err != nil {
+		log.Fatalf("Error creating indexes: %v", err)
+	}
+	if err := controller.EnsureInvoiceIndexes(context.Background()); err != nil {
+		log.Fatalf("Error creating invoice indexes: %v", err)
+	}
+	if err := controller.EnsurePromotionIndexes(context.Background()); err != nil {
+		log.Fatalf("Error creating promotion indexes: %v", err)
+	}
+	if err := controller.EnsurePaymentIndexes(context.Background()); err != nil {
+		log.Fatalf("Error creating payment indexes: %v", err)
+	}
+	if err := gateway.CheckConfig(); err != nil {
+		log.Fatalf("Error configuring the payment provider: %v", err)
+	}
+
 	router := gin.New()
 	router.Use(gin.Logger())
+	router.Use(gin.Recovery())
 	routes.UserRoutes(router)
 	routes.FoodRoutes(router)
 	routes.MenuRoutes(router)
+	routes.CategoryRoutes(router)
 	routes.OrderRoutes(router)
 	routes.TableRoutes(router)
 	routes.OrderItemRoutes(router)
 	routes.InvoiceRoutes(router)
+	routes.NoteRoutes(router)
+	routes.TaxRateRoutes(router)
+	routes.PromotionRoutes(router)
+	routes.PaymentRoutes(router)
+	routes.ServiceChargeRoutes(router)
+	routes.RestaurantRoutes(router)
+	routes.PrinterRoutes(router)
+	routes.ExchangeRateRoutes(router)
 	// router.Use(middleware.Authentication())
 
+	scheduler.Start(context.Background(),
+		scheduler.Job{Name: "course auto-fire", Interval: 30 * time.Second, Run: controller.AutoFireCourses},
+		scheduler.Job{Name: "overdue invoices", Interval: 15 * time.Minute, Run: controller.MarkOverdueInvoices},
+		scheduler.Job{Name: "scheduled menus", Interval: time.Minute, Run: controller.PublishScheduledMenus},
+	)
+
 	runErr := router.Run(":" + port)
 	if runErr != nil {
 		// Handle the error, for example, log it or return it
@@ -1 +1,32 @@
 package middleware
+
+import (
+	"net/http"
+
+	helper "atm1504.in/rms/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func Authentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientToken := c.Request.Header.Get("token")
+		if clientToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No Authorization header provided"})
+			c.Abort()
+			return
+		}
+
+		claims, msg := helper.ValidateToken(clientToken)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			c.Abort()
+			return
+		}
+
+		c.Set("email", claims.Email)
+		c.Set("first_name", claims.FirstName)
+		c.Set("last_name", claims.LastName)
+		c.Set("uid", claims.UID)
+		c.Next()
+	}
+}
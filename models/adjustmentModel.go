@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type ManagerApproval struct {
+	ManagerID    string `bson:"manager_id" json:"manager_id"`
+	Pin          string `bson:"pin" json:"pin"`
+	ManagerToken string `bson:"manager_token" json:"manager_token"`
+}
+
+type AdjustmentRequest struct {
+	ReasonCode *string         `json:"reason_code" validate:"required,eq=ORDER_ERROR|eq=CUSTOMER_REQUEST|eq=KITCHEN_ERROR|eq=QUALITY_ISSUE|eq=LONG_WAIT|eq=WALKOUT|eq=HOSPITALITY|eq=PRICE_CORRECTION|eq=OTHER"`
+	Comment    string          `json:"comment"`
+	Approval   ManagerApproval `json:"approval"`
+}
+
+type Adjustment struct {
+	Action      string    `bson:"action" json:"action"`
+	ReasonCode  string    `bson:"reason_code" json:"reason_code"`
+	Comment     string    `bson:"comment" json:"comment"`
+	RequestedBy string    `bson:"requested_by" json:"requested_by"`
+	ApprovedBy  string    `bson:"approved_by" json:"approved_by"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditEntry struct {
+	ID            primitive.ObjectID `bson:"_id" json:"_id"`
+	Action        string             `bson:"action" json:"action"`
+	OrderID       string             `bson:"order_id" json:"order_id"`
+	TargetOrderID string             `bson:"target_order_id,omitempty" json:"target_order_id,omitempty"`
+	OrderItemIDs  []string           `bson:"order_item_ids" json:"order_item_ids"`
+	PerformedBy   string             `bson:"performed_by" json:"performed_by"`
+	Details       string             `bson:"details" json:"details"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	AuditID       string             `bson:"audit_id" json:"audit_id"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Category groups the foods of a menu. Categories nest under a parent, such
+// as Drinks > Hot > Coffee, and are shown by SortOrder within their parent.
+type Category struct {
+	ID         primitive.ObjectID `bson:"_id" json:"_id"`
+	Name       *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	MenuID     *string            `bson:"menu_id" json:"menu_id" validate:"required"`
+	ParentID   *string            `bson:"parent_id" json:"parent_id"`
+	SortOrder  *int               `bson:"sort_order" json:"sort_order" validate:"omitempty,min=0"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	CategoryID string             `bson:"category_id" json:"category_id"`
+}
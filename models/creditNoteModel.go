@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"atm1504.in/rms/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RefundRequest struct {
+	AdjustmentRequest
+	OrderItemIDs []string     `json:"order_item_ids"`
+	Amount       *money.Money `json:"amount" validate:"omitempty,gt=0"`
+	Method       *string      `json:"method" validate:"omitempty,eq=CASH|eq=CARD"`
+}
+
+type CreditNoteLine struct {
+	OrderItemID string      `bson:"order_item_id" json:"order_item_id"`
+	FoodName    string      `bson:"food_name" json:"food_name"`
+	Amount      money.Money `bson:"amount" json:"amount"`
+}
+
+// ProviderRefund is the part of a card refund sent back through the payment
+// provider against one payment.
+type ProviderRefund struct {
+	PaymentID     string      `bson:"payment_id" json:"payment_id"`
+	Provider      string      `bson:"provider" json:"provider"`
+	TransactionID string      `bson:"transaction_id" json:"transaction_id"`
+	Amount        money.Money `bson:"amount" json:"amount"`
+}
+
+type CreditNote struct {
+	ID              primitive.ObjectID `bson:"_id" json:"_id"`
+	InvoiceID       string             `bson:"invoice_id" json:"invoice_id"`
+	OrderID         string             `bson:"order_id" json:"order_id"`
+	Lines           []CreditNoteLine   `bson:"lines" json:"lines"`
+	Amount          money.Money        `bson:"amount" json:"amount"`
+	Method          string             `bson:"method" json:"method"`
+	Adjustment      Adjustment         `bson:"adjustment" json:"adjustment"`
+	ProviderRefunds []ProviderRefund   `bson:"provider_refunds,omitempty" json:"provider_refunds,omitempty"`
+	// Status is PENDING while a card refund is with the provider, then
+	// COMPLETED, or PARTIAL or FAILED with Amount cut down to what the
+	// provider refunded. Credit notes from before it was kept are completed.
+	Status       string    `bson:"status,omitempty" json:"status,omitempty" validate:"omitempty,eq=PENDING|eq=COMPLETED|eq=PARTIAL|eq=FAILED"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	CreditNoteID string    `bson:"credit_note_id" json:"credit_note_id"`
+}
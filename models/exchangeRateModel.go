@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExchangeRate is what one unit of a foreign currency is worth in the
+// restaurant's base currency.
+type ExchangeRate struct {
+	ID             primitive.ObjectID `bson:"_id" json:"_id"`
+	Currency       string             `bson:"currency" json:"currency"`
+	Rate           *float64           `bson:"rate" json:"rate" validate:"required,gt=0"`
+	UpdatedBy      string             `bson:"updated_by" json:"updated_by"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	ExchangeRateID string             `bson:"exchange_rate_id" json:"exchange_rate_id"`
+}
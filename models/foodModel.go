@@ -3,16 +3,47 @@ package models
 import (
 	"time"
 
+	"atm1504.in/rms/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Food struct {
 	ID        primitive.ObjectID `bson:"_id" json:"_id"`
 	Name      *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
-	Price     *float64           `bson:"price" json:"price" validate:"required"`
-	FoodImage *string            `bson:"food_image" json:"food_image" validate:"required"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
-	FoodID    string             `bson:"food_id" json:"food_id"`
-	MenuID    *string            `bson:"menu_id" json:"menu_id" validate:"required"`
+	Price     *money.Money       `bson:"price" json:"price" validate:"required,gt=0"`
+	FoodImage *string            `bson:"food_image" json:"food_image"`
+	// Thumbnails are scaled down copies of an uploaded FoodImage by size.
+	Thumbnails map[string]string `bson:"thumbnails,omitempty" json:"thumbnails,omitempty"`
+	CreatedAt  time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time         `bson:"updated_at" json:"updated_at"`
+	FoodID     string            `bson:"food_id" json:"food_id"`
+	MenuID     *string           `bson:"menu_id" json:"menu_id" validate:"required"`
+	TaxRateIDs []string          `bson:"tax_rate_ids" json:"tax_rate_ids"`
+	CategoryID *string           `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	SortOrder  *int              `bson:"sort_order,omitempty" json:"sort_order,omitempty" validate:"omitempty,min=0"`
+	// Allergens are those of Allergens the food contains.
+	Allergens   []string       `bson:"allergens" json:"allergens" validate:"omitempty,dive,allergen"`
+	DietaryTags []string       `bson:"dietary_tags" json:"dietary_tags" validate:"omitempty,dive,dietary_tag"`
+	Modifiers   []FoodModifier `bson:"modifiers,omitempty" json:"modifiers,omitempty" validate:"omitempty,dive"`
+	// DisplayPrices is the price in the restaurant's display currencies.
+	DisplayPrices []money.Money `bson:"-" json:"display_prices,omitempty"`
+}
+
+// FoodModifier is an option that can be ordered with a food, such as extra
+// cheese, with the allergens it brings.
+type FoodModifier struct {
+	Name        string   `bson:"name" json:"name" validate:"required,min=1,max=100"`
+	Allergens   []string `bson:"allergens" json:"allergens" validate:"omitempty,dive,allergen"`
+	DietaryTags []string `bson:"dietary_tags" json:"dietary_tags" validate:"omitempty,dive,dietary_tag"`
+}
+
+// Allergens are the fourteen allergens that must be declared on food.
+var Allergens = []string{
+	"CELERY", "GLUTEN", "CRUSTACEANS", "EGGS", "FISH", "LUPIN", "MILK",
+	"MOLLUSCS", "MUSTARD", "PEANUTS", "SESAME", "SOYBEANS", "SULPHITES", "TREE_NUTS",
+}
+
+// DietaryTags are the diets a food can be marked as suitable for.
+var DietaryTags = []string{
+	"VEGETARIAN", "VEGAN", "HALAL", "KOSHER", "GLUTEN_FREE", "DAIRY_FREE", "NUT_FREE",
 }
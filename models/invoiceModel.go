@@ -3,16 +3,32 @@ package models
 import (
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Invoice struct {
 	ID             primitive.ObjectID `bson:"_id" json:"_id"`
 	InvoiceID      string             `bson:"invoice_id" json:"invoice_id"`
+	InvoiceNumber  string             `bson:"invoice_number,omitempty" json:"invoice_number,omitempty"`
+	NumberSeries   string             `bson:"number_series,omitempty" json:"-"`
+	NumberSequence int64              `bson:"number_sequence,omitempty" json:"-"`
 	OrderID        string             `bson:"order_id" json:"order_id"`
 	PaymentMethod  *string            `bson:"payment_method" json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
-	PaymentStatus  *string            `bson:"payment_status" json:"payment_status" validate:"required,eq=PENDING|eq=PAID"`
+	PaymentStatus  *string            `bson:"payment_status" json:"payment_status" validate:"required,eq=PENDING|eq=PARTIALLY_PAID|eq=PAID|eq=REFUNDED|eq=OVERDUE|eq=VOIDED"`
 	PaymentDueDate time.Time          `bson:"payment_due_date" json:"payment_due_date"`
+	BillingEmail   *string            `bson:"billing_email,omitempty" json:"billing_email,omitempty" validate:"omitempty,email"`
+	RemindersSent  int                `bson:"reminders_sent,omitempty" json:"reminders_sent,omitempty"`
+	LastRemindedAt *time.Time         `bson:"last_reminded_at,omitempty" json:"last_reminded_at,omitempty"`
+	CouponCodes    []string           `bson:"coupon_codes" json:"coupon_codes"`
+	SplitType      *string            `bson:"split_type,omitempty" json:"split_type,omitempty" validate:"omitempty,eq=ITEM|eq=SEAT|eq=EVEN"`
+	SplitIndex     *int               `bson:"split_index,omitempty" json:"split_index,omitempty"`
+	SplitCount     *int               `bson:"split_count,omitempty" json:"split_count,omitempty"`
+	Seat           *int               `bson:"seat,omitempty" json:"seat,omitempty"`
+	OrderItemIDs   []string           `bson:"order_item_ids,omitempty" json:"order_item_ids,omitempty"`
 	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	// Bill is the invoice as billed, priced when it was made and kept so
+	// later changes to prices, taxes or promotions don't change it.
+	Bill bson.Raw `bson:"bill,omitempty" json:"-"`
 }
@@ -6,13 +6,58 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Menu is orderable from StartDate until EndDate, either being open ended
+// when not set. With schedules it is only orderable within one of them too.
+//
+// The fields served are those of the published version. Edits go to Draft
+// and only reach the menu when it is published, at once or at PublishAt. A
+// menu still in DRAFT status has never been published and can't be ordered
+// from.
 type Menu struct {
-	ID        primitive.ObjectID `bson:"_id" json:"_id"`
-	Name      string             `bson:"name" json:"name" validate:"required"`
-	Category  string             `bson:"category" json:"category" validate:"required"`
-	StartDate *time.Time         `bson:"start_date" json:"start_date"`
-	EndDate   *time.Time         `bson:"end_date" json:"end_date"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
-	MenuID    string             `bson:"menu_id" json:"menu_id"`
+	ID          primitive.ObjectID `bson:"_id" json:"_id"`
+	Name        string             `bson:"name" json:"name" validate:"required"`
+	Category    string             `bson:"category" json:"category" validate:"required"`
+	StartDate   *time.Time         `bson:"start_date" json:"start_date"`
+	EndDate     *time.Time         `bson:"end_date" json:"end_date"`
+	Schedules   []MenuSchedule     `bson:"schedules,omitempty" json:"schedules,omitempty" validate:"omitempty,dive"`
+	Status      *string            `bson:"status" json:"status" validate:"omitempty,eq=DRAFT|eq=PUBLISHED"`
+	Version     int                `bson:"version" json:"version"`
+	Draft       *MenuContent       `bson:"draft,omitempty" json:"draft,omitempty"`
+	PublishAt   *time.Time         `bson:"publish_at,omitempty" json:"publish_at,omitempty"`
+	PublishedAt *time.Time         `bson:"published_at,omitempty" json:"published_at,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	MenuID      string             `bson:"menu_id" json:"menu_id"`
+}
+
+// MenuSchedule is a recurring window in the restaurant's timezone, such as
+// 07:00 to 11:00 on weekdays. Without days it applies every day. A window
+// ending before it starts runs past midnight into the next day.
+type MenuSchedule struct {
+	Days      []string `bson:"days" json:"days" validate:"omitempty,dive,eq=MON|eq=TUE|eq=WED|eq=THU|eq=FRI|eq=SAT|eq=SUN"`
+	StartTime string   `bson:"start_time" json:"start_time" validate:"required,datetime=15:04"`
+	EndTime   string   `bson:"end_time" json:"end_time" validate:"required,datetime=15:04"`
+}
+
+// MenuContent is the versioned part of a menu, held by drafts and by the
+// version history.
+type MenuContent struct {
+	Name      string         `bson:"name" json:"name" validate:"required"`
+	Category  string         `bson:"category" json:"category" validate:"required"`
+	StartDate *time.Time     `bson:"start_date" json:"start_date"`
+	EndDate   *time.Time     `bson:"end_date" json:"end_date"`
+	Schedules []MenuSchedule `bson:"schedules,omitempty" json:"schedules,omitempty" validate:"omitempty,dive"`
+}
+
+// MenuVersion is a published version of a menu. A rollback publishes the
+// content of an earlier version again as a new version.
+type MenuVersion struct {
+	ID             primitive.ObjectID `bson:"_id" json:"_id"`
+	MenuID         string             `bson:"menu_id" json:"menu_id"`
+	Version        int                `bson:"version" json:"version"`
+	Content        MenuContent        `bson:"content" json:"content"`
+	RolledBackFrom *int               `bson:"rolled_back_from,omitempty" json:"rolled_back_from,omitempty"`
+	PublishedBy    string             `bson:"published_by" json:"published_by"`
+	PublishedAt    time.Time          `bson:"published_at" json:"published_at"`
+	MenuVersionID  string             `bson:"menu_version_id" json:"menu_version_id"`
 }
@@ -7,10 +7,16 @@ import (
 )
 
 type Note struct {
-	ID        primitive.ObjectID `bson:"_id" json:"_id"`
-	Text      string             `bson:"text" json:"text"`
-	Title     string             `bson:"title" json:"title"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
-	NoteID    string             `bson:"note_id" json:"note_id"`
+	ID          primitive.ObjectID `bson:"_id" json:"_id"`
+	Text        string             `bson:"text" json:"text" validate:"required"`
+	Title       string             `bson:"title" json:"title"`
+	NoteType    *string            `bson:"note_type" json:"note_type" validate:"required,eq=GENERAL|eq=SPECIAL_REQUEST|eq=ALLERGY|eq=COMPLAINT"`
+	EntityType  *string            `bson:"entity_type" json:"entity_type" validate:"required,eq=ORDER|eq=ORDER_ITEM|eq=TABLE|eq=INVOICE"`
+	EntityID    *string            `bson:"entity_id" json:"entity_id" validate:"required"`
+	AuthorID    string             `bson:"author_id" json:"author_id"`
+	AuthorName  string             `bson:"author_name" json:"author_name"`
+	AuthorEmail string             `bson:"author_email" json:"author_email"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	NoteID      string             `bson:"note_id" json:"note_id"`
 }
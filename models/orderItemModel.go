@@ -3,16 +3,26 @@ package models
 import (
 	"time"
 
+	"atm1504.in/rms/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type OrderItem struct {
 	ID          primitive.ObjectID `bson:"_id" json:"_id"`
 	Quantity    *string            `bson:"quantity" json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
-	UnitPrice   *float64           `bson:"unit_price" json:"unit_price" validate:"required"`
+	UnitPrice   *money.Money       `bson:"unit_price" json:"unit_price" validate:"required,gt=0"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
 	FoodID      *string            `bson:"food_id" json:"food_id" validate:"required"`
 	OrderItemID string             `bson:"order_item_id" json:"order_item_id"`
 	OrderID     string             `bson:"order_id" json:"order_id" validate:"required"`
+	Status      *string            `bson:"status" json:"status" validate:"omitempty,eq=ACTIVE|eq=VOIDED|eq=COMPED"`
+	Course      *int               `bson:"course" json:"course" validate:"omitempty,min=1"`
+	Seat        *int               `bson:"seat" json:"seat" validate:"omitempty,min=1"`
+	Hold        *bool              `bson:"hold" json:"hold"`
+	FireAt      *time.Time         `bson:"fire_at" json:"fire_at"`
+	FiredAt     *time.Time         `bson:"fired_at" json:"fired_at"`
+	Adjustments []Adjustment       `bson:"adjustments,omitempty" json:"adjustments,omitempty"`
+	// Modifiers are the names of the food's modifiers ordered with it.
+	Modifiers []string `bson:"modifiers,omitempty" json:"modifiers,omitempty"`
 }
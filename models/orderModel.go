@@ -3,14 +3,28 @@ package models
 import (
 	"time"
 
+	"atm1504.in/rms/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Order struct {
-	ID        primitive.ObjectID `bson:"_id" json:"_id"`
-	OrderDate time.Time          `bson:"order_date" json:"order_date" validate:"required"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
-	OrderID   string             `bson:"order_id" json:"order_id"`
-	TableID   *string            `bson:"table_id" json:"table_id" validate:"required"`
+	ID              primitive.ObjectID `bson:"_id" json:"_id"`
+	OrderDate       time.Time          `bson:"order_date" json:"order_date" validate:"required"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	OrderID         string             `bson:"order_id" json:"order_id"`
+	OrderType       *string            `bson:"order_type" json:"order_type" validate:"omitempty,eq=DINE_IN|eq=TAKEAWAY|eq=DELIVERY"`
+	TableID         *string            `bson:"table_id" json:"table_id"`
+	ServerID        *string            `bson:"server_id" json:"server_id"`
+	CustomerName    *string            `bson:"customer_name" json:"customer_name"`
+	CustomerPhone   *string            `bson:"customer_phone" json:"customer_phone"`
+	PickupTime      *time.Time         `bson:"pickup_time" json:"pickup_time"`
+	DeliveryAddress *string            `bson:"delivery_address" json:"delivery_address"`
+	DeliveryFee     *money.Money       `bson:"delivery_fee" json:"delivery_fee" validate:"omitempty,min=0"`
+	DeliveryStatus  *string            `bson:"delivery_status" json:"delivery_status" validate:"omitempty,eq=PENDING|eq=DISPATCHED|eq=DELIVERED|eq=FAILED"`
+	Status          *string            `bson:"status" json:"status" validate:"omitempty,eq=OPEN|eq=CANCELLED|eq=MERGED"`
+	Adjustments     []Adjustment       `bson:"adjustments,omitempty" json:"adjustments,omitempty"`
+	MergedInto      *string            `bson:"merged_into,omitempty" json:"merged_into,omitempty"`
+	// Allergies are the allergens the guests declared.
+	Allergies []string `bson:"allergies,omitempty" json:"allergies,omitempty" validate:"omitempty,dive,allergen"`
 }
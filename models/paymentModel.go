@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"atm1504.in/rms/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Payment struct {
+	ID        primitive.ObjectID `bson:"_id" json:"_id"`
+	InvoiceID string             `bson:"invoice_id" json:"invoice_id"`
+	OrderID   string             `bson:"order_id" json:"order_id"`
+	Method    *string            `bson:"method" json:"method" validate:"required,eq=CASH|eq=CARD"`
+	Amount    *money.Money       `bson:"amount" json:"amount" validate:"omitempty,gt=0"`
+	Tendered  *money.Money       `bson:"tendered" json:"tendered" validate:"omitempty,gt=0"`
+	// ForeignTendered is cash handed over in another currency, Tendered
+	// being its value in the base currency at ExchangeRate.
+	ForeignTendered *money.Money `bson:"foreign_tendered,omitempty" json:"foreign_tendered,omitempty"`
+	ExchangeRate    *float64     `bson:"exchange_rate,omitempty" json:"exchange_rate,omitempty"`
+	Change          money.Money  `bson:"change" json:"change"`
+	Tip             *money.Money `bson:"tip" json:"tip" validate:"omitempty,min=0"`
+	ServerID        string       `bson:"server_id" json:"server_id"`
+	Reference       *string      `bson:"reference" json:"reference" validate:"omitempty,max=100"`
+	Status          string       `bson:"status" json:"status"`
+	Capture         *bool        `bson:"capture" json:"capture"`
+	CardToken       string       `bson:"-" json:"card_token,omitempty"`
+	Provider        string       `bson:"provider,omitempty" json:"provider,omitempty"`
+	TransactionID   string       `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"`
+	DeclineReason   string       `bson:"decline_reason,omitempty" json:"decline_reason,omitempty"`
+	IdempotencyKey  string       `bson:"idempotency_key,omitempty" json:"idempotency_key,omitempty"`
+	RefundedAmount  money.Money  `bson:"refunded_amount" json:"refunded_amount"`
+	ReceivedBy      string       `bson:"received_by" json:"received_by"`
+	CreatedAt       time.Time    `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time    `bson:"updated_at" json:"updated_at"`
+	PaymentID       string       `bson:"payment_id" json:"payment_id"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"atm1504.in/rms/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Promotion struct {
+	ID             primitive.ObjectID `bson:"_id" json:"_id"`
+	Name           *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Description    string             `bson:"description" json:"description"`
+	DiscountType   *string            `bson:"discount_type" json:"discount_type" validate:"required,eq=PERCENTAGE|eq=FIXED|eq=BUY_X_GET_Y"`
+	Value          *float64           `bson:"value" json:"value" validate:"omitempty,min=0"`
+	Amount         *money.Money       `bson:"amount,omitempty" json:"amount,omitempty" validate:"omitempty,min=0"`
+	Scope          *string            `bson:"scope" json:"scope" validate:"required,eq=ITEM|eq=CATEGORY|eq=ORDER"`
+	FoodIDs        []string           `bson:"food_ids" json:"food_ids"`
+	Categories     []string           `bson:"categories" json:"categories"`
+	BuyQuantity    *int               `bson:"buy_quantity" json:"buy_quantity" validate:"omitempty,min=1"`
+	GetQuantity    *int               `bson:"get_quantity" json:"get_quantity" validate:"omitempty,min=1"`
+	MinOrderAmount *money.Money       `bson:"min_order_amount" json:"min_order_amount" validate:"omitempty,min=0"`
+	StartDate      *time.Time         `bson:"start_date" json:"start_date"`
+	EndDate        *time.Time         `bson:"end_date" json:"end_date"`
+	DaysOfWeek     []string           `bson:"days_of_week" json:"days_of_week" validate:"dive,eq=SUN|eq=MON|eq=TUE|eq=WED|eq=THU|eq=FRI|eq=SAT"`
+	StartTime      *string            `bson:"start_time" json:"start_time" validate:"omitempty,datetime=15:04"`
+	EndTime        *string            `bson:"end_time" json:"end_time" validate:"omitempty,datetime=15:04"`
+	CouponCode     *string            `bson:"coupon_code" json:"coupon_code" validate:"omitempty,min=3,max=30"`
+	UsageLimit     *int               `bson:"usage_limit" json:"usage_limit" validate:"omitempty,min=1"`
+	UsageCount     int                `bson:"usage_count" json:"usage_count"`
+	Stackable      *bool              `bson:"stackable" json:"stackable"`
+	Priority       int                `bson:"priority" json:"priority"`
+	Active         *bool              `bson:"active" json:"active"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	PromotionID    string             `bson:"promotion_id" json:"promotion_id"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Restaurant holds the restaurant's own details, printed on receipts. There is
+// a single restaurant document.
+type Restaurant struct {
+	ID                   primitive.ObjectID `bson:"_id" json:"_id"`
+	Name                 *string            `bson:"name" json:"name" validate:"omitempty,min=2,max=100"`
+	Address              *string            `bson:"address" json:"address" validate:"omitempty,max=300"`
+	Phone                *string            `bson:"phone" json:"phone"`
+	Email                *string            `bson:"email" json:"email" validate:"omitempty,email"`
+	Website              *string            `bson:"website" json:"website"`
+	TaxID                *string            `bson:"tax_id" json:"tax_id"`
+	ReceiptFooter        *string            `bson:"receipt_footer" json:"receipt_footer" validate:"omitempty,max=500"`
+	InvoicePrefix        *string            `bson:"invoice_prefix" json:"invoice_prefix" validate:"omitempty,max=20"`
+	InvoiceNumberFormat  *string            `bson:"invoice_number_format" json:"invoice_number_format" validate:"omitempty,max=60"`
+	FiscalYearStartMonth *int               `bson:"fiscal_year_start_month" json:"fiscal_year_start_month" validate:"omitempty,min=1,max=12"`
+	BaseCurrency         *string            `bson:"base_currency" json:"base_currency" validate:"omitempty,len=3,alpha"`
+	Timezone             *string            `bson:"timezone" json:"timezone"`
+	DisplayCurrencies    []string           `bson:"display_currencies" json:"display_currencies" validate:"omitempty,dive,len=3,alpha"`
+	CreatedAt            time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt            time.Time          `bson:"updated_at" json:"updated_at"`
+	RestaurantID         string             `bson:"restaurant_id" json:"restaurant_id"`
+}
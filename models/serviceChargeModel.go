@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ServiceCharge struct {
+	ID              primitive.ObjectID `bson:"_id" json:"_id"`
+	Name            *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Rate            *float64           `bson:"rate" json:"rate" validate:"required,min=0,max=100"`
+	MinPartySize    *int               `bson:"min_party_size" json:"min_party_size" validate:"omitempty,min=1"`
+	OrderTypes      []string           `bson:"order_types" json:"order_types" validate:"dive,eq=DINE_IN|eq=TAKEAWAY|eq=DELIVERY"`
+	Active          *bool              `bson:"active" json:"active"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	ServiceChargeID string             `bson:"service_charge_id" json:"service_charge_id"`
+}
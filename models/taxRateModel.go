@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TaxRate struct {
+	ID         primitive.ObjectID `bson:"_id" json:"_id"`
+	Name       *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Code       *string            `bson:"code" json:"code" validate:"required,min=2,max=30"`
+	Rate       *float64           `bson:"rate" json:"rate" validate:"required,min=0,max=100"`
+	Inclusive  *bool              `bson:"inclusive" json:"inclusive" validate:"required"`
+	Categories []string           `bson:"categories" json:"categories"`
+	Active     *bool              `bson:"active" json:"active"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	TaxRateID  string             `bson:"tax_rate_id" json:"tax_rate_id"`
+}
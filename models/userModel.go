@@ -7,16 +7,20 @@ import (
 )
 
 type User struct {
-	ID           primitive.ObjectID `bson:"_id" json:"_id"`
-	FirstName    *string            `bson:"first_name" json:"first_name" validate:"required,min=2,max=100"`
-	LastName     *string            `bson:"last_name" json:"last_name" validate:"required,min=2,max=100"`
-	Password     *string            `bson:"password" json:"password" validate:"required,min=6"`
-	Email        *string            `bson:"email" json:"email" validate:"email,required"`
-	Avatar       *string            `bson:"avatar" json:"avatar"`
-	Phone        *string            `bson:"phone" json:"phone" validate:"required"`
-	Token        *string            `bson:"token" json:"token"`
-	RefreshToken *string            `bson:"refresh_token" json:"refresh_token"`
-	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
-	UserID       string             `bson:"user_id" json:"user_id"`
+	ID        primitive.ObjectID `bson:"_id" json:"_id"`
+	FirstName *string            `bson:"first_name" json:"first_name" validate:"required,min=2,max=100"`
+	LastName  *string            `bson:"last_name" json:"last_name" validate:"required,min=2,max=100"`
+	Password  *string            `bson:"password" json:"password" validate:"required,min=6"`
+	Email     *string            `bson:"email" json:"email" validate:"email,required"`
+	Avatar    *string            `bson:"avatar" json:"avatar"`
+	Phone     *string            `bson:"phone" json:"phone" validate:"required"`
+	Role      *string            `bson:"role" json:"role" validate:"omitempty,eq=ADMIN|eq=MANAGER|eq=STAFF"`
+	// Pin is the bcrypt hash of a manager's approval PIN. It never leaves
+	// the server; SignUp takes the PIN itself.
+	Pin          *string   `bson:"pin" json:"-"`
+	Token        *string   `bson:"token" json:"token"`
+	RefreshToken *string   `bson:"refresh_token" json:"refresh_token"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `bson:"updated_at" json:"updated_at"`
+	UserID       string    `bson:"user_id" json:"user_id"`
 }
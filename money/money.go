@@ -0,0 +1,282 @@
+// Package money holds amounts of money exactly, as a whole number of the
+// currency's minor unit together with the currency code.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Money is an amount in a currency. Cents counts the currency's minor unit:
+// cents for USD, yen for JPY, fils for KWD.
+//
+// In JSON it is a plain decimal number such as 12.50 in the default
+// currency, or {"amount": 12.50, "currency": "EUR"} in any other; numbers
+// with more decimals than the currency has are rejected rather than
+// rounded. In MongoDB it is stored as {cents, currency} so sums in
+// aggregations stay exact. Amounts stored as floats by earlier versions are
+// still read, rounded half away from zero to the minor unit.
+type Money struct {
+	Cents    int64  `bson:"cents" json:"-"`
+	Currency string `bson:"currency" json:"-"`
+}
+
+var ErrTooPrecise = errors.New("amount has more decimals than the currency allows")
+
+// decimals lists the currencies whose minor unit isn't a hundredth.
+var decimals = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// Decimals is the number of decimal places of a currency's minor unit.
+func Decimals(currency string) int {
+	if places, ok := decimals[currency]; ok {
+		return places
+	}
+	return 2
+}
+
+// base is the currency set with SetDefaultCurrency.
+var base atomic.Value
+
+// DefaultCurrency is the currency amounts are in when they don't say: the
+// restaurant's base currency once set, otherwise CURRENCY and USD when that
+// isn't set either.
+func DefaultCurrency() string {
+	if currency, _ := base.Load().(string); currency != "" {
+		return currency
+	}
+	if currency := os.Getenv("CURRENCY"); currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return "USD"
+}
+
+// SetDefaultCurrency makes currency the default, taking over from CURRENCY.
+// An empty currency goes back to CURRENCY.
+func SetDefaultCurrency(currency string) {
+	base.Store(strings.ToUpper(currency))
+}
+
+func New(cents int64, currency string) Money {
+	return Money{Cents: cents, Currency: currency}
+}
+
+// Of is an amount of minor units in the default currency.
+func Of(cents int64) Money {
+	return New(cents, DefaultCurrency())
+}
+
+// Parse reads a decimal amount such as "12.5" in a currency.
+func Parse(text string, currency string) (Money, error) {
+	amount, ok := new(big.Rat).SetString(strings.TrimSpace(text))
+	if !ok {
+		return Money{}, fmt.Errorf("%q is not an amount", text)
+	}
+	cents := amount.Mul(amount, new(big.Rat).SetInt(scale(currency)))
+	if !cents.IsInt() {
+		return Money{}, ErrTooPrecise
+	}
+	if !cents.Num().IsInt64() {
+		return Money{}, fmt.Errorf("%q is too large", text)
+	}
+	return New(cents.Num().Int64(), currency), nil
+}
+
+// FromFloat converts an amount held as a float, going through its shortest
+// decimal form and rounding half away from zero to the minor unit.
+func FromFloat(amount float64, currency string) Money {
+	exact, _ := new(big.Rat).SetString(strconv.FormatFloat(amount, 'f', -1, 64))
+	return New(Round(exact.Mul(exact, new(big.Rat).SetInt(scale(currency)))), currency)
+}
+
+// Round rounds a number of minor units half away from zero.
+func Round(cents *big.Rat) int64 {
+	quotient, remainder := new(big.Int).QuoRem(cents.Num(), cents.Denom(), new(big.Int))
+	twice := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2))
+	if twice.Cmp(cents.Denom()) >= 0 {
+		if cents.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient.Int64()
+}
+
+// Add sums two amounts in the same currency. Adding amounts in different
+// currencies is a programming error and panics.
+func (m Money) Add(other Money) Money {
+	m.mustMatch(other)
+	return New(m.Cents+other.Cents, m.currency())
+}
+
+// Sub subtracts an amount in the same currency, panicking like Add when the
+// currencies differ.
+func (m Money) Sub(other Money) Money {
+	m.mustMatch(other)
+	return New(m.Cents-other.Cents, m.currency())
+}
+
+func (m Money) mustMatch(other Money) {
+	if m.currency() != other.currency() {
+		panic(fmt.Sprintf("money: %s and %s amounts can't be combined", m.currency(), other.currency()))
+	}
+}
+
+// Convert changes the amount into another currency, where rate is how much
+// one unit of the amount's currency is worth in that currency. The result is
+// rounded half away from zero to the other currency's minor unit.
+func (m Money) Convert(currency string, rate *big.Rat) Money {
+	converted := new(big.Rat).Mul(m.Rat(), rate)
+	return New(Round(converted.Mul(converted, new(big.Rat).SetInt(scale(currency)))), currency)
+}
+
+// In reports whether the amount is in currency.
+func (m Money) In(currency string) bool {
+	return m.currency() == currency
+}
+
+func (m Money) IsZero() bool {
+	return m.Cents == 0
+}
+
+// Rat is the amount in whole currency units.
+func (m Money) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(m.Cents), scale(m.currency()))
+}
+
+// String formats the amount with the currency's decimals, e.g. "12.50".
+func (m Money) String() string {
+	return m.Rat().FloatString(Decimals(m.currency()))
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency()
+	}
+	return m.Currency
+}
+
+// MarshalJSON writes amounts in the default currency as plain numbers and
+// amounts in any other currency as {"amount": 12.50, "currency": "EUR"}.
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.In(DefaultCurrency()) {
+		return []byte(m.String()), nil
+	}
+	return []byte(`{"amount":` + m.String() + `,"currency":` + strconv.Quote(m.Currency) + `}`), nil
+}
+
+// UnmarshalJSON reads a number, or a string holding one, in the default
+// currency, or an {"amount", "currency"} object for any currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		return nil
+	}
+	currency := DefaultCurrency()
+	if strings.HasPrefix(text, "{") {
+		var object struct {
+			Amount   json.RawMessage `json:"amount"`
+			Currency string          `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		if object.Currency != "" {
+			currency = strings.ToUpper(object.Currency)
+		}
+		text = strings.TrimSpace(string(object.Amount))
+	}
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal([]byte(text), &text); err != nil {
+			return err
+		}
+	}
+	parsed, err := Parse(text, currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(bson.D{{Key: "cents", Value: m.Cents}, {Key: "currency", Value: m.currency()}})
+}
+
+// UnmarshalBSONValue reads {cents, currency} documents as well as plain
+// numbers and decimals left by earlier versions, which are in the default
+// currency.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bson.TypeEmbeddedDocument:
+		var stored struct {
+			Cents    interface{} `bson:"cents"`
+			Currency string      `bson:"currency"`
+		}
+		if err := value.Unmarshal(&stored); err != nil {
+			return err
+		}
+		m.Currency = stored.Currency
+		switch cents := stored.Cents.(type) {
+		case int32:
+			m.Cents = int64(cents)
+		case int64:
+			m.Cents = cents
+		case float64:
+			m.Cents = Round(new(big.Rat).SetFloat64(cents))
+		}
+		return nil
+	case bson.TypeDouble:
+		*m = FromFloat(value.Double(), DefaultCurrency())
+	case bson.TypeInt32:
+		*m = New(int64(value.Int32())*scale(DefaultCurrency()).Int64(), DefaultCurrency())
+	case bson.TypeInt64:
+		*m = New(value.Int64()*scale(DefaultCurrency()).Int64(), DefaultCurrency())
+	case bson.TypeDecimal128:
+		exact, ok := new(big.Rat).SetString(value.Decimal128().String())
+		if !ok {
+			return fmt.Errorf("cannot read %s as money", value.Decimal128())
+		}
+		*m = New(Round(exact.Mul(exact, new(big.Rat).SetInt(scale(DefaultCurrency())))), DefaultCurrency())
+	case bson.TypeString:
+		parsed, err := Parse(value.StringValue(), DefaultCurrency())
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case bson.TypeNull, bson.TypeUndefined:
+		*m = Money{}
+	default:
+		return fmt.Errorf("cannot read a BSON %s as money", t)
+	}
+	return nil
+}
+
+// Legacy reports whether a stored value is a plain number left by earlier
+// versions rather than a {cents, currency} document.
+func Legacy(value interface{}) bool {
+	switch value.(type) {
+	case float64, int32, int64, primitive.Decimal128, string:
+		return true
+	}
+	return false
+}
+
+func scale(currency string) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(Decimals(currency))), nil)
+}
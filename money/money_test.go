@@ -0,0 +1,206 @@
+package money
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		currency string
+		want     int64
+		wantErr  error
+	}{
+		{name: "whole", text: "12", currency: "USD", want: 1200},
+		{name: "one decimal", text: "12.5", currency: "USD", want: 1250},
+		{name: "spaces", text: " 7.05 ", currency: "USD", want: 705},
+		{name: "negative", text: "-3.10", currency: "USD", want: -310},
+		{name: "three decimals", text: "12.345", currency: "KWD", want: 12345},
+		{name: "no decimals", text: "1000", currency: "JPY", want: 1000},
+		{name: "too precise", text: "12.345", currency: "USD", wantErr: ErrTooPrecise},
+		{name: "too precise for yen", text: "10.5", currency: "JPY", wantErr: ErrTooPrecise},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text, tt.currency)
+			if err != tt.wantErr {
+				t.Fatalf("Parse(%q, %s) error = %v, want %v", tt.text, tt.currency, err, tt.wantErr)
+			}
+			if err == nil && (got.Cents != tt.want || got.Currency != tt.currency) {
+				t.Errorf("Parse(%q, %s) = %d %s, want %d %s", tt.text, tt.currency, got.Cents, got.Currency, tt.want, tt.currency)
+			}
+		})
+	}
+
+	if _, err := Parse("twelve", "USD"); err == nil || err == ErrTooPrecise {
+		t.Errorf("Parse(twelve) error = %v, want a parse error", err)
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		num, denom int64
+		want       int64
+	}{
+		{num: 4, denom: 1, want: 4},
+		{num: 1, denom: 2, want: 1},
+		{num: -1, denom: 2, want: -1},
+		{num: 5, denom: 2, want: 3},
+		{num: -5, denom: 2, want: -3},
+		{num: 249, denom: 100, want: 2},
+		{num: -249, denom: 100, want: -2},
+		{num: 7, denom: 3, want: 2},
+		{num: -7, denom: 3, want: -2},
+		{num: 8, denom: 3, want: 3},
+	}
+	for _, tt := range tests {
+		if got := Round(big.NewRat(tt.num, tt.denom)); got != tt.want {
+			t.Errorf("Round(%d/%d) = %d, want %d", tt.num, tt.denom, got, tt.want)
+		}
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     int64
+	}{
+		{amount: 0.1 + 0.2, currency: "USD", want: 30},
+		{amount: 1.005, currency: "USD", want: 101},
+		{amount: -1.005, currency: "USD", want: -101},
+		{amount: 19.99, currency: "USD", want: 1999},
+		{amount: 2.5, currency: "JPY", want: 3},
+		{amount: 1.0005, currency: "KWD", want: 1001},
+	}
+	for _, tt := range tests {
+		if got := FromFloat(tt.amount, tt.currency); got.Cents != tt.want {
+			t.Errorf("FromFloat(%v, %s) = %d, want %d", tt.amount, tt.currency, got.Cents, tt.want)
+		}
+	}
+}
+
+func TestAddAndSub(t *testing.T) {
+	SetDefaultCurrency("USD")
+	defer SetDefaultCurrency("")
+
+	if got := New(1250, "USD").Add(New(75, "USD")); got.Cents != 1325 || got.Currency != "USD" {
+		t.Errorf("Add = %d %s, want 1325 USD", got.Cents, got.Currency)
+	}
+	if got := New(1250, "USD").Sub(New(1300, "USD")); got.Cents != -50 {
+		t.Errorf("Sub = %d, want -50", got.Cents)
+	}
+	// an amount without a currency is in the default currency
+	if got := New(100, "").Add(Of(50)); got.Cents != 150 || got.Currency != "USD" {
+		t.Errorf("Add without currency = %d %s, want 150 USD", got.Cents, got.Currency)
+	}
+}
+
+func TestCurrencyMismatchPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func()
+	}{
+		{name: "add", op: func() { New(100, "USD").Add(New(100, "EUR")) }},
+		{name: "sub", op: func() { New(100, "EUR").Sub(New(100, "JPY")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s of different currencies did not panic", tt.name)
+				}
+			}()
+			tt.op()
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		from     Money
+		currency string
+		rate     *big.Rat
+		want     int64
+	}{
+		{from: New(1000, "EUR"), currency: "USD", rate: big.NewRat(10825, 10000), want: 1083},
+		{from: New(-1000, "EUR"), currency: "USD", rate: big.NewRat(10825, 10000), want: -1083},
+		{from: New(1000, "USD"), currency: "JPY", rate: big.NewRat(15050, 100), want: 1505},
+		{from: New(1500, "JPY"), currency: "USD", rate: big.NewRat(1, 150), want: 1000},
+	}
+	for _, tt := range tests {
+		got := tt.from.Convert(tt.currency, tt.rate)
+		if got.Cents != tt.want || got.Currency != tt.currency {
+			t.Errorf("%s %s.Convert(%s, %s) = %d %s, want %d %s", tt.from, tt.from.Currency, tt.currency, tt.rate.RatString(), got.Cents, got.Currency, tt.want, tt.currency)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		amount Money
+		want   string
+	}{
+		{amount: New(1250, "USD"), want: "12.50"},
+		{amount: New(-310, "USD"), want: "-3.10"},
+		{amount: New(5, "KWD"), want: "0.005"},
+		{amount: New(500, "JPY"), want: "500"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("String() of %d %s = %q, want %q", tt.amount.Cents, tt.amount.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	SetDefaultCurrency("USD")
+	defer SetDefaultCurrency("")
+
+	marshal := []struct {
+		amount Money
+		want   string
+	}{
+		{amount: New(1250, "USD"), want: `12.50`},
+		{amount: New(1250, "EUR"), want: `{"amount":12.50,"currency":"EUR"}`},
+	}
+	for _, tt := range marshal {
+		got, err := json.Marshal(tt.amount)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Marshal(%d %s) = %s, %v, want %s", tt.amount.Cents, tt.amount.Currency, got, err, tt.want)
+		}
+	}
+
+	unmarshal := []struct {
+		data     string
+		want     int64
+		currency string
+		wantErr  bool
+	}{
+		{data: `12.5`, want: 1250, currency: "USD"},
+		{data: `"12.5"`, want: 1250, currency: "USD"},
+		{data: `{"amount": 3, "currency": "jpy"}`, want: 3, currency: "JPY"},
+		{data: `{"amount": "0.125", "currency": "KWD"}`, want: 125, currency: "KWD"},
+		{data: `12.345`, wantErr: true},
+		{data: `{"amount": 1.5, "currency": "JPY"}`, wantErr: true},
+	}
+	for _, tt := range unmarshal {
+		var got Money
+		err := json.Unmarshal([]byte(tt.data), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (got.Cents != tt.want || got.Currency != tt.currency) {
+			t.Errorf("Unmarshal(%s) = %d %s, want %d %s", tt.data, got.Cents, got.Currency, tt.want, tt.currency)
+		}
+	}
+
+	got := New(42, "USD")
+	if err := json.Unmarshal([]byte(`null`), &got); err != nil || got.Cents != 42 {
+		t.Errorf("Unmarshal(null) = %d, %v, want the amount left alone", got.Cents, err)
+	}
+}
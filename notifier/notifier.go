@@ -0,0 +1,87 @@
+// Package notifier sends payment reminders for overdue invoices. Notifiers
+// are registered by name and the one used is picked with NOTIFIER.
+package notifier
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"atm1504.in/rms/money"
+)
+
+var ErrUnknownNotifier = errors.New("unknown notifier")
+
+// Reminder asks a customer to pay an overdue invoice. Stage counts the
+// reminders sent for the invoice, this one included.
+type Reminder struct {
+	InvoiceID     string      `json:"invoice_id"`
+	InvoiceNumber string      `json:"invoice_number,omitempty"`
+	OrderID       string      `json:"order_id"`
+	CustomerName  string      `json:"customer_name,omitempty"`
+	CustomerPhone string      `json:"customer_phone,omitempty"`
+	BillingEmail  string      `json:"billing_email,omitempty"`
+	Balance       money.Money `json:"balance"`
+	DueDate       time.Time   `json:"payment_due_date"`
+	DaysOverdue   int         `json:"days_overdue"`
+	Stage         int         `json:"stage"`
+}
+
+// Notifier delivers reminders to customers.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, reminder Reminder) error
+}
+
+var (
+	mu        sync.RWMutex
+	notifiers = map[string]Notifier{}
+)
+
+// Register makes a notifier available under its name.
+func Register(notifier Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	notifiers[notifier.Name()] = notifier
+}
+
+func Get(name string) (Notifier, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	notifier, ok := notifiers[name]
+	if !ok {
+		return nil, ErrUnknownNotifier
+	}
+	return notifier, nil
+}
+
+// Default returns the notifier named by NOTIFIER, the log notifier when it
+// is not set.
+func Default() (Notifier, error) {
+	name := os.Getenv("NOTIFIER")
+	if name == "" {
+		name = "log"
+	}
+	return Get(name)
+}
+
+// LogNotifier writes reminders to the server log instead of sending them.
+type LogNotifier struct{}
+
+func (LogNotifier) Name() string {
+	return "log"
+}
+
+func (LogNotifier) Notify(ctx context.Context, reminder Reminder) error {
+	log.Printf("notifier: reminder %d for invoice %s, %s due since %s (%d days overdue)",
+		reminder.Stage, reminder.InvoiceID, reminder.Balance, reminder.DueDate.Format("2006-01-02"), reminder.DaysOverdue)
+	return nil
+}
+
+func init() {
+	Register(LogNotifier{})
+	Register(WebhookNotifier{})
+}
@@ -0,0 +1,52 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// WebhookNotifier posts reminders as JSON to NOTIFIER_WEBHOOK_URL, for an
+// email or SMS service to deliver. NOTIFIER_WEBHOOK_TOKEN, when set, is sent
+// as a bearer token.
+type WebhookNotifier struct{}
+
+func (WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (WebhookNotifier) Notify(ctx context.Context, reminder Reminder) error {
+	url := os.Getenv("NOTIFIER_WEBHOOK_URL")
+	if url == "" {
+		return errors.New("NOTIFIER_WEBHOOK_URL is not set")
+	}
+	payload, err := json.Marshal(reminder)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("NOTIFIER_WEBHOOK_TOKEN"); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint answered %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package printer turns kitchen tickets and receipts into ESC/POS byte streams
+// for 80mm thermal printers and sends them to named printers.
+package printer
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// Columns is the number of characters on a line in the printer's default
+// font on an 80mm roll.
+const Columns = 48
+
+const (
+	esc = 0x1b
+	gs  = 0x1d
+)
+
+type Alignment byte
+
+const (
+	AlignLeft Alignment = iota
+	AlignCenter
+	AlignRight
+)
+
+// Document builds an ESC/POS byte stream. Text is sent in the Windows-1252
+// code page; characters it doesn't have are printed as "?".
+type Document struct {
+	buf bytes.Buffer
+}
+
+// NewDocument starts a document that resets the printer and selects the
+// Windows-1252 code page.
+func NewDocument() *Document {
+	d := &Document{}
+	d.buf.Write([]byte{esc, '@'})
+	d.buf.Write([]byte{esc, 't', 16})
+	return d
+}
+
+func (d *Document) Align(alignment Alignment) *Document {
+	d.buf.Write([]byte{esc, 'a', byte(alignment)})
+	return d
+}
+
+func (d *Document) Bold(on bool) *Document {
+	d.buf.Write([]byte{esc, 'E', flag(on)})
+	return d
+}
+
+// Invert prints white on black, used to make allergy alerts stand out.
+func (d *Document) Invert(on bool) *Document {
+	d.buf.Write([]byte{gs, 'B', flag(on)})
+	return d
+}
+
+// Size scales the characters that follow, 1 to 8 times in each direction.
+func (d *Document) Size(width int, height int) *Document {
+	d.buf.Write([]byte{gs, '!', byte((clamp(width)-1)<<4 | (clamp(height) - 1))})
+	return d
+}
+
+func (d *Document) Text(text string) *Document {
+	for _, r := range text {
+		d.buf.WriteByte(cp1252(r))
+	}
+	return d
+}
+
+func (d *Document) Line(text string) *Document {
+	return d.Text(text).Text("\n")
+}
+
+// Row prints left and right on one line, cutting left short when both don't
+// fit in columns.
+func (d *Document) Row(left string, right string, columns int) *Document {
+	space := columns - utf8.RuneCountInString(right) - 1
+	if space < 0 {
+		space = 0
+	}
+	if utf8.RuneCountInString(left) > space {
+		left = string([]rune(left)[:space])
+	}
+	padding := columns - utf8.RuneCountInString(left) - utf8.RuneCountInString(right)
+	if padding < 1 {
+		padding = 1
+	}
+	return d.Line(left + strings.Repeat(" ", padding) + right)
+}
+
+func (d *Document) Rule(columns int) *Document {
+	return d.Line(strings.Repeat("-", columns))
+}
+
+func (d *Document) Feed(lines int) *Document {
+	d.buf.Write([]byte{esc, 'd', byte(lines)})
+	return d
+}
+
+// Cut feeds the paper past the cutter and makes a partial cut.
+func (d *Document) Cut() *Document {
+	d.buf.Write([]byte{gs, 'V', 66, 0})
+	return d
+}
+
+func (d *Document) Bytes() []byte {
+	return d.buf.Bytes()
+}
+
+func flag(on bool) byte {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+func clamp(scale int) int {
+	if scale < 1 {
+		return 1
+	}
+	if scale > 8 {
+		return 8
+	}
+	return scale
+}
+
+// cp1252 maps a character to Windows-1252, which matches Latin-1 apart from
+// the 0x80-0x9f block.
+func cp1252(r rune) byte {
+	switch {
+	case r == '\n' || (r >= 32 && r < 127) || (r >= 160 && r <= 255):
+		return byte(r)
+	case r == '€':
+		return 0x80
+	case r == '‘' || r == '’':
+		return '\''
+	case r == '“' || r == '”':
+		return '"'
+	case r == '–' || r == '—':
+		return '-'
+	}
+	return '?'
+}
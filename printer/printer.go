@@ -0,0 +1,196 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of print job, each routed to its own printer.
+const (
+	KitchenTickets = "kitchen"
+	Receipts       = "receipt"
+)
+
+var ErrUnknownPrinter = errors.New("unknown printer")
+
+// Printer is somewhere ESC/POS jobs can be sent.
+type Printer interface {
+	Print(ctx context.Context, job []byte) error
+}
+
+// NetworkPrinter sends jobs over raw TCP, port 9100 unless the address says
+// otherwise.
+type NetworkPrinter struct {
+	Address string
+}
+
+func (p NetworkPrinter) Print(ctx context.Context, job []byte) error {
+	address := p.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "9100")
+	}
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write(job)
+	return err
+}
+
+// SpoolPrinter writes each job to its own file in a directory watched by a
+// print spooler. Files are written under a temporary name and renamed once
+// complete, so the spooler never picks up half a job.
+type SpoolPrinter struct {
+	Dir string
+}
+
+var spoolSequence struct {
+	sync.Mutex
+	n int
+}
+
+func (p SpoolPrinter) Print(ctx context.Context, job []byte) error {
+	if err := os.MkdirAll(p.Dir, 0o755); err != nil {
+		return err
+	}
+	spoolSequence.Lock()
+	spoolSequence.n++
+	name := fmt.Sprintf("%s-%06d.prn", time.Now().Format("20060102T150405"), spoolSequence.n)
+	spoolSequence.Unlock()
+
+	path := filepath.Join(p.Dir, name)
+	if err := os.WriteFile(path+".tmp", job, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// FakePrinter keeps jobs in memory instead of printing them.
+type FakePrinter struct {
+	mu   sync.Mutex
+	jobs [][]byte
+}
+
+func (p *FakePrinter) Print(ctx context.Context, job []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs = append(p.jobs, append([]byte(nil), job...))
+	return nil
+}
+
+// Jobs returns the jobs printed so far, oldest first.
+func (p *FakePrinter) Jobs() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.jobs...)
+}
+
+func (p *FakePrinter) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs = nil
+}
+
+var (
+	mu       sync.RWMutex
+	printers = map[string]Printer{}
+	loadOnce sync.Once
+)
+
+// Register makes a printer available under a name, replacing any printer
+// configured under it.
+func Register(name string, printer Printer) {
+	loadOnce.Do(load)
+	mu.Lock()
+	defer mu.Unlock()
+	printers[name] = printer
+}
+
+func Get(name string) (Printer, error) {
+	loadOnce.Do(load)
+	mu.RLock()
+	defer mu.RUnlock()
+	printer, ok := printers[name]
+	if !ok {
+		return nil, ErrUnknownPrinter
+	}
+	return printer, nil
+}
+
+func Names() []string {
+	loadOnce.Do(load)
+	mu.RLock()
+	defer mu.RUnlock()
+	names := []string{}
+	for name := range printers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Route returns the name of the printer a kind of job goes to: the
+// KITCHEN_PRINTER or RECEIPT_PRINTER setting, or the kind itself.
+func Route(kind string) string {
+	if name := os.Getenv(strings.ToUpper(kind) + "_PRINTER"); name != "" {
+		return name
+	}
+	return kind
+}
+
+// load sets up the printers listed in PRINTERS, a comma separated list of
+// name=destination pairs such as
+//
+//	kitchen=tcp://192.168.1.50:9100,bar=spool:///var/spool/rms/bar,receipt=fake:
+//
+// It runs on first use, after the environment has been loaded.
+func load() {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, entry := range strings.Split(os.Getenv("PRINTERS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, destination, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Printf("printer: ignoring %q, expected name=destination", entry)
+			continue
+		}
+		printer, err := parseDestination(destination)
+		if err != nil {
+			log.Printf("printer: ignoring %s: %v", name, err)
+			continue
+		}
+		printers[strings.TrimSpace(name)] = printer
+	}
+}
+
+func parseDestination(destination string) (Printer, error) {
+	location, err := url.Parse(strings.TrimSpace(destination))
+	if err != nil {
+		return nil, err
+	}
+	switch location.Scheme {
+	case "tcp":
+		return NetworkPrinter{Address: location.Host}, nil
+	case "spool":
+		return SpoolPrinter{Dir: location.Path}, nil
+	case "fake":
+		return &FakePrinter{}, nil
+	}
+	return nil, fmt.Errorf("unsupported destination %q", destination)
+}
@@ -0,0 +1,107 @@
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"atm1504.in/rms/receipt"
+)
+
+// KitchenTicket is what the kitchen needs to prepare an order, already worked
+// out.
+type KitchenTicket struct {
+	Heading       string
+	Details       []string
+	AllergyAlerts []string
+	Notes         []string
+	Items         []KitchenItem
+	PrintedAt     time.Time
+}
+
+type KitchenItem struct {
+	Name     string
+	Quantity string
+	Course   int
+	Seat     *int
+	Hold     bool
+	Status   string
+	// Modifiers are printed under the item and Warnings, the allergens in
+	// it the guests declared, inverted.
+	Modifiers []string
+	Warnings  []string
+	Notes     []string
+}
+
+// RenderKitchenTicket lays a kitchen ticket out for a thermal printer: the
+// table or order type in large print, allergy alerts inverted at the top,
+// then the items grouped by course.
+func RenderKitchenTicket(ticket KitchenTicket) []byte {
+	d := NewDocument()
+	d.Align(AlignCenter).Bold(true).Size(2, 2).Line(ticket.Heading).Size(1, 1).Bold(false)
+	for _, detail := range ticket.Details {
+		d.Line(detail)
+	}
+	d.Line(ticket.PrintedAt.Format("02 Jan 15:04")).Align(AlignLeft)
+
+	if len(ticket.AllergyAlerts) > 0 {
+		d.Rule(Columns).Bold(true).Invert(true)
+		for _, alert := range ticket.AllergyAlerts {
+			d.Line(" ALLERGY: " + alert + " ")
+		}
+		d.Invert(false).Bold(false)
+	}
+	for _, note := range ticket.Notes {
+		d.Line("NOTE: " + note)
+	}
+
+	course := 0
+	for _, item := range ticket.Items {
+		if item.Course != course {
+			course = item.Course
+			d.Rule(Columns).Bold(true).Line(fmt.Sprintf("COURSE %d", course)).Bold(false)
+		}
+		name := fmt.Sprintf("%s (%s)", item.Name, item.Quantity)
+		if item.Status != "" && item.Status != "ACTIVE" {
+			name = item.Status + " " + name
+		}
+		d.Bold(true).Size(1, 2).Line(name).Size(1, 1).Bold(false)
+		var flags []string
+		if item.Seat != nil {
+			flags = append(flags, fmt.Sprintf("seat %d", *item.Seat))
+		}
+		if item.Hold {
+			flags = append(flags, "HOLD")
+		}
+		if len(flags) > 0 {
+			d.Line("   " + strings.Join(flags, ", "))
+		}
+		for _, modifier := range item.Modifiers {
+			d.Line("   + " + modifier)
+		}
+		if len(item.Warnings) > 0 {
+			d.Bold(true).Invert(true).Line(" CONTAINS " + strings.Join(item.Warnings, ", ") + " ").Invert(false).Bold(false)
+		}
+		for _, note := range item.Notes {
+			d.Line("   * " + note)
+		}
+	}
+	return d.Rule(Columns).Feed(3).Cut().Bytes()
+}
+
+// RenderReceipt prints the text receipt with the restaurant's name in large
+// print.
+func RenderReceipt(document receipt.Receipt) ([]byte, error) {
+	d := NewDocument()
+	if len(document.Header) > 0 {
+		d.Align(AlignCenter).Bold(true).Size(2, 2).Line(document.Header[0]).Size(1, 1).Bold(false).Align(AlignLeft)
+		document.Header = document.Header[1:]
+	}
+	var text bytes.Buffer
+	if err := receipt.Text(&text, document); err != nil {
+		return nil, err
+	}
+	d.Text(text.String())
+	return d.Feed(3).Cut().Bytes(), nil
+}
@@ -0,0 +1,79 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The PDF is a single page the width of an 80mm roll and as long as the
+// receipt, set in Courier so the text layout carries over unchanged.
+const (
+	pdfFontSize = 8.0
+	pdfLeading  = 10.0
+	pdfMargin   = 12.0
+	pdfWidth    = 226.8
+)
+
+// PDF writes the text receipt as a PDF document.
+func PDF(w io.Writer, receipt Receipt) error {
+	var text bytes.Buffer
+	if err := Text(&text, receipt); err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(text.String(), "\n"), "\n")
+	height := 2*pdfMargin + float64(len(lines))*pdfLeading
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "BT\n/F1 %.1f Tf\n%.1f TL\n%.1f %.1f Td\n", pdfFontSize, pdfLeading, pdfMargin, height-pdfMargin-pdfFontSize)
+	for _, line := range lines {
+		fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.1f %.1f] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>", pdfWidth, height),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var document bytes.Buffer
+	document.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = document.Len()
+		fmt.Fprintf(&document, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := document.Len()
+	fmt.Fprintf(&document, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&document, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&document, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(document.Bytes())
+	return err
+}
+
+// pdfString escapes text for a PDF string literal. Characters outside
+// Latin-1 can't be set in the standard fonts and are replaced with "?".
+func pdfString(text string) string {
+	var out strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r < 32 || r > 255:
+			out.WriteByte('?')
+		case r < 128:
+			out.WriteRune(r)
+		default:
+			fmt.Fprintf(&out, "\\%03o", r)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,135 @@
+// Package receipt renders customer receipts as plain text, HTML and PDF from
+// the templates in templates/.
+package receipt
+
+import (
+	"embed"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+	"time"
+	"unicode/utf8"
+
+	"atm1504.in/rms/money"
+)
+
+// Width is the number of characters on a line of the text receipt, which
+// fits an 80mm roll.
+const Width = 42
+
+// Receipt is everything printed on a receipt, already worked out.
+type Receipt struct {
+	Header         []string
+	Title          string
+	Details        []Field
+	Items          []Item
+	Subtotal       money.Money
+	Discounts      []Amount
+	SharedAmount   money.Money
+	ServiceCharges []Amount
+	Taxes          []Amount
+	DeliveryFee    money.Money
+	Total          money.Money
+	Payments       []Payment
+	TipTotal       money.Money
+	AmountPaid     money.Money
+	RefundedTotal  money.Money
+	Balance        money.Money
+	Footer         []string
+	PrintedAt      time.Time
+}
+
+type Field struct {
+	Label string
+	Value string
+}
+
+type Item struct {
+	Name      string
+	Quantity  string // portion size: S, M or L
+	UnitPrice money.Money
+	Amount    money.Money
+	Seat      *int
+}
+
+// Amount is a labelled line of the totals. Included amounts, such as taxes
+// already in the menu prices, are shown but not added to the total.
+type Amount struct {
+	Label    string
+	Amount   money.Money
+	Included bool
+}
+
+type Payment struct {
+	Method    string
+	Reference string
+	Amount    money.Money
+	Tip       money.Money
+	Tendered  money.Money
+	Change    money.Money
+	Status    string
+	// Foreign is cash tendered in another currency at Rate.
+	Foreign money.Money
+	Rate    string
+}
+
+var funcs = map[string]any{
+	"money":  formatMoney,
+	"row":    row,
+	"center": center,
+	"rule":   func() string { return strings.Repeat("-", Width) },
+	"date":   func(t time.Time) string { return t.Format("02 Jan 2006 15:04") },
+}
+
+//go:embed templates/*
+var templateFS embed.FS
+
+var (
+	textTemplate = texttemplate.Must(texttemplate.New("receipt.txt.tmpl").Funcs(funcs).ParseFS(templateFS, "templates/receipt.txt.tmpl"))
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("receipt.html.tmpl").Funcs(funcs).ParseFS(templateFS, "templates/receipt.html.tmpl"))
+)
+
+// Text writes the receipt as plain text, Width characters wide.
+func Text(w io.Writer, receipt Receipt) error {
+	return textTemplate.Execute(w, receipt)
+}
+
+// HTML writes the receipt as a standalone HTML page.
+func HTML(w io.Writer, receipt Receipt) error {
+	return htmlTemplate.Execute(w, receipt)
+}
+
+// formatMoney writes amounts in the default currency as plain numbers and
+// others after their currency code.
+func formatMoney(amount money.Money) string {
+	if !amount.In(money.DefaultCurrency()) {
+		return amount.Currency + " " + amount.String()
+	}
+	return amount.String()
+}
+
+// row puts left and right on one line, cutting left short when both don't
+// fit.
+func row(left string, right string) string {
+	space := Width - utf8.RuneCountInString(right) - 1
+	if space < 0 {
+		space = 0
+	}
+	if utf8.RuneCountInString(left) > space {
+		left = string([]rune(left)[:space])
+	}
+	padding := Width - utf8.RuneCountInString(left) - utf8.RuneCountInString(right)
+	if padding < 1 {
+		padding = 1
+	}
+	return left + strings.Repeat(" ", padding) + right
+}
+
+func center(text string) string {
+	length := utf8.RuneCountInString(text)
+	if length >= Width {
+		return text
+	}
+	return strings.Repeat(" ", (Width-length)/2) + text
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func CategoryRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/categories", controller.GetCategories())
+	incomingRoutes.GET("/categories/:category_id", controller.GetCategory())
+	incomingRoutes.POST("/categories", controller.CreateCategory())
+	incomingRoutes.PATCH("/categories/:category_id", controller.UpdateCategory())
+	incomingRoutes.DELETE("/categories/:category_id", controller.DeleteCategory())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func ExchangeRateRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/exchangeRates", controller.GetExchangeRates())
+	incomingRoutes.GET("/exchangeRates/:currency", controller.GetExchangeRate())
+	incomingRoutes.PUT("/exchangeRates/:currency", middleware.Authentication(), controller.SetExchangeRate())
+	incomingRoutes.DELETE("/exchangeRates/:currency", middleware.Authentication(), controller.DeleteExchangeRate())
+}
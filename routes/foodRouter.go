@@ -10,4 +10,6 @@ func FoodRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/foods/:food_id", controller.GetFood())
 	incomingRoutes.POST("/foods", controller.CreateFood())
 	incomingRoutes.PATCH("/foods/:food_id", controller.UpdateFood())
+	incomingRoutes.POST("/foods/:food_id/image", controller.UploadFoodImage())
+	incomingRoutes.GET("/images/*key", controller.ServeImage())
 }
@@ -2,6 +2,7 @@ package routes
 
 import (
 	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,5 +10,8 @@ func InvoiceRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/invoices", controller.GetInvoices())
 	incomingRoutes.GET("/invoices/:invoice_id", controller.GetInvoice())
 	incomingRoutes.POST("/invoices", controller.CreateInvoice())
-	incomingRoutes.PATCH("/invoices/:invoice_id", controller.UpdateInvoice())
+	incomingRoutes.PATCH("/invoices/:invoice_id", middleware.Authentication(), controller.UpdateInvoice())
+	incomingRoutes.POST("/invoices/:invoice_id/coupons", middleware.Authentication(), controller.ApplyCoupon())
+	incomingRoutes.DELETE("/invoices/:invoice_id/coupons/:coupon_code", middleware.Authentication(), controller.RemoveCoupon())
+	incomingRoutes.GET("/invoices/:invoice_id/receipt", controller.GetInvoiceReceipt())
 }
@@ -2,12 +2,19 @@ package routes
 
 import (
 	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 func MenuRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/menus", controller.GetMenus())
+	incomingRoutes.GET("/menus/active", controller.GetActiveMenus())
 	incomingRoutes.GET("/menus/:menu_id", controller.GetMenu())
 	incomingRoutes.POST("/menus", controller.CreateMenu())
 	incomingRoutes.PATCH("/menus/:menu_id", controller.UpdateMenu())
+	incomingRoutes.POST("/menus/:menu_id/publish", middleware.Authentication(), controller.PublishMenu())
+	incomingRoutes.DELETE("/menus/:menu_id/draft", middleware.Authentication(), controller.DiscardMenuDraft())
+	incomingRoutes.GET("/menus/:menu_id/tree", controller.GetMenuTree())
+	incomingRoutes.GET("/menus/:menu_id/versions", controller.GetMenuVersions())
+	incomingRoutes.POST("/menus/:menu_id/versions/:version/rollback", middleware.Authentication(), controller.RollbackMenu())
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func NoteRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/notes", controller.GetNotes())
+	incomingRoutes.GET("/notes/:note_id", controller.GetNote())
+	incomingRoutes.POST("/notes", middleware.Authentication(), controller.CreateNote())
+	incomingRoutes.PATCH("/notes/:note_id", middleware.Authentication(), controller.UpdateNote())
+	incomingRoutes.DELETE("/notes/:note_id", middleware.Authentication(), controller.DeleteNote())
+}
@@ -2,14 +2,17 @@ package routes
 
 import (
 	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func OrderItemRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/orderItems", controller.GetOrderItems())
-	incomingRoutes.GET("/orderItems/:orderItem_id", controller.GetOrderItem())
+	incomingRoutes.GET("/orderItems/:order_item_id", controller.GetOrderItem())
 	incomingRoutes.GET("/orderItems-order/:order_id", controller.GetOrderItemsByOrder())
 	incomingRoutes.POST("/orderItems", controller.CreateOrderItem())
-	incomingRoutes.PATCH("/orderItems/:orderItem_id", controller.UpdateOrderItem())
+	incomingRoutes.PATCH("/orderItems/:order_item_id", controller.UpdateOrderItem())
+	incomingRoutes.POST("/orderItems/:order_item_id/void", middleware.Authentication(), controller.VoidOrderItem())
+	incomingRoutes.POST("/orderItems/:order_item_id/comp", middleware.Authentication(), controller.CompOrderItem())
 }
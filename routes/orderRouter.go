@@ -2,6 +2,7 @@ package routes
 
 import (
 	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
 	"github.com/gin-gonic/gin"
 )
 
@@ -10,4 +11,12 @@ func OrderRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/orders/:order_id", controller.GetOrder())
 	incomingRoutes.POST("/orders", controller.CreateOrder())
 	incomingRoutes.PATCH("/orders/:order_id", controller.UpdateOrder())
+	incomingRoutes.GET("/orders/:order_id/kitchen-ticket", controller.GetKitchenTicket())
+	incomingRoutes.POST("/orders/:order_id/cancel", middleware.Authentication(), controller.CancelOrder())
+	incomingRoutes.POST("/orders/:order_id/fire", controller.FireCourse())
+	incomingRoutes.POST("/orders/:order_id/items", middleware.Authentication(), controller.AddOrderItems())
+	incomingRoutes.POST("/orders/:order_id/transfer", middleware.Authentication(), controller.TransferOrderItems())
+	incomingRoutes.POST("/orders/:order_id/merge", middleware.Authentication(), controller.MergeOrders())
+	incomingRoutes.POST("/orders/:order_id/split", middleware.Authentication(), controller.SplitOrder())
+	incomingRoutes.GET("/orders/:order_id/audit", controller.GetOrderAudit())
 }
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func PaymentRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/invoices/:invoice_id/payments", controller.GetInvoicePayments())
+	incomingRoutes.POST("/invoices/:invoice_id/payments", middleware.Authentication(), controller.CreatePayment())
+	incomingRoutes.GET("/payments/:payment_id", controller.GetPayment())
+	incomingRoutes.POST("/payments/:payment_id/capture", middleware.Authentication(), controller.CapturePayment())
+	incomingRoutes.POST("/payments/:payment_id/void", middleware.Authentication(), controller.VoidPayment())
+	incomingRoutes.POST("/webhooks/payments/:provider", controller.PaymentWebhook())
+	incomingRoutes.GET("/reports/tips", middleware.Authentication(), controller.GetTipReport())
+	incomingRoutes.GET("/invoices/:invoice_id/creditNotes", controller.GetInvoiceCreditNotes())
+	incomingRoutes.POST("/invoices/:invoice_id/refunds", middleware.Authentication(), controller.RefundInvoice())
+	incomingRoutes.GET("/creditNotes/:credit_note_id", controller.GetCreditNote())
+}
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func PrinterRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/printers", controller.GetPrinters())
+	incomingRoutes.POST("/orders/:order_id/kitchen-ticket/print", controller.PrintKitchenTicket())
+	incomingRoutes.POST("/invoices/:invoice_id/receipt/print", controller.PrintInvoiceReceipt())
+}
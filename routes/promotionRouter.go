@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func PromotionRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/promotions", controller.GetPromotions())
+	incomingRoutes.GET("/promotions/:promotion_id", controller.GetPromotion())
+	incomingRoutes.POST("/promotions", middleware.Authentication(), controller.CreatePromotion())
+	incomingRoutes.PATCH("/promotions/:promotion_id", middleware.Authentication(), controller.UpdatePromotion())
+}
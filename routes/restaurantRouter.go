@@ -0,0 +1,12 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func RestaurantRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/restaurant", controller.GetRestaurant())
+	incomingRoutes.PATCH("/restaurant", middleware.Authentication(), controller.UpdateRestaurant())
+}
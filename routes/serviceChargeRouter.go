@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func ServiceChargeRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/serviceCharges", controller.GetServiceCharges())
+	incomingRoutes.GET("/serviceCharges/:service_charge_id", controller.GetServiceCharge())
+	incomingRoutes.POST("/serviceCharges", middleware.Authentication(), controller.CreateServiceCharge())
+	incomingRoutes.PATCH("/serviceCharges/:service_charge_id", middleware.Authentication(), controller.UpdateServiceCharge())
+}
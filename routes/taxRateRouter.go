@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func TaxRateRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/taxRates", controller.GetTaxRates())
+	incomingRoutes.GET("/taxRates/:tax_rate_id", controller.GetTaxRate())
+	incomingRoutes.POST("/taxRates", middleware.Authentication(), controller.CreateTaxRate())
+	incomingRoutes.PATCH("/taxRates/:tax_rate_id", middleware.Authentication(), controller.UpdateTaxRate())
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	controller "atm1504.in/rms/controllers"
+	"atm1504.in/rms/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,4 +12,5 @@ func UserRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/users/:user_id", controller.GetUser())
 	incomingRoutes.POST("/users/signup", controller.SignUp())
 	incomingRoutes.POST("/users/login", controller.Login())
+	incomingRoutes.PATCH("/users/:user_id/role", middleware.Authentication(), controller.UpdateUserRole())
 }
@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Job is a background task that the scheduler runs every Interval.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Start runs every job in its own goroutine until ctx is cancelled. A job run
+// gets its own timeout so a slow database call can't pile up ticks.
+func Start(ctx context.Context, jobs ...Job) {
+	for _, job := range jobs {
+		go run(ctx, job)
+	}
+}
+
+func run(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCtx, cancel := context.WithTimeout(ctx, job.Interval)
+			if err := job.Run(runCtx); err != nil {
+				log.Printf("scheduler: %s failed: %v", job.Name, err)
+			}
+			cancel()
+		}
+	}
+}
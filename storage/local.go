@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// LocalStorage keeps objects as files under IMAGE_DIR, ./uploads by
+// default. The content type is worked out from the file extension.
+type LocalStorage struct{}
+
+func (LocalStorage) Name() string {
+	return "local"
+}
+
+func (s LocalStorage) Put(ctx context.Context, key string, object Object) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	// written aside and renamed so a half written file is never served
+	temp := file + ".tmp"
+	if err := os.WriteFile(temp, object.Data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, file)
+}
+
+func (s LocalStorage) Get(ctx context.Context, key string) (Object, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Data: data, ContentType: mime.TypeByExtension(path.Ext(key))}, nil
+}
+
+func (s LocalStorage) Delete(ctx context.Context, key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (LocalStorage) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	dir := os.Getenv("IMAGE_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	return filepath.Join(dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps objects in a bucket of an S3-compatible service such as
+// AWS S3 or MinIO. It is set up with S3_ENDPOINT, S3_BUCKET, S3_REGION
+// (us-east-1 by default), S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY, and
+// addresses the bucket by path so any endpoint works.
+type S3Storage struct{}
+
+func (S3Storage) Name() string {
+	return "s3"
+}
+
+func (s S3Storage) Put(ctx context.Context, key string, object Object) error {
+	response, err := s.do(ctx, http.MethodPut, key, object)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("s3 answered %s", response.Status)
+	}
+	return nil
+}
+
+func (s S3Storage) Get(ctx context.Context, key string) (Object, error) {
+	response, err := s.do(ctx, http.MethodGet, key, Object{})
+	if err != nil {
+		return Object{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return Object{}, ErrNotFound
+	}
+	if response.StatusCode >= 300 {
+		return Object{}, fmt.Errorf("s3 answered %s", response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Data: data, ContentType: response.Header.Get("Content-Type")}, nil
+}
+
+func (s S3Storage) Delete(ctx context.Context, key string) error {
+	response, err := s.do(ctx, http.MethodDelete, key, Object{})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 && response.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3 answered %s", response.Status)
+	}
+	return nil
+}
+
+func (S3Storage) do(ctx context.Context, method string, key string, object Object) (*http.Response, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, bucket := os.Getenv("S3_ENDPOINT"), os.Getenv("S3_BUCKET")
+	accessKey, secretKey := os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY")
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set")
+	}
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	segments := []string{url.PathEscape(bucket)}
+	for _, segment := range strings.Split(key, "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+	target := *base
+	target.RawPath = strings.TrimSuffix(base.Path, "/") + "/" + strings.Join(segments, "/")
+	target.Path, _ = url.PathUnescape(target.RawPath)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(object.Data))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if object.ContentType != "" {
+		request.Header.Set("Content-Type", object.ContentType)
+	}
+	signV4(request, object.Data, region, accessKey, secretKey, time.Now().UTC())
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = cancelOnClose{response.Body, cancel}
+	return response, nil
+}
+
+// cancelOnClose ends a request's context once its body has been read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// signV4 signs a request with AWS Signature Version 4, which S3-compatible
+// services accept.
+func signV4(request *http.Request, payload []byte, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + request.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
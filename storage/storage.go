@@ -0,0 +1,80 @@
+// Package storage keeps uploaded files such as food images. Stores are
+// registered by name and the one used is picked with IMAGE_STORAGE.
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownStorage = errors.New("unknown storage")
+	ErrNotFound       = errors.New("object not found")
+	ErrInvalidKey     = errors.New("invalid object key")
+)
+
+// Object is a stored file with its content type.
+type Object struct {
+	Data        []byte
+	ContentType string
+}
+
+// Storage keeps objects under slash separated keys such as
+// foods/65f0c3/1a2b3c.jpg.
+type Storage interface {
+	Name() string
+	Put(ctx context.Context, key string, object Object) error
+	Get(ctx context.Context, key string) (Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	mu     sync.RWMutex
+	stores = map[string]Storage{}
+)
+
+// Register makes a store available under its name.
+func Register(storage Storage) {
+	mu.Lock()
+	defer mu.Unlock()
+	stores[storage.Name()] = storage
+}
+
+func Get(name string) (Storage, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	storage, ok := stores[name]
+	if !ok {
+		return nil, ErrUnknownStorage
+	}
+	return storage, nil
+}
+
+// Default returns the store named by IMAGE_STORAGE, local disk when it is
+// not set.
+func Default() (Storage, error) {
+	name := os.Getenv("IMAGE_STORAGE")
+	if name == "" {
+		name = "local"
+	}
+	return Get(name)
+}
+
+// cleanKey checks that a key stays inside the store, so a key taken from a
+// request can't reach other files.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(key, "/"))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") || strings.Contains(cleaned, "\\") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
+
+func init() {
+	Register(LocalStorage{})
+	Register(S3Storage{})
+}